@@ -2,16 +2,24 @@ package archiver
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"xui_log_archiver/fsys"
+	"xui_log_archiver/logging"
 )
 
 const (
+	// BASE_DIR - директория x-ui, в которой лежат все файлы архиватора по умолчанию
+	BASE_DIR        = "/usr/local/x-ui"
 	LOG_FILE        = "/usr/local/x-ui/access.log"
 	ARCHIVE_DIR     = "/usr/local/x-ui/archives"
 	STATE_FILE      = "/usr/local/x-ui/last_archived_line.txt"
@@ -21,92 +29,345 @@ const (
 
 // Archiver представляет архиватор логов
 type Archiver struct {
-	logFile       string
-	archiveDir    string
-	stateFile     string
-	positionFile  string
-	tempHourlyLog string
-	localLogFile  string
+	logFile        string
+	archiveDir     string
+	stateFile      string
+	positionFile   string
+	tempHourlyLog  string
+	runStateFile   string
+	historyFile    string
+	lockFile       string
+	outboxDir      string
+	period         Period
+	naming         Naming
+	periodStart    time.Time
+	maxPending     int64
+	truncateAbove  int64
+	truncateKeep   int64
+	minFree        int64
+	resumeFree     int64
+	emergencyKeep  time.Duration
+	onAlert        func(Alert)
+	failOnRotation bool
+	clock          Clock
+	codec          Codec
+	fs             fsys.FS
+	log            *slog.Logger
+	logCloser      io.Closer
+	metrics        *Metrics
+}
+
+// Paths - файлы одного экземпляра x-ui, с которыми работает архиватор
+type Paths struct {
+	LogFile       string `json:"access_log"`
+	ArchiveDir    string `json:"archive_dir"`
+	StateFile     string `json:"state_file"`
+	PositionFile  string `json:"position_file"`
+	TempHourlyLog string `json:"temp_log"`
+	RunStateFile  string `json:"run_state_file"`
+	HistoryFile   string `json:"history_file"`
+	LockFile      string `json:"lock_file"`
+	// OutboxDir - очередь отправки архивов во внешнее хранилище
+	OutboxDir string `json:"outbox_dir"`
+}
+
+// PathsFor возвращает стандартное расположение файлов для директории x-ui dir
+func PathsFor(dir string) Paths {
+	return Paths{
+		LogFile:       filepath.Join(dir, "access.log"),
+		ArchiveDir:    filepath.Join(dir, "archives"),
+		StateFile:     filepath.Join(dir, "last_archived_line.txt"),
+		PositionFile:  filepath.Join(dir, "last_archived_position.txt"),
+		TempHourlyLog: filepath.Join(dir, "temp_hourly_archive.log"),
+		RunStateFile:  filepath.Join(dir, "archiver_run_state.json"),
+		HistoryFile:   filepath.Join(dir, "archiver_history.jsonl"),
+		LockFile:      filepath.Join(dir, "archiver.lock"),
+		OutboxDir:     filepath.Join(dir, "archiver_outbox"),
+	}
+}
+
+// DefaultPaths возвращает расположение файлов по умолчанию (/usr/local/x-ui)
+func DefaultPaths() Paths {
+	return PathsFor(BASE_DIR)
 }
 
-// New создает новый экземпляр архиватора
+// New создает новый экземпляр архиватора с путями по умолчанию
 func New() *Archiver {
+	return NewWithPaths(DefaultPaths())
+}
+
+// NewWithPaths создает архиватор для экземпляра x-ui с заданными путями и журналом
+// по умолчанию: archive.log в директории архивов и ~/archiver.log
+func NewWithPaths(paths Paths) *Archiver {
+	a := NewWithOptions(Options{Paths: paths})
+	a.SetLogging(logging.Config{})
+	return a
+}
+
+// DefaultLogOutputs возвращает места назначения лога по умолчанию:
+// основной лог в директории архивов и локальный лог в домашней директории
+func (a *Archiver) DefaultLogOutputs() []string {
 	// Получаем домашнюю директорию пользователя для создания локального лога
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		homeDir = "." // Fallback к текущей директории
 	}
+	return []string{
+		filepath.Join(a.archiveDir, "archive.log"),
+		filepath.Join(homeDir, "archiver.log"),
+	}
+}
 
-	return &Archiver{
-		logFile:       LOG_FILE,
-		archiveDir:    ARCHIVE_DIR,
-		stateFile:     STATE_FILE,
-		positionFile:  POSITION_FILE,
-		tempHourlyLog: TEMP_HOURLY_LOG,
-		localLogFile:  filepath.Join(homeDir, "archiver.log"),
+// Paths возвращает файлы, с которыми работает архиватор
+func (a *Archiver) Paths() Paths {
+	return Paths{
+		LogFile:       a.logFile,
+		ArchiveDir:    a.archiveDir,
+		StateFile:     a.stateFile,
+		PositionFile:  a.positionFile,
+		TempHourlyLog: a.tempHourlyLog,
+		RunStateFile:  a.runStateFile,
+		HistoryFile:   a.historyFile,
+		LockFile:      a.lockFile,
+		OutboxDir:     a.outboxDir,
 	}
 }
 
-// RunArchiving выполняет процесс архивирования
-func (a *Archiver) RunArchiving() error {
-	startTime := time.Now()
-	fmt.Println("Начинаем процесс архивирования...")
+// SetPeriod задает период, за который накопитель сжимается в один архив (по умолчанию час)
+func (a *Archiver) SetPeriod(p Period) {
+	a.period = p
+}
+
+// SetNaming задает шаблон имени архивов (по умолчанию DEFAULT_NAMING в местном времени)
+func (a *Archiver) SetNaming(n Naming) {
+	a.naming = n
+}
+
+// Naming возвращает шаблон имени архивов
+func (a *Archiver) Naming() Naming {
+	return a.naming
+}
+
+// now возвращает текущее время в поясе имен архивов: от него отсчитываются периоды
+func (a *Archiver) now() time.Time {
+	return a.naming.In(a.clock.Now())
+}
+
+// SetMaxPending задает максимальный размер накопителя в байтах. При превышении накопитель
+// запечатывается в часть архива (access_..._10.part1.log.gz), не дожидаясь конца периода. 0 - без ограничения
+func (a *Archiver) SetMaxPending(size int64) {
+	a.maxPending = size
+}
+
+// SetLogging пересоздает логгер архиватора по конфигурации
+func (a *Archiver) SetLogging(cfg logging.Config) error {
+	// Директория архивов должна существовать до открытия основного лога
+	a.fs.MkdirAll(a.archiveDir, 0755)
+
+	logger, closer, err := logging.New(cfg, a.DefaultLogOutputs())
+	if err != nil {
+		return err
+	}
+
+	a.Close()
+	a.log, a.logCloser = logger, closer
+	return nil
+}
+
+// Close закрывает файлы лога архиватора
+func (a *Archiver) Close() error {
+	if a.logCloser == nil {
+		return nil
+	}
+	err := a.logCloser.Close()
+	a.logCloser = nil
+	return err
+}
+
+// RunStats содержит итоги одного запуска архивирования
+type RunStats struct {
+	StartTime      time.Time     `json:"start_time"`
+	Duration       time.Duration `json:"duration_ns"`
+	SourceSize     int64         `json:"source_size"`
+	BytesProcessed int64         `json:"bytes_processed"`
+	LinesProcessed int           `json:"lines_processed"`
+	RolledOver     bool          `json:"rolled_over"`
+	ArchiveFile    string        `json:"archive_file,omitempty"`
+	PartFiles      []string      `json:"part_files,omitempty"`
+	// RotationDetected - access.log оказался меньше позиции и прочитан с начала
+	RotationDetected bool `json:"rotation_detected,omitempty"`
+	// NextRollover - когда будет создан следующий архив, если архив в этом запуске не создан
+	NextRollover time.Time `json:"next_rollover"`
+	// TruncatedBytes - сколько байт освобождено усечением access.log
+	TruncatedBytes int64 `json:"truncated_bytes,omitempty"`
+	// FreeBytes - свободное место при последней проверке
+	FreeBytes int64 `json:"free_bytes,omitempty"`
+	// DiskEmergency - запуск остановлен из-за нехватки места
+	DiskEmergency bool `json:"disk_emergency,omitempty"`
+	// DeletedArchives - архивы, удаленные в аварийном режиме
+	DeletedArchives []string `json:"deleted_archives,omitempty"`
+}
+
+// Run переносит новые строки access.log в накопитель и создает архив, если начался новый
+// период. При отмене ctx уже перенесенные строки и позиция сохраняются, а Run возвращает
+// ошибку ctx. Если другой запуск еще выполняется, возвращает ErrLocked
+func (a *Archiver) Run(ctx context.Context) (RunStats, error) {
+	return a.run(ctx, false)
+}
+
+// RunArchiving выполняет процесс архивирования без возможности отмены
+func (a *Archiver) RunArchiving() (RunStats, error) {
+	return a.Run(context.Background())
+}
+
+// SealPending дочитывает новые строки access.log и сразу запечатывает накопитель в архив,
+// не дожидаясь 00 минут часа. Используется перед удалением архиватора, чтобы не потерять данные
+func (a *Archiver) SealPending() (RunStats, error) {
+	return a.run(context.Background(), true)
+}
+
+func (a *Archiver) run(ctx context.Context, forceRollover bool) (RunStats, error) {
+	stats := RunStats{StartTime: a.clock.Now()}
+
+	// Пропущенный из-за блокировки запуск не считается ни успехом, ни ошибкой в итогах запусков
+	unlock, err := acquireLock(a.lockFile)
+	if err != nil {
+		if err != ErrLocked {
+			err = fmt.Errorf("ошибка блокировки %s: %v", a.lockFile, err)
+		}
+		return stats, err
+	}
+	defer unlock()
+
+	err = a.runArchiving(ctx, &stats, forceRollover)
+	if err == nil {
+		if err = a.truncateSource(&stats); err != nil {
+			a.observeError("truncate")
+			err = fmt.Errorf("ошибка усечения %s: %v", a.logFile, err)
+		}
+	}
+	stats.Duration = a.since(stats.StartTime)
+	a.observeRun(stats, err)
+	a.recordRun(stats, err)
+	a.appendHistory(stats, err)
+	return stats, err
+}
+
+// PendingBytes возвращает размер временного накопителя, который еще не попал в архив
+func (a *Archiver) PendingBytes() int64 {
+	info, err := a.fs.Stat(a.tempHourlyLog)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (a *Archiver) runArchiving(ctx context.Context, stats *RunStats, forceRollover bool) error {
+	a.log.Info("Начинаем процесс архивирования")
 
 	// Создаем необходимые директории, если их нет
-	if err := os.MkdirAll(a.archiveDir, 0755); err != nil {
+	if err := a.fs.MkdirAll(a.archiveDir, 0755); err != nil {
+		a.observeError("mkdir")
 		return fmt.Errorf("ошибка создания директории %s: %v", a.archiveDir, err)
 	}
 
+	// Прошлый запуск мог прерваться посреди усечения access.log
+	if err := a.resolveTruncation(stats); err != nil {
+		a.observeError("truncate")
+		return err
+	}
+
+	// При принудительном запечатывании отсутствие access.log не мешает сохранить накопитель
+	if _, err := a.fs.Stat(a.logFile); os.IsNotExist(err) && forceRollover {
+		return a.rollover(stats, a.now())
+	}
+
 	// Получаем текущий размер файла
-	fileInfo, err := os.Stat(a.logFile)
+	fileInfo, err := a.fs.Stat(a.logFile)
 	if err != nil {
+		a.observeError("stat_source")
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrSourceMissing, a.logFile)
+		}
 		return fmt.Errorf("ошибка получения информации о файле %s: %v", a.logFile, err)
 	}
 	currentSize := fileInfo.Size()
+	stats.SourceSize = currentSize
 
 	// Получаем позицию последней обработанной строки
 	lastPosition := a.getLastProcessedPosition()
 
 	// Если файл был очищен (стал меньше), начинаем с начала
 	if currentSize < lastPosition {
-		a.logInfo(fmt.Sprintf("Файл был очищен (было %d байт, стало %d), начинаем с начала", lastPosition, currentSize))
+		if a.failOnRotation {
+			return fmt.Errorf("%w: размер %d меньше позиции %d", ErrRotationDetected, currentSize, lastPosition)
+		}
+		a.log.Warn("Файл был очищен, начинаем с начала", "previous_position", lastPosition, "size", currentSize)
+		stats.RotationDetected = true
 		lastPosition = 0
 	}
 
 	// Вычисляем количество новых байт
 	newBytes := currentSize - lastPosition
 
-	// Извлекаем новые строки и добавляем во временный файл-накопитель
+	// Извлекаем новые строки и добавляем во временный файл-накопитель. Если накопитель
+	// достигает максимального размера, он запечатывается в часть архива, и извлечение продолжается
 	if newBytes > 0 {
-		extractStart := time.Now()
-		linesProcessed, err := a.appendNewLinesFromPosition(lastPosition)
-		if err != nil {
-			return fmt.Errorf("ошибка добавления новых строк: %v", err)
+		extractStart := a.clock.Now()
+		for position := lastPosition; position < currentSize; {
+			// Перед каждой порцией проверяем место: при нехватке строки остаются в access.log
+			if err := a.guardDisk(stats); err != nil {
+				return err
+			}
+			linesProcessed, next, err := a.appendNewLines(ctx, position, currentSize)
+			if err != nil {
+				a.observeError("extract")
+				return fmt.Errorf("ошибка добавления новых строк: %v", err)
+			}
+			stats.LinesProcessed += linesProcessed
+			position = next
+
+			// Позицию сохраняем после каждой порции: после сбоя повторно читать придется только ее
+			if err := a.updateLastProcessedPosition(position); err != nil {
+				a.observeError("position")
+				return fmt.Errorf("ошибка обновления позиции: %v", err)
+			}
+			stats.BytesProcessed = position - lastPosition
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("архивирование прервано на позиции %d: %w", position, err)
+			}
+
+			if a.maxPending > 0 && a.PendingBytes() >= a.maxPending {
+				if err := a.sealPart(stats, a.now()); err != nil {
+					return err
+				}
+			}
 		}
-		extractDuration := time.Since(extractStart)
-		a.logInfo(fmt.Sprintf("Добавлено %d новых строк (%d байт) во временный накопитель за %v", linesProcessed, newBytes, extractDuration))
-		a.logPerformance("EXTRACT_LINES", extractDuration, fmt.Sprintf("Извлечено %d строк из %d байт", linesProcessed, newBytes))
+		extractDuration := a.since(extractStart)
+		a.log.Info("Добавлены новые строки во временный накопитель", "lines", stats.LinesProcessed, "bytes", newBytes, "duration", extractDuration)
+		a.logPerformance("EXTRACT_LINES", extractDuration, "lines", stats.LinesProcessed, "bytes", newBytes)
 	} else {
-		a.logInfo("Новых записей для добавления в накопитель не найдено.")
+		a.log.Info("Новых записей для добавления в накопитель не найдено")
 	}
 
 	// Обновляем позицию - записываем текущий размер файла
 	if err := a.updateLastProcessedPosition(currentSize); err != nil {
+		a.observeError("position")
 		return fmt.Errorf("ошибка обновления позиции: %v", err)
 	}
 
-	// Проверяем текущую минуту. Если это 00-я минута, архивируем накопитель.
-	currentMinute := time.Now().Minute()
-	if currentMinute == 0 {
-		archiveStart := time.Now()
-		if err := a.archiveHourlyLog(); err != nil {
-			return fmt.Errorf("ошибка архивирования: %v", err)
+	// Архивируем накопитель, если начался новый период. Сравниваем с началом периода накопителя,
+	// а не с минутой запуска: пропущенный запуск на границе не сдвигает архив на целый период
+	now := a.now()
+	if forceRollover || a.accumulatorPeriod(now).Before(a.period.Start(now)) {
+		if err := a.guardDisk(stats); err != nil {
+			return err
+		}
+		if err := a.rollover(stats, now); err != nil {
+			return err
 		}
-		archiveDuration := time.Since(archiveStart)
-		a.logPerformance("ARCHIVE_HOURLY", archiveDuration, "Создан часовой архив")
-	} else {
-		fmt.Printf("Архивирование произойдет в %d минут следующего часа (в 00 минут)\n", 60-currentMinute)
 	}
+	stats.NextRollover = a.period.Next(now)
 
 	// Очистка старых архивов отключена - архивы сохраняются навсегда
 	// if err := a.cleanupOldArchives(); err != nil {
@@ -114,17 +375,45 @@ func (a *Archiver) RunArchiving() error {
 	// }
 
 	// Логируем статистику производительности
-	duration := time.Since(startTime)
-	performanceStats := fmt.Sprintf("Процесс архивирования завершен за %v. Обработано байт: %d, новых байт: %d",
-		duration, currentSize, newBytes)
-	a.logInfo(performanceStats)
-	a.logPerformance("TOTAL_RUN", duration, fmt.Sprintf("Обработано %d байт, новых %d", currentSize, newBytes))
-	fmt.Printf("Архивирование завершено успешно! Время выполнения: %v\n", duration)
+	duration := a.since(stats.StartTime)
+	a.log.Info("Процесс архивирования завершен", "duration", duration, "size", currentSize, "new_bytes", newBytes)
+	a.logPerformance("TOTAL_RUN", duration, "size", currentSize, "new_bytes", newBytes)
+	return nil
+}
+
+// rollover запечатывает временный накопитель в архив периода, к которому он относится
+func (a *Archiver) rollover(stats *RunStats, now time.Time) error {
+	archiveStart := a.clock.Now()
+	archiveFile, err := a.archivePeriodLog(a.accumulatorPeriod(now), false)
+	if err != nil {
+		a.observeError("archive")
+		return fmt.Errorf("ошибка архивирования: %v", err)
+	}
+	// Дальше накопитель собирает строки текущего периода
+	a.periodStart = a.period.Start(now)
+	stats.RolledOver = true
+	stats.ArchiveFile = archiveFile
+	archiveDuration := a.since(archiveStart)
+	a.logPerformance("ARCHIVE_HOURLY", archiveDuration, "archive", archiveFile)
+	return nil
+}
+
+// sealPart запечатывает переполненный накопитель в очередную часть архива текущего периода
+func (a *Archiver) sealPart(stats *RunStats, now time.Time) error {
+	archiveStart := a.clock.Now()
+	partFile, err := a.archivePeriodLog(a.accumulatorPeriod(now), true)
+	if err != nil {
+		a.observeError("archive")
+		return fmt.Errorf("ошибка архивирования части: %v", err)
+	}
+	stats.PartFiles = append(stats.PartFiles, partFile)
+	a.log.Info("Накопитель превысил максимальный размер, создана часть архива", "archive", partFile, "max_bytes", a.maxPending)
+	a.logPerformance("ARCHIVE_PART", a.since(archiveStart), "archive", partFile)
 	return nil
 }
 
 func (a *Archiver) getLastProcessedPosition() int64 {
-	data, err := os.ReadFile(a.positionFile)
+	data, err := a.fs.ReadFile(a.positionFile)
 	if err != nil {
 		return 0
 	}
@@ -138,91 +427,207 @@ func (a *Archiver) getLastProcessedPosition() int64 {
 }
 
 func (a *Archiver) updateLastProcessedPosition(position int64) error {
-	return os.WriteFile(a.positionFile, []byte(fmt.Sprintf("%d", position)), 0644)
+	return a.fs.WriteFile(a.positionFile, []byte(fmt.Sprintf("%d", position)), 0644)
 }
 
-func (a *Archiver) appendNewLinesFromPosition(startPosition int64) (int, error) {
+// appendNewLines переносит строки access.log из диапазона [start, end) в накопитель.
+// Если задан максимальный размер накопителя, останавливается на границе строки, как только
+// накопитель его достиг. Возвращает число строк и позицию, до которой дочитан access.log
+// При отмене ctx останавливается на границе строки, сохранив уже записанное
+func (a *Archiver) appendNewLines(ctx context.Context, start, end int64) (int, int64, error) {
 	// Открываем основной лог файл
-	logFile, err := os.Open(a.logFile)
+	logFile, err := a.fs.Open(a.logFile)
 	if err != nil {
-		return 0, err
+		return 0, start, err
 	}
 	defer logFile.Close()
 
 	// Переходим к позиции последней обработанной строки
-	if _, err := logFile.Seek(startPosition, 0); err != nil {
-		return 0, err
+	if _, err := logFile.Seek(start, io.SeekStart); err != nil {
+		return 0, start, err
 	}
 
 	// Открываем временный файл для добавления
-	tempFile, err := os.OpenFile(a.tempHourlyLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	tempFile, err := a.fs.OpenFile(a.tempHourlyLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		return 0, err
+		return 0, start, err
 	}
 	defer tempFile.Close()
 
 	// Используем буферизованный writer для эффективной записи
 	writer := bufio.NewWriter(tempFile)
-	defer writer.Flush()
+	pending := a.PendingBytes()
+	// Если запись оборвалась, например кончилось место, отрезаем дописанное: иначе
+	// повторный запуск с той же позиции запишет эти строки второй раз
+	before := pending
+	rollback := func(err error) (int, int64, error) {
+		tempFile.Close()
+		a.fs.Truncate(a.tempHourlyLog, before)
+		return 0, start, err
+	}
 
-	scanner := bufio.NewScanner(logFile)
+	// Читаем не дальше end: строки, дописанные после замера размера, достанутся следующему запуску
+	reader := bufio.NewReaderSize(io.LimitReader(logFile, end-start), 64*1024)
+	position := start
 	linesWritten := 0
-
-	// Читаем только новые строки (с позиции startPosition до конца файла)
-	for scanner.Scan() {
-		if _, err := writer.WriteString(scanner.Text() + "\n"); err != nil {
-			return linesWritten, err
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			position += int64(len(line))
+			// Незавершенную последнюю строку дописываем с переводом строки, как и раньше
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			if _, err := writer.Write(line); err != nil {
+				return rollback(err)
+			}
+			linesWritten++
+			pending += int64(len(line))
+			if a.maxPending > 0 && pending >= a.maxPending {
+				break
+			}
+			if linesWritten%1024 == 0 && ctx.Err() != nil {
+				break
+			}
+		}
+		if readErr == io.EOF {
+			break
 		}
-		linesWritten++
+		if readErr != nil {
+			return rollback(readErr)
+		}
+	}
+
+	// Позицию можно сдвигать, только если строки действительно записаны
+	if err := writer.Flush(); err != nil {
+		return rollback(err)
+	}
+	return linesWritten, position, nil
+}
+
+// accumulatorPeriod возвращает начало периода, строки которого собирает накопитель.
+// Если оно еще не сохранено, определяется по первой записи накопителя
+func (a *Archiver) accumulatorPeriod(now time.Time) time.Time {
+	if !a.periodStart.IsZero() {
+		return a.periodStart
+	}
+	if state := a.loadRunState(); state.PeriodStart != nil {
+		a.periodStart = *state.PeriodStart
+	} else if since, ok := a.pendingSince(); ok {
+		a.periodStart = a.period.Start(a.naming.In(since))
+	} else {
+		a.periodStart = a.period.Start(now)
 	}
+	return a.periodStart
+}
+
+// uniqueArchivePath возвращает путь архива, не занятый ни сжатым, ни несжатым файлом.
+// Повторный архив того же периода (например, после запечатывания) получает суффикс _2, _3...
+func (a *Archiver) uniqueArchivePath(name string) string {
+	base := strings.TrimSuffix(filepath.Join(a.archiveDir, name), ".log")
+	candidate := base + ".log"
+	for n := 2; a.exists(candidate) || a.exists(candidate+a.codec.Extension()); n++ {
+		candidate = fmt.Sprintf("%s_%d.log", base, n)
+	}
+	return candidate
+}
+
+func (a *Archiver) exists(path string) bool {
+	_, err := a.fs.Stat(path)
+	return err == nil
+}
+
+// partPath возвращает путь части number архива name: access_X.log -> access_X.part2.log
+func (a *Archiver) partPath(name string, number int) string {
+	return filepath.Join(a.archiveDir, fmt.Sprintf("%s.part%d.log", strings.TrimSuffix(name, ".log"), number))
+}
 
-	return linesWritten, scanner.Err()
+// lastPart возвращает номер последней существующей части архива name, 0 - частей нет
+func (a *Archiver) lastPart(name string) int {
+	prefix := strings.TrimSuffix(name, ".log") + ".part"
+	matches, _ := a.fs.Glob(filepath.Join(a.archiveDir, prefix+"*.log*"))
+	last := 0
+	for _, match := range matches {
+		number := strings.TrimPrefix(filepath.Base(match), prefix)
+		number = number[:strings.Index(number, ".log")]
+		if n, err := strconv.Atoi(number); err == nil && n > last {
+			last = n
+		}
+	}
+	return last
 }
 
-func (a *Archiver) archiveHourlyLog() error {
+// archivePeriodLog сжимает накопитель в архив периода start. Если у периода уже есть части
+// или part=true, архив становится следующей частью периода
+func (a *Archiver) archivePeriodLog(start time.Time, part bool) (string, error) {
 	// Проверяем, есть ли данные в временном файле
-	fileInfo, err := os.Stat(a.tempHourlyLog)
+	fileInfo, err := a.fs.Stat(a.tempHourlyLog)
+	if os.IsNotExist(err) {
+		a.log.Info("Временный накопитель отсутствует, архив не создан")
+		return "", nil
+	}
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if fileInfo.Size() == 0 {
-		a.logInfo("Временный накопитель пуст, часовой архив не создан.")
+		a.log.Info("Временный накопитель пуст, архив не создан")
 		// Очищаем временный накопитель после проверки
-		return os.Truncate(a.tempHourlyLog, 0)
+		return "", a.fs.Truncate(a.tempHourlyLog, 0)
 	}
 
-	// Создаем имя архива с временной меткой
-	timestamp := time.Now().Format("20060102_150405")
-	archiveFile := filepath.Join(a.archiveDir, fmt.Sprintf("access_%s.log", timestamp))
+	// Имя архива - начало периода с точностью периода
+	now := a.clock.Now()
+	name := a.naming.ArchiveName(a.period, start)
+	archiveFile := a.uniqueArchivePath(name)
+	if last := a.lastPart(name); part || last > 0 {
+		archiveFile = a.partPath(name, last+1)
+	}
 
 	// Перемещаем временный файл в архив
-	moveStart := time.Now()
-	if err := os.Rename(a.tempHourlyLog, archiveFile); err != nil {
-		return err
+	moveStart := a.clock.Now()
+	if err := a.fs.Rename(a.tempHourlyLog, archiveFile); err != nil {
+		return "", err
 	}
-	moveDuration := time.Since(moveStart)
-	a.logPerformance("MOVE_TEMP_FILE", moveDuration, fmt.Sprintf("Перемещен файл размером %d байт", fileInfo.Size()))
+	moveDuration := a.since(moveStart)
+	a.logPerformance("MOVE_TEMP_FILE", moveDuration, "bytes", fileInfo.Size())
 
 	// Сжимаем архив
-	compressStart := time.Now()
-	if err := a.compressFile(archiveFile); err != nil {
-		a.logError(fmt.Sprintf("Ошибка сжатия архива %s: %v", archiveFile, err))
+	compressStart := a.clock.Now()
+	if compressed, err := a.compressFile(archiveFile); err != nil {
+		a.observeError("compress")
+		a.log.Error("Ошибка сжатия архива", "archive", archiveFile, "error", err)
 		// Продолжаем выполнение даже если сжатие не удалось
 	} else {
-		compressDuration := time.Since(compressStart)
-		a.logInfo(fmt.Sprintf("Архивирован часовой лог в %s.gz", archiveFile))
-		a.logPerformance("COMPRESS_ARCHIVE", compressDuration, fmt.Sprintf("Сжат файл размером %d байт", fileInfo.Size()))
+		archiveFile = compressed
+		compressDuration := a.since(compressStart)
+		a.log.Info("Архивирован лог периода", "archive", archiveFile, "period", a.period.String(), "period_start", start)
+		a.logPerformance("COMPRESS_ARCHIVE", compressDuration, "bytes", fileInfo.Size())
 	}
+	a.observeArchive(now)
 
-	// Очищаем временный накопитель после архивирования
-	return os.Truncate(a.tempHourlyLog, 0)
+	// Создаем пустой временный накопитель: исходный файл перемещен в архив. Если не удалось,
+	// архив все равно создан, а накопитель создаст следующий запуск при добавлении строк
+	if err := a.fs.WriteFile(a.tempHourlyLog, nil, 0644); err != nil {
+		a.log.Warn("Не удалось создать пустой накопитель", "file", a.tempHourlyLog, "error", err)
+	}
+	return archiveFile, nil
 }
 
-func (a *Archiver) compressFile(filename string) error {
-	// Выполняем команду gzip
-	cmd := exec.Command("gzip", filename)
-	return cmd.Run()
+// compressFile сжимает архив кодеком и удаляет несжатый файл. Сжатый файл появляется
+// под своим именем только целиком, поэтому при сбое остается несжатый архив, а не битый
+func (a *Archiver) compressFile(filename string) (string, error) {
+	compressed := filename + a.codec.Extension()
+	src, err := a.fs.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	if err := a.writeArchive(compressed, src); err != nil {
+		return "", err
+	}
+	return compressed, a.fs.Remove(filename)
 }
 
 func (a *Archiver) cleanupOldArchives() error {
@@ -231,50 +636,8 @@ func (a *Archiver) cleanupOldArchives() error {
 	return cmd.Run()
 }
 
-func (a *Archiver) logInfo(message string) {
-	a.logMessage("INFO", message)
-}
-
-func (a *Archiver) logError(message string) {
-	a.logMessage("ERROR", message)
-}
-
-func (a *Archiver) logMessage(level, message string) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	logEntry := fmt.Sprintf("%s [%s]: %s\n", timestamp, level, message)
-
-	// Записываем в основной лог (в директории архивов)
-	logFile := filepath.Join(a.archiveDir, "archive.log")
-	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		fmt.Printf("Ошибка записи в основной лог: %v\n", err)
-	} else {
-		file.WriteString(logEntry)
-		file.Close()
-	}
-
-	// Записываем в локальный лог (в директории запуска)
-	localFile, err := os.OpenFile(a.localLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		fmt.Printf("Ошибка записи в локальный лог: %v\n", err)
-		return
-	}
-	defer localFile.Close()
-
-	localFile.WriteString(logEntry)
-}
-
-// logPerformance записывает информацию о производительности в локальный лог
-func (a *Archiver) logPerformance(operation string, duration time.Duration, details string) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	perfEntry := fmt.Sprintf("%s [PERF] %s: %v - %s\n", timestamp, operation, duration, details)
-
-	localFile, err := os.OpenFile(a.localLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		fmt.Printf("Ошибка записи в локальный лог: %v\n", err)
-		return
-	}
-	defer localFile.Close()
-
-	localFile.WriteString(perfEntry)
+// logPerformance записывает длительность операции в лог и в метрики
+func (a *Archiver) logPerformance(operation string, duration time.Duration, args ...any) {
+	a.observeDuration(operation, duration)
+	a.log.Info("PERF", append([]any{"operation", operation, "duration", duration}, args...)...)
 }
@@ -0,0 +1,484 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"xui_log_archiver/fsys"
+	"xui_log_archiver/xraylog"
+)
+
+// Что импорт сделал с архивом периода
+const (
+	BACKFILL_CREATED = "created"
+	BACKFILL_MERGED  = "merged"
+	BACKFILL_SKIPPED = "skipped"
+)
+
+// BACKFILL_DIR - временная директория импорта внутри директории архивов
+const BACKFILL_DIR = ".backfill"
+
+// BackfillOptions - настройки импорта старых логов
+type BackfillOptions struct {
+	// Merge - добавлять строки в существующие архивы периодов, а не пропускать эти периоды.
+	// Все архивы периода (с суффиксами _2 и частями) объединяются в один без дубликатов
+	Merge bool
+	// DryRun - только посчитать, какие архивы будут созданы
+	DryRun bool
+}
+
+// BackfillPeriod - итог импорта одного периода
+type BackfillPeriod struct {
+	Start   time.Time `json:"start"`
+	Archive string    `json:"archive"`
+	// Action - created, merged или skipped
+	Action string `json:"action"`
+	// Lines - строк импорта за период; для объединенного архива - строк в нем всего
+	Lines      int `json:"lines"`
+	Duplicates int `json:"duplicates,omitempty"`
+}
+
+// BackfillStats - итоги импорта
+type BackfillStats struct {
+	Files []string `json:"files"`
+	Lines int      `json:"lines"`
+	// Unparsed - строки без метки времени, они пропущены
+	Unparsed int `json:"unparsed"`
+	// Live - строки периода, который собирает накопитель, и более поздние: их архивирует
+	// обычный запуск. Из текущего access.log он прочитает их сам, строки ротированных
+	// файлов импорт дописывает в накопитель
+	Live int `json:"live"`
+	// Appended - строки ротированных файлов, дописанные в накопитель; строки, которые
+	// уже есть в нем, не дублируются
+	Appended int              `json:"appended"`
+	Periods  []BackfillPeriod `json:"periods"`
+}
+
+// Backfill раскладывает строки старых логов (access.log.1, access.log.2.gz, ...) по архивам
+// периодов по их меткам времени. Периоды, у которых уже есть архив, пропускаются или,
+// с BackfillOptions.Merge, объединяются с ним. Пока идет импорт, обычные запуски пропускаются.
+//
+// Текущий access.log можно импортировать, только пока архиватор не начал его читать: тогда
+// позиция переносится на первую строку периода накопителя, и обычный запуск продолжает с нее
+func (a *Archiver) Backfill(ctx context.Context, files []string, opts BackfillOptions) (BackfillStats, error) {
+	stats := BackfillStats{Files: files, Periods: []BackfillPeriod{}}
+
+	unlock, err := acquireLock(a.lockFile)
+	if err != nil {
+		if err != ErrLocked {
+			err = fmt.Errorf("ошибка блокировки %s: %v", a.lockFile, err)
+		}
+		return stats, err
+	}
+	defer unlock()
+
+	// Импорт пишет временные файлы периодов и архивы: при нехватке места он только приблизит аварию
+	if a.minFree > 0 {
+		if free, err := a.FreeSpace(); err == nil && free < a.minFree {
+			return stats, fmt.Errorf("%w: свободно %d МБ, нужно не меньше %d МБ", ErrLowDiskSpace, free>>20, a.minFree>>20)
+		}
+	}
+
+	work := filepath.Join(a.archiveDir, BACKFILL_DIR)
+	if err := a.fs.MkdirAll(work, 0755); err != nil {
+		return stats, fmt.Errorf("ошибка создания директории %s: %v", work, err)
+	}
+	buckets := &backfillBuckets{fs: a.fs, dir: work, files: map[int64]string{}}
+	defer buckets.remove()
+
+	live := a.accumulatorPeriod(a.now())
+	position := int64(-1)
+	for _, path := range files {
+		var limit int64 = -1
+		if path == a.logFile {
+			if !a.CanBackfillSource() {
+				return stats, fmt.Errorf("%s уже читается архиватором с позиции %d, импортируйте только ротированные файлы",
+					path, a.getLastProcessedPosition())
+			}
+			info, err := a.fs.Stat(path)
+			if err != nil {
+				return stats, fmt.Errorf("ошибка получения информации о файле %s: %v", path, err)
+			}
+			// Строки, дописанные во время импорта, достанутся обычному запуску
+			limit = info.Size()
+		}
+		livePosition, err := a.backfillFile(ctx, path, limit, live, buckets, &stats)
+		if err != nil {
+			buckets.close()
+			return stats, err
+		}
+		if limit >= 0 {
+			position = livePosition
+		}
+	}
+	if err := buckets.close(); err != nil {
+		return stats, fmt.Errorf("ошибка записи временных файлов импорта: %v", err)
+	}
+
+	for _, start := range buckets.sorted() {
+		if err := ctx.Err(); err != nil {
+			return stats, fmt.Errorf("импорт прерван: %w", err)
+		}
+		if !start.Before(live) {
+			appended, err := a.backfillAccumulator(buckets.files[start.Unix()], opts.DryRun)
+			if err != nil {
+				return stats, fmt.Errorf("ошибка записи в накопитель: %v", err)
+			}
+			stats.Appended += appended
+			continue
+		}
+		period, err := a.backfillPeriod(start, buckets.files[start.Unix()], opts)
+		if err != nil {
+			return stats, fmt.Errorf("ошибка импорта периода %s: %v", start.Format("2006-01-02 15:04"), err)
+		}
+		stats.Periods = append(stats.Periods, period)
+	}
+
+	if position >= 0 && !opts.DryRun {
+		if err := a.updateLastProcessedPosition(position); err != nil {
+			return stats, fmt.Errorf("ошибка обновления позиции: %v", err)
+		}
+	}
+	a.log.Info("Импорт старых логов завершен", "files", len(files), "lines", stats.Lines, "periods", len(stats.Periods))
+	return stats, nil
+}
+
+// CanBackfillSource сообщает, можно ли импортировать текущий access.log: архиватор еще
+// не начал его читать и накопитель пуст
+func (a *Archiver) CanBackfillSource() bool {
+	return a.getLastProcessedPosition() == 0 && a.PendingBytes() == 0
+}
+
+// backfillFile раскладывает строки одного файла по периодам, читая не больше limit байт
+// (-1 - до конца). Строки периода накопителя из текущего access.log (limit >= 0) остаются
+// обычному запуску, из ротированного файла - тоже идут во временный файл своего периода.
+// Возвращает позицию первой строки периода накопителя или, если таких строк нет, позицию
+// конца прочитанного
+func (a *Archiver) backfillFile(ctx context.Context, path string, limit int64, live time.Time, buckets *backfillBuckets, stats *BackfillStats) (int64, error) {
+	file, err := a.openLog(path)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка открытия %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var source io.Reader = file
+	if limit >= 0 {
+		source = io.LimitReader(file, limit)
+	}
+	reader := bufio.NewReaderSize(source, 64*1024)
+	loc := a.clock.Now().Location()
+	var position int64
+	livePosition := int64(-1)
+	for n := 1; ; n++ {
+		if n%4096 == 0 && ctx.Err() != nil {
+			return 0, fmt.Errorf("импорт прерван: %w", ctx.Err())
+		}
+		raw, readErr := reader.ReadString('\n')
+		offset := position
+		position += int64(len(raw))
+		if line := strings.TrimRight(raw, "\r\n"); strings.TrimSpace(line) != "" {
+			if t, _, ok := xraylog.ParseTimeIn(line, loc); !ok {
+				stats.Unparsed++
+			} else if start := a.period.Start(a.naming.In(t)); !start.Before(live) {
+				if livePosition < 0 {
+					livePosition = offset
+				}
+				stats.Live++
+				// Ротированный файл обычный запуск уже не прочитает
+				if limit < 0 {
+					if err := buckets.add(start, line); err != nil {
+						return 0, fmt.Errorf("ошибка записи временного файла импорта: %v", err)
+					}
+				}
+			} else {
+				if err := buckets.add(start, line); err != nil {
+					return 0, fmt.Errorf("ошибка записи временного файла импорта: %v", err)
+				}
+				stats.Lines++
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return 0, fmt.Errorf("ошибка чтения %s: %v", path, readErr)
+		}
+	}
+	if livePosition < 0 {
+		livePosition = position
+	}
+	return livePosition, nil
+}
+
+// backfillPeriod создает архив периода start из строк временного файла или объединяет их
+// с существующими архивами периода
+func (a *Archiver) backfillPeriod(start time.Time, tmp string, opts BackfillOptions) (BackfillPeriod, error) {
+	name := a.naming.ArchiveName(a.period, start)
+	target := filepath.Join(a.archiveDir, name) + a.codec.Extension()
+	result := BackfillPeriod{Start: start, Archive: target, Action: BACKFILL_CREATED}
+
+	lines, err := a.readLines(tmp)
+	if err != nil {
+		return result, err
+	}
+	result.Lines = len(lines)
+
+	// Уплотненный архив дня или месяца ради одного периода не переписывается
+	if compacted := a.compactedArchive(start); compacted != "" {
+		result.Action, result.Archive = BACKFILL_SKIPPED, compacted
+		return result, nil
+	}
+	existing := a.periodArchives(name)
+	if len(existing) > 0 {
+		if !opts.Merge {
+			result.Action, result.Archive = BACKFILL_SKIPPED, existing[0]
+			return result, nil
+		}
+		result.Action = BACKFILL_MERGED
+		for _, path := range existing {
+			archived, err := a.readLines(path)
+			if err != nil {
+				return result, err
+			}
+			lines = append(lines, archived...)
+		}
+	}
+
+	// Строки одного периода сортируются и очищаются от дубликатов, как в merge
+	sort.Strings(lines)
+	unique := lines[:0]
+	for _, line := range lines {
+		if len(unique) > 0 && line == unique[len(unique)-1] {
+			result.Duplicates++
+			continue
+		}
+		unique = append(unique, line)
+	}
+	if result.Action == BACKFILL_MERGED {
+		result.Lines = len(unique)
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	var buf bytes.Buffer
+	for _, line := range unique {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if err := a.writeArchive(target, &buf); err != nil {
+		return result, err
+	}
+	// Архиватор создает архив в начале следующего периода, status и дашборд опираются на это время
+	end := a.period.Next(start)
+	a.fs.Chtimes(target, end, end)
+
+	for _, path := range existing {
+		if path != target {
+			if err := a.fs.Remove(path); err != nil {
+				return result, fmt.Errorf("ошибка удаления объединенного архива %s: %v", path, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// backfillAccumulator дописывает в накопитель строки временного файла, которых в нем еще нет:
+// часть из них архиватор мог прочитать из access.log до ротации. Возвращает число дописанных строк
+func (a *Archiver) backfillAccumulator(tmp string, dryRun bool) (int, error) {
+	lines, err := a.readLines(tmp)
+	if err != nil {
+		return 0, err
+	}
+	pending := map[string]bool{}
+	if a.PendingBytes() > 0 {
+		existing, err := a.readLines(a.tempHourlyLog)
+		if err != nil {
+			return 0, err
+		}
+		for _, line := range existing {
+			pending[line] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	appended := 0
+	for _, line := range lines {
+		if pending[line] {
+			continue
+		}
+		pending[line] = true
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+		appended++
+	}
+	if dryRun {
+		return appended, nil
+	}
+	return a.appendBytes(buf.Bytes())
+}
+
+// periodArchives возвращает существующие архивы периода name: основной, с суффиксами _2, _3...
+// и части, сжатые или нет
+func (a *Archiver) periodArchives(name string) []string {
+	base := filepath.Join(a.archiveDir, strings.TrimSuffix(name, ".log"))
+	var archives []string
+	for _, pattern := range []string{base + ".log*", base + "_[0-9]*.log*", base + ".part[0-9]*.log*"} {
+		matches, _ := a.fs.Glob(pattern)
+		for _, match := range matches {
+			if !strings.HasSuffix(match, ".tmp") {
+				archives = append(archives, match)
+			}
+		}
+	}
+	sort.Strings(archives)
+	return archives
+}
+
+// openLog открывает лог или архив для чтения, распаковывая сжатые gzip и кодеком архиватора
+func (a *Archiver) openLog(path string) (io.ReadCloser, error) {
+	file, err := a.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var reader io.ReadCloser
+	switch {
+	case strings.HasSuffix(path, a.codec.Extension()):
+		reader, err = a.codec.Decompress(file)
+	case strings.HasSuffix(path, ".gz"):
+		reader, err = gzip.NewReader(file)
+	default:
+		return file, nil
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return readCloser{reader, file}, nil
+}
+
+// readCloser закрывает и распаковщик, и файл под ним
+type readCloser struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (r readCloser) Close() error {
+	r.ReadCloser.Close()
+	return r.file.Close()
+}
+
+// readLines читает все непустые строки лога или архива
+func (a *Archiver) readLines(path string) ([]string, error) {
+	reader, err := a.openLog(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия %s: %v", path, err)
+	}
+	defer reader.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %v", path, err)
+	}
+	return lines, nil
+}
+
+// writeArchive сжимает src в архив path. Архив появляется под своим именем только целиком
+func (a *Archiver) writeArchive(path string, src io.Reader) error {
+	tmp := path + ".tmp"
+	dst, err := a.fs.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := a.codec.Compress(dst, src); err != nil {
+		dst.Close()
+		a.fs.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		a.fs.Remove(tmp)
+		return err
+	}
+	if err := a.fs.Rename(tmp, path); err != nil {
+		a.fs.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// backfillBuckets раскладывает строки по временным файлам периодов. Открытым держится только
+// файл последнего периода: строки в логах идут по времени, и переключения редки
+type backfillBuckets struct {
+	fs      fsys.FS
+	dir     string
+	files   map[int64]string
+	starts  []time.Time
+	current int64
+	file    fsys.File
+	writer  *bufio.Writer
+}
+
+func (b *backfillBuckets) add(start time.Time, line string) error {
+	key := start.Unix()
+	if b.file == nil || key != b.current {
+		if err := b.close(); err != nil {
+			return err
+		}
+		path, ok := b.files[key]
+		if !ok {
+			path = filepath.Join(b.dir, fmt.Sprintf("%d.log", key))
+			b.files[key] = path
+			b.starts = append(b.starts, start)
+		}
+		file, err := b.fs.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		b.file, b.writer, b.current = file, bufio.NewWriter(file), key
+	}
+	_, err := b.writer.WriteString(line + "\n")
+	return err
+}
+
+func (b *backfillBuckets) close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.writer.Flush()
+	if closeErr := b.file.Close(); err == nil {
+		err = closeErr
+	}
+	b.file, b.writer = nil, nil
+	return err
+}
+
+// sorted возвращает начала периодов по возрастанию
+func (b *backfillBuckets) sorted() []time.Time {
+	sort.Slice(b.starts, func(i, j int) bool { return b.starts[i].Before(b.starts[j]) })
+	return b.starts
+}
+
+// remove удаляет временные файлы импорта
+func (b *backfillBuckets) remove() {
+	b.close()
+	for _, path := range b.files {
+		b.fs.Remove(path)
+	}
+	b.fs.Remove(b.dir)
+}
@@ -0,0 +1,477 @@
+package archiver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Уровни уплотнения архивов
+const (
+	COMPACT_DAILY   = "daily"
+	COMPACT_MONTHLY = "monthly"
+)
+
+// COMPACT_JOURNAL - журнал уплотнения в директории архивов. Пока он есть, уплотнение не завершено:
+// после сбоя по нему удаляются уже объединенные архивы
+const COMPACT_JOURNAL = ".compact.json"
+
+// CompactOptions - настройки уплотнения
+type CompactOptions struct {
+	// Monthly - кроме дней объединять в один архив завершенные месяцы
+	Monthly bool
+	// DryRun - только показать, какие архивы будут объединены
+	DryRun bool
+}
+
+// CompactedArchive - архив дня или месяца и архивы, из которых он собран
+type CompactedArchive struct {
+	Archive string   `json:"archive"`
+	Inputs  []string `json:"inputs"`
+	Lines   int      `json:"lines"`
+}
+
+// CompactStats - итоги уплотнения
+type CompactStats struct {
+	Archives []CompactedArchive `json:"archives"`
+	Inputs   int                `json:"inputs"`
+	Lines    int                `json:"lines"`
+}
+
+// compactJournal - что объединялось в момент сбоя
+type compactJournal struct {
+	Archive string   `json:"archive"`
+	Inputs  []string `json:"inputs"`
+	Lines   int      `json:"lines"`
+}
+
+// compactInput - архив, который войдет в архив дня или месяца
+type compactInput struct {
+	path string
+	node string
+	day  time.Time
+	// start, seq, part - порядок внутри дня: начало периода, суффикс _2 и номер части.
+	// Архив без номера части - остаток периода после частей
+	start time.Time
+	seq   int
+	part  int
+	// daily - архив целого дня: уплотненный или с периодом в сутки
+	daily bool
+}
+
+// compactKey - архивы одного узла за день или месяц. Архивы разных узлов, собранные в одну
+// директорию, объединяются отдельно
+type compactKey struct {
+	node string
+	day  time.Time
+}
+
+// DayDir возвращает директорию уплотненного архива дня: ARCHIVE_DIR/YYYY/MM/DD
+func DayDir(archiveDir string, day time.Time) string {
+	return filepath.Join(archiveDir, day.Format("2006"), day.Format("01"), day.Format("02"))
+}
+
+// MonthDir возвращает директорию уплотненного архива месяца: ARCHIVE_DIR/YYYY/MM
+func MonthDir(archiveDir string, month time.Time) string {
+	return filepath.Join(archiveDir, month.Format("2006"), month.Format("01"))
+}
+
+func (a *Archiver) dailyArchive(node string, day time.Time) string {
+	return filepath.Join(DayDir(a.archiveDir, day), a.naming.name(node, LAYOUT_DAY, day)+a.codec.Extension())
+}
+
+func (a *Archiver) monthlyArchive(node string, month time.Time) string {
+	return filepath.Join(MonthDir(a.archiveDir, month), a.naming.name(node, LAYOUT_MONTH, month)+a.codec.Extension())
+}
+
+// compactedArchive возвращает уплотненный архив дня или месяца, в который попадает период start
+func (a *Archiver) compactedArchive(start time.Time) string {
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, start.Location())
+	for _, path := range []string{a.dailyArchive(a.naming.node, day), a.monthlyArchive(a.naming.node, month)} {
+		if a.exists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// Compact объединяет архивы каждого завершенного дня в один архив ARCHIVE_DIR/YYYY/MM/DD,
+// а с Monthly - архивы завершенного месяца в ARCHIVE_DIR/YYYY/MM. Исходные архивы удаляются,
+// только если в новом архиве столько же строк, сколько в них
+func (a *Archiver) Compact(ctx context.Context, opts CompactOptions) (CompactStats, error) {
+	stats := CompactStats{Archives: []CompactedArchive{}}
+
+	unlock, err := acquireLock(a.lockFile)
+	if err != nil {
+		if err != ErrLocked {
+			err = fmt.Errorf("ошибка блокировки %s: %v", a.lockFile, err)
+		}
+		return stats, err
+	}
+	defer unlock()
+
+	if err := a.recoverCompaction(); err != nil {
+		return stats, err
+	}
+
+	// Уплотняются только дни, строки которых уже не придут ни из access.log, ни из накопителя
+	now := a.now()
+	limit := a.period.Start(now)
+	if a.PendingBytes() > 0 && a.accumulatorPeriod(now).Before(limit) {
+		limit = a.accumulatorPeriod(now)
+	}
+
+	days, err := a.compactDays(ctx, limit, opts, &stats)
+	if err != nil || !opts.Monthly {
+		return stats, err
+	}
+	return stats, a.compactMonths(ctx, limit, days, opts, &stats)
+}
+
+// compactDays объединяет архивы завершенных дней из корня директории архивов.
+// Возвращает архивы дней, созданные или запланированные в пробном запуске
+func (a *Archiver) compactDays(ctx context.Context, limit time.Time, opts CompactOptions, stats *CompactStats) ([]string, error) {
+	entries, err := a.fs.ReadDir(a.archiveDir)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения директории %s: %v", a.archiveDir, err)
+	}
+	groups := map[compactKey][]compactInput{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		input, ok := a.parseCompactInput(filepath.Join(a.archiveDir, entry.Name()), a.now().Location())
+		if !ok || input.day.AddDate(0, 0, 1).After(limit) {
+			continue
+		}
+		key := compactKey{input.node, input.day}
+		groups[key] = append(groups[key], input)
+	}
+
+	var planned []string
+	for _, key := range sortedKeys(groups) {
+		if err := ctx.Err(); err != nil {
+			return planned, fmt.Errorf("уплотнение прервано: %w", err)
+		}
+		day, inputs := key.day, groups[key]
+		sortInputs(inputs)
+		target := a.dailyArchive(key.node, day)
+		paths := inputPaths(inputs)
+		// Архив дня, созданный раньше, объединяется с архивами, появившимися после него
+		if a.exists(target) {
+			paths = append([]string{target}, paths...)
+		}
+		compacted, err := a.compactInto(target, paths, day.AddDate(0, 0, 1), opts.DryRun)
+		if err != nil {
+			return planned, err
+		}
+		stats.add(compacted)
+		planned = append(planned, target)
+	}
+	return planned, nil
+}
+
+// compactMonths объединяет архивы дней завершенных месяцев
+func (a *Archiver) compactMonths(ctx context.Context, limit time.Time, planned []string, opts CompactOptions, stats *CompactStats) error {
+	loc := a.now().Location()
+	daily, _ := a.fs.Glob(filepath.Join(a.archiveDir, "[0-9][0-9][0-9][0-9]", "[0-9][0-9]", "[0-9][0-9]", "access_*.log"+a.codec.Extension()))
+	seen := map[string]bool{}
+	groups := map[compactKey][]compactInput{}
+	for _, path := range append(daily, planned...) {
+		input, ok := a.parseCompactInput(path, loc)
+		if seen[path] || !ok || !input.daily {
+			continue
+		}
+		seen[path] = true
+		month := time.Date(input.day.Year(), input.day.Month(), 1, 0, 0, 0, 0, loc)
+		if month.AddDate(0, 1, 0).After(limit) {
+			continue
+		}
+		key := compactKey{input.node, month}
+		groups[key] = append(groups[key], input)
+	}
+
+	for _, key := range sortedKeys(groups) {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("уплотнение прервано: %w", err)
+		}
+		month, inputs := key.day, groups[key]
+		sortInputs(inputs)
+		target := a.monthlyArchive(key.node, month)
+		paths := inputPaths(inputs)
+		if a.exists(target) {
+			paths = append([]string{target}, paths...)
+		}
+		compacted, err := a.compactInto(target, paths, month.AddDate(0, 1, 0), opts.DryRun)
+		if err != nil {
+			return err
+		}
+		stats.add(compacted)
+		if !opts.DryRun {
+			for _, input := range inputs {
+				a.removeEmptyDir(filepath.Dir(input.path))
+			}
+		}
+	}
+	return nil
+}
+
+// parseCompactInput разбирает имя сжатого архива дня или периода внутри дня
+func (a *Archiver) parseCompactInput(path string, loc *time.Location) (compactInput, bool) {
+	name := filepath.Base(path)
+	if !strings.HasSuffix(name, a.codec.Extension()) {
+		return compactInput{}, false
+	}
+	info, ok := a.naming.Parse(strings.TrimSuffix(name, a.codec.Extension()), loc)
+	if !ok || info.Layout == LAYOUT_MONTH {
+		return compactInput{}, false
+	}
+	start := info.Start
+	return compactInput{
+		path:  path,
+		node:  info.Node,
+		day:   time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc),
+		start: start,
+		seq:   info.Seq,
+		part:  info.Part,
+		daily: info.Layout == LAYOUT_DAY,
+	}, true
+}
+
+// compactInto объединяет строки inputs в архив target и удаляет inputs, если число строк совпало.
+// Порядок шагов позволяет после сбоя на любом из них завершить уплотнение без потерь и дублей
+func (a *Archiver) compactInto(target string, inputs []string, end time.Time, dryRun bool) (CompactedArchive, error) {
+	result := CompactedArchive{Archive: target, Inputs: inputs}
+	if dryRun {
+		return result, nil
+	}
+	if err := a.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return result, fmt.Errorf("ошибка создания директории %s: %v", filepath.Dir(target), err)
+	}
+
+	tmp := target + ".tmp"
+	lines, err := a.writeCompacted(tmp, inputs)
+	if err != nil {
+		a.fs.Remove(tmp)
+		return result, fmt.Errorf("ошибка создания архива %s: %v", target, err)
+	}
+	written, err := a.countArchiveLines(tmp)
+	if err != nil || written != lines {
+		a.fs.Remove(tmp)
+		if err == nil {
+			err = fmt.Errorf("в архиве %d строк вместо %d", written, lines)
+		}
+		return result, fmt.Errorf("проверка архива %s не пройдена, исходные архивы не тронуты: %v", target, err)
+	}
+	result.Lines = lines
+
+	journal, err := json.Marshal(compactJournal{Archive: target, Inputs: inputs, Lines: lines})
+	if err != nil {
+		return result, err
+	}
+	journalPath := filepath.Join(a.archiveDir, COMPACT_JOURNAL)
+	if err := a.fs.WriteFile(journalPath, journal, 0644); err != nil {
+		a.fs.Remove(tmp)
+		return result, fmt.Errorf("ошибка записи журнала уплотнения: %v", err)
+	}
+	if err := a.fs.Rename(tmp, target); err != nil {
+		a.fs.Remove(tmp)
+		a.fs.Remove(journalPath)
+		return result, fmt.Errorf("ошибка создания архива %s: %v", target, err)
+	}
+	// Время архива - конец дня или месяца, как у архивов, созданных архиватором
+	a.fs.Chtimes(target, end, end)
+	if err := a.removeInputs(target, inputs); err != nil {
+		return result, err
+	}
+	a.log.Info("Архивы объединены", "archive", target, "inputs", len(inputs), "lines", lines)
+	return result, a.fs.Remove(journalPath)
+}
+
+// writeCompacted сжимает строки inputs по порядку в path и возвращает их число
+func (a *Archiver) writeCompacted(path string, inputs []string) (int, error) {
+	dst, err := a.fs.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	lines := 0
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(a.copyLines(writer, inputs, &lines))
+	}()
+	err = a.codec.Compress(dst, reader)
+	reader.CloseWithError(io.ErrClosedPipe)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return lines, err
+}
+
+// copyLines копирует строки архивов в w, дописывая перевод строки в конце архива, если его нет
+func (a *Archiver) copyLines(w io.Writer, inputs []string, lines *int) error {
+	buffered := bufio.NewWriterSize(w, 64*1024)
+	for _, path := range inputs {
+		src, err := a.openLog(path)
+		if err != nil {
+			return fmt.Errorf("ошибка открытия %s: %v", path, err)
+		}
+		reader := bufio.NewReaderSize(src, 64*1024)
+		for {
+			line, readErr := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				if line[len(line)-1] != '\n' {
+					line = append(line, '\n')
+				}
+				if _, err := buffered.Write(line); err != nil {
+					src.Close()
+					return err
+				}
+				*lines++
+			}
+			if readErr == io.EOF {
+				break
+			}
+			if readErr != nil {
+				src.Close()
+				return fmt.Errorf("ошибка чтения %s: %v", path, readErr)
+			}
+		}
+		src.Close()
+	}
+	return buffered.Flush()
+}
+
+// countArchiveLines считает строки в сжатом архиве
+func (a *Archiver) countArchiveLines(path string) (int, error) {
+	file, err := a.fs.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader, err := a.codec.Decompress(file)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	lines := 0
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := reader.Read(buf)
+		for _, b := range buf[:n] {
+			if b == '\n' {
+				lines++
+			}
+		}
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+	}
+}
+
+// recoverCompaction завершает уплотнение, прерванное сбоем. Если новый архив успел занять свое
+// место (в нем записанное в журнал число строк), удаляются оставшиеся исходные архивы,
+// иначе исходные архивы не тронуты и удаляется только временный файл
+func (a *Archiver) recoverCompaction() error {
+	journalPath := filepath.Join(a.archiveDir, COMPACT_JOURNAL)
+	data, err := a.fs.ReadFile(journalPath)
+	if err != nil {
+		return nil
+	}
+	var journal compactJournal
+	if err := json.Unmarshal(data, &journal); err == nil && journal.Archive != "" {
+		if lines, err := a.countArchiveLines(journal.Archive); err == nil && lines == journal.Lines {
+			if err := a.removeInputs(journal.Archive, journal.Inputs); err != nil {
+				return err
+			}
+			a.log.Warn("Завершено прерванное уплотнение", "archive", journal.Archive)
+		}
+		a.fs.Remove(journal.Archive + ".tmp")
+	}
+	return a.fs.Remove(journalPath)
+}
+
+// removeInputs удаляет исходные архивы, кроме самого архива target, если он был среди них
+func (a *Archiver) removeInputs(target string, inputs []string) error {
+	for _, path := range inputs {
+		if path == target || !a.exists(path) {
+			continue
+		}
+		if err := a.fs.Remove(path); err != nil {
+			return fmt.Errorf("ошибка удаления объединенного архива %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// removeEmptyDir удаляет директорию дня, если в ней ничего не осталось
+func (a *Archiver) removeEmptyDir(dir string) {
+	if entries, err := a.fs.ReadDir(dir); err == nil && len(entries) == 0 {
+		a.fs.Remove(dir)
+	}
+}
+
+func (s *CompactStats) add(archive CompactedArchive) {
+	s.Archives = append(s.Archives, archive)
+	s.Lines += archive.Lines
+	for _, input := range archive.Inputs {
+		if input != archive.Archive {
+			s.Inputs++
+		}
+	}
+}
+
+func sortedKeys(groups map[compactKey][]compactInput) []compactKey {
+	keys := make([]compactKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].day.Equal(keys[j].day) {
+			return keys[i].day.Before(keys[j].day)
+		}
+		return keys[i].node < keys[j].node
+	})
+	return keys
+}
+
+// sortInputs упорядочивает архивы по времени строк: по дню, началу периода, суффиксу
+// повторного архива и номеру части
+func sortInputs(inputs []compactInput) {
+	rank := func(part int) int {
+		if part == 0 {
+			return int(^uint(0) >> 1)
+		}
+		return part
+	}
+	sort.Slice(inputs, func(i, j int) bool {
+		x, y := inputs[i], inputs[j]
+		switch {
+		case !x.day.Equal(y.day):
+			return x.day.Before(y.day)
+		case !x.start.Equal(y.start):
+			return x.start.Before(y.start)
+		case x.seq != y.seq:
+			return x.seq < y.seq
+		}
+		return rank(x.part) < rank(y.part)
+	})
+}
+
+func inputPaths(inputs []compactInput) []string {
+	paths := make([]string, len(inputs))
+	for i, input := range inputs {
+		paths[i] = input.path
+	}
+	return paths
+}
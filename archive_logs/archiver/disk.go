@@ -0,0 +1,212 @@
+package archiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"xui_log_archiver/fsys"
+)
+
+// DEFAULT_MIN_FREE - свободное место, ниже которого архиватор переходит в аварийный режим,
+// если в настройках не задано другое
+const DEFAULT_MIN_FREE = 100 << 20
+
+// Alert - сообщение о входе в аварийный режим из-за нехватки места или о выходе из него
+type Alert struct {
+	Time time.Time `json:"time"`
+	// Emergency - true при входе в аварийный режим, false после освобождения места
+	Emergency bool   `json:"emergency"`
+	FreeBytes int64  `json:"free_bytes"`
+	Message   string `json:"message"`
+}
+
+// MaxCompressor - кодек, который умеет сжимать сильнее за счет времени. В аварийном режиме
+// архиватор сжимает им накопитель и несжатые архивы
+type MaxCompressor interface {
+	MaxCompression() Codec
+}
+
+// FreeSpace возвращает свободное место для накопителя и архивов: меньшее из двух,
+// если они в разных файловых системах
+func (a *Archiver) FreeSpace() (int64, error) {
+	free, err := a.fs.Free(a.archiveDir)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := a.fs.Free(filepath.Dir(a.tempHourlyLog))
+	if err != nil {
+		return 0, err
+	}
+	return min(free, pending), nil
+}
+
+// guardDisk проверяется перед каждой записью в накопитель и сжатием. Если места меньше minFree,
+// архиватор переходит в аварийный режим: перестает читать access.log, чтобы строки копились там,
+// а не в накопителе, освобождает место и возвращает ErrLowDiskSpace. Режим снимается,
+// когда свободно не меньше resumeFree
+func (a *Archiver) guardDisk(stats *RunStats) error {
+	if a.minFree <= 0 {
+		return nil
+	}
+	free, err := a.FreeSpace()
+	if err != nil {
+		a.log.Debug("Свободное место не проверено", "error", err)
+		return nil
+	}
+	stats.FreeBytes = free
+
+	state := a.loadRunState()
+	threshold := a.minFree
+	if state.DiskEmergency != nil {
+		threshold = a.resumeFree
+	}
+	if free >= threshold {
+		if state.DiskEmergency != nil {
+			since := *state.DiskEmergency
+			state.DiskEmergency = nil
+			if err := a.saveRunState(state); err != nil {
+				a.log.Warn("Не удалось сохранить итоги запуска", "file", a.runStateFile, "error", err)
+			}
+			a.log.Info("Место освободилось, аварийный режим снят", "free", free, "since", since)
+			a.alert(false, free, fmt.Sprintf("место освободилось: свободно %d МБ, архивирование продолжено", free>>20))
+		}
+		return nil
+	}
+
+	stats.DiskEmergency = true
+	if state.DiskEmergency == nil {
+		now := a.clock.Now()
+		state.DiskEmergency = &now
+		if err := a.saveRunState(state); err != nil {
+			a.log.Warn("Не удалось сохранить итоги запуска", "file", a.runStateFile, "error", err)
+		}
+		a.alert(true, free, fmt.Sprintf("мало места: свободно %d МБ, нужно не меньше %d МБ. Новые строки остаются в %s",
+			free>>20, threshold>>20, a.logFile))
+	}
+	a.log.Error("Мало места на диске, аварийный режим", "free", free, "min_free", threshold)
+	a.observeError("disk")
+	a.relieveDisk(stats)
+	return fmt.Errorf("%w: свободно %d МБ, нужно не меньше %d МБ", ErrLowDiskSpace, free>>20, threshold>>20)
+}
+
+// relieveDisk освобождает место: сжимает несжатые архивы и накопитель с максимальным сжатием,
+// а если этого мало - удаляет самые старые архивы старше emergencyKeep
+func (a *Archiver) relieveDisk(stats *RunStats) {
+	if m, ok := a.codec.(MaxCompressor); ok {
+		codec := a.codec
+		a.codec = m.MaxCompression()
+		defer func() { a.codec = codec }()
+	}
+
+	for _, leftover := range a.UncompressedArchives() {
+		archive, err := a.compressFile(leftover)
+		if err != nil {
+			a.log.Warn("Не удалось сжать архив", "file", leftover, "error", err)
+			continue
+		}
+		a.log.Info("Несжатый архив сжат", "archive", archive)
+	}
+
+	// Накопитель сжимается, только если в нем что-то есть: пустой архив места не освободит
+	if a.PendingBytes() > 0 {
+		now := a.now()
+		var err error
+		if a.accumulatorPeriod(now).Before(a.period.Start(now)) {
+			err = a.rollover(stats, now)
+		} else {
+			err = a.sealPart(stats, now)
+		}
+		if err != nil {
+			a.log.Warn("Не удалось сжать накопитель", "error", err)
+		}
+	}
+
+	a.applyEmergencyRetention(stats)
+}
+
+// applyEmergencyRetention удаляет архивы старше emergencyKeep, начиная с самых старых,
+// пока свободного места не станет resumeFree. Архивы из очереди отправки удаляются последними:
+// вместе с архивом из очереди убирается и его запись, отправлять уже нечего
+func (a *Archiver) applyEmergencyRetention(stats *RunStats) {
+	if a.emergencyKeep <= 0 {
+		return
+	}
+	cutoff := a.clock.Now().Add(-a.emergencyKeep)
+	type archive struct {
+		path    string
+		modTime time.Time
+	}
+	var old []archive
+	fsys.Walk(a.fs, a.archiveDir, func(path string, info fs.FileInfo) error {
+		name := info.Name()
+		if strings.HasPrefix(name, "access_") && !strings.HasSuffix(name, ".tmp") && info.ModTime().Before(cutoff) {
+			old = append(old, archive{path, info.ModTime()})
+		}
+		return nil
+	})
+	queued := a.queuedArchives()
+	sort.Slice(old, func(i, j int) bool {
+		_, iQueued := queued[old[i].path]
+		_, jQueued := queued[old[j].path]
+		if iQueued != jQueued {
+			return jQueued
+		}
+		return old[i].modTime.Before(old[j].modTime)
+	})
+
+	for _, archive := range old {
+		if free, err := a.FreeSpace(); err != nil || free >= a.resumeFree {
+			return
+		}
+		if err := a.fs.Remove(archive.path); err != nil {
+			a.log.Warn("Не удалось удалить архив", "file", archive.path, "error", err)
+			continue
+		}
+		stats.DeletedArchives = append(stats.DeletedArchives, archive.path)
+		a.log.Warn("Архив удален для освобождения места", "file", archive.path, "created", archive.modTime)
+		if item, ok := queued[archive.path]; ok {
+			if err := a.fs.Remove(item); err != nil {
+				a.log.Warn("Не удалось убрать архив из очереди отправки", "file", item, "error", err)
+			}
+			a.log.Warn("Архив удален до отправки во внешнее хранилище, отправка отменена", "file", archive.path)
+		}
+	}
+}
+
+// queuedArchives возвращает архивы из очереди отправки (shipper) и файлы их записей в очереди
+func (a *Archiver) queuedArchives() map[string]string {
+	queued := map[string]string{}
+	if a.outboxDir == "" {
+		return queued
+	}
+	files, err := a.fs.Glob(filepath.Join(a.outboxDir, "*.json"))
+	if err != nil {
+		return queued
+	}
+	for _, file := range files {
+		data, err := a.fs.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var item struct {
+			Path string `json:"path"`
+		}
+		if json.Unmarshal(data, &item) == nil && item.Path != "" {
+			queued[filepath.Clean(item.Path)] = file
+		}
+	}
+	return queued
+}
+
+// alert сообщает о входе в аварийный режим или выходе из него
+func (a *Archiver) alert(emergency bool, free int64, message string) {
+	if a.onAlert == nil {
+		return
+	}
+	a.onAlert(Alert{Time: a.clock.Now(), Emergency: emergency, FreeBytes: free, Message: message})
+}
@@ -0,0 +1,161 @@
+package archiver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"xui_log_archiver/fsys"
+	"xui_log_archiver/xraylog"
+)
+
+// RunState хранит итоги запусков между вызовами из cron
+type RunState struct {
+	LastRun       *time.Time `json:"last_run,omitempty"`
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	// PeriodStart - начало периода, строки которого сейчас в накопителе
+	PeriodStart *time.Time `json:"period_start,omitempty"`
+	// Truncation - усечение access.log, прерванное сбоем
+	Truncation *Truncation `json:"truncation,omitempty"`
+	// DiskEmergency - с какого момента архиватор в аварийном режиме из-за нехватки места
+	DiskEmergency *time.Time `json:"disk_emergency,omitempty"`
+}
+
+// Health описывает состояние архивирования в момент проверки
+type Health struct {
+	RunState
+	LagBytes        int64      `json:"lag_bytes"`
+	PendingBytes    int64      `json:"pending_bytes"`
+	PendingSince    *time.Time `json:"pending_since,omitempty"`
+	PendingAge      string     `json:"pending_age,omitempty"`
+	NewestArchive   string     `json:"newest_archive,omitempty"`
+	NewestArchiveAt *time.Time `json:"newest_archive_time,omitempty"`
+	Rollover        string     `json:"rollover"`
+	NextRollover    time.Time  `json:"next_rollover"`
+	// NextArchive - имя архива, в который попадут строки накопителя
+	NextArchive     string `json:"next_archive"`
+	ArchiveDirBytes int64  `json:"archive_dir_bytes"`
+	ArchiveCount    int    `json:"archive_count"`
+}
+
+// Health собирает состояние архивирования: итоги последнего запуска, отставание от access.log,
+// накопитель, последний архив и использование диска
+func (a *Archiver) Health(now time.Time) Health {
+	health := Health{
+		RunState:     a.loadRunState(),
+		LagBytes:     a.LagBytes(),
+		PendingBytes: a.PendingBytes(),
+		Rollover:     a.period.String(),
+		NextRollover: a.period.Next(a.naming.In(now)),
+	}
+	health.NextArchive = a.naming.ArchiveName(a.period, a.accumulatorPeriod(a.naming.In(now))) + a.codec.Extension()
+
+	if health.PendingBytes > 0 {
+		if since, ok := a.pendingSince(); ok {
+			health.PendingSince = &since
+			health.PendingAge = now.Sub(since).Truncate(time.Second).String()
+		}
+	}
+
+	if newest, at := a.newestArchive(); newest != "" {
+		health.NewestArchive, health.NewestArchiveAt = newest, &at
+	}
+	health.ArchiveDirBytes, health.ArchiveCount = a.ArchiveDirUsage()
+	return health
+}
+
+// Position возвращает позицию в access.log, до которой он обработан
+func (a *Archiver) Position() int64 {
+	return a.getLastProcessedPosition()
+}
+
+// UncompressedArchives возвращает архивы периодов, которые остались несжатыми после сбоя
+func (a *Archiver) UncompressedArchives() []string {
+	matches, _ := a.fs.Glob(filepath.Join(a.archiveDir, "access_*.log"))
+	return matches
+}
+
+// pendingSince возвращает время первой записи в накопителе
+func (a *Archiver) pendingSince() (time.Time, bool) {
+	file, err := a.fs.Open(a.tempHourlyLog)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// Время в access.log записано без зоны, в зоне часов архиватора
+		if t, _, ok := xraylog.ParseTimeIn(scanner.Text(), a.clock.Now().Location()); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// newestArchive возвращает самый свежий архив access_*.log[.gz], в том числе уплотненный
+// в YYYY/MM/DD, и время его создания
+func (a *Archiver) newestArchive() (string, time.Time) {
+	var newest string
+	var newestAt time.Time
+	fsys.Walk(a.fs, a.archiveDir, func(path string, info fs.FileInfo) error {
+		name := info.Name()
+		if !strings.HasPrefix(name, "access_") || strings.HasSuffix(name, ".tmp") {
+			return nil
+		}
+		if info.ModTime().After(newestAt) {
+			newest, newestAt = path, info.ModTime()
+		}
+		return nil
+	})
+	return newest, newestAt
+}
+
+func (a *Archiver) loadRunState() RunState {
+	var state RunState
+	data, err := a.fs.ReadFile(a.runStateFile)
+	if err != nil {
+		return state
+	}
+	json.Unmarshal(data, &state)
+	return state
+}
+
+// recordRun сохраняет итоги запуска. Ошибка записи не должна ломать архивирование
+func (a *Archiver) recordRun(stats RunStats, runErr error) {
+	state := a.loadRunState()
+	start, finish := stats.StartTime, stats.StartTime.Add(stats.Duration)
+	state.LastRun = &start
+	if runErr == nil {
+		state.LastSuccess = &finish
+	} else {
+		state.LastError = runErr.Error()
+		state.LastErrorTime = &start
+	}
+	if !a.periodStart.IsZero() {
+		periodStart := a.periodStart
+		state.PeriodStart = &periodStart
+	}
+	if err := a.saveRunState(state); err != nil {
+		a.log.Warn("Не удалось сохранить итоги запуска", "file", a.runStateFile, "error", err)
+	}
+}
+
+// saveRunState атомарно записывает итоги запусков
+func (a *Archiver) saveRunState(state RunState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := a.runStateFile + ".tmp"
+	if err := a.fs.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return a.fs.Rename(tmp, a.runStateFile)
+}
@@ -0,0 +1,153 @@
+package archiver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// HISTORY_MAX_BYTES - размер журнала запусков, после которого он ротируется.
+	// Запись занимает около 100 байт, при запуске каждые 10 минут это больше года истории
+	HISTORY_MAX_BYTES = 1 << 20
+	// HISTORY_BACKUPS - сколько ротированных копий журнала хранить (.1, .2, ...)
+	HISTORY_BACKUPS = 3
+)
+
+// HistoryRecord - запись журнала об одном запуске архивирования
+type HistoryRecord struct {
+	Start      time.Time `json:"start"`
+	DurationMs int64     `json:"ms"`
+	Bytes      int64     `json:"bytes"`
+	Lines      int       `json:"lines"`
+	RolledOver bool      `json:"rolled,omitempty"`
+	Archive    string    `json:"archive,omitempty"`
+	Parts      int       `json:"parts,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// HistoryBucket - итоги запусков за один период
+type HistoryBucket struct {
+	Start    time.Time `json:"start"`
+	Runs     int       `json:"runs"`
+	Errors   int       `json:"errors"`
+	Bytes    int64     `json:"bytes"`
+	Lines    int       `json:"lines"`
+	Archives int       `json:"archives"`
+	// MaxDurationMs - самый долгий запуск за период
+	MaxDurationMs int64 `json:"max_ms"`
+}
+
+// appendHistory дописывает итоги запуска в журнал, при необходимости ротируя его
+func (a *Archiver) appendHistory(stats RunStats, runErr error) {
+	record := HistoryRecord{
+		Start:      stats.StartTime.Truncate(time.Millisecond),
+		DurationMs: stats.Duration.Milliseconds(),
+		Bytes:      stats.BytesProcessed,
+		Lines:      stats.LinesProcessed,
+		RolledOver: stats.RolledOver,
+		Parts:      len(stats.PartFiles),
+	}
+	if stats.ArchiveFile != "" {
+		record.Archive = filepath.Base(stats.ArchiveFile)
+	}
+	if runErr != nil {
+		record.Error = runErr.Error()
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	if info, err := a.fs.Stat(a.historyFile); err == nil && info.Size()+int64(len(line)) > HISTORY_MAX_BYTES {
+		a.rotateHistory()
+	}
+
+	file, err := a.fs.OpenFile(a.historyFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		a.log.Warn("Не удалось записать журнал запусков", "file", a.historyFile, "error", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(line); err != nil {
+		a.log.Warn("Не удалось записать журнал запусков", "file", a.historyFile, "error", err)
+	}
+}
+
+// rotateHistory сдвигает копии журнала: history -> history.1 -> history.2 ...
+func (a *Archiver) rotateHistory() {
+	path := a.historyFile
+	for n := HISTORY_BACKUPS; n > 1; n-- {
+		a.fs.Rename(fmt.Sprintf("%s.%d", path, n-1), fmt.Sprintf("%s.%d", path, n))
+	}
+	a.fs.Rename(path, path+".1")
+}
+
+// History возвращает записи журнала запусков начиная с since, от старых к новым.
+// Поврежденные строки, например оборванные при сбое, пропускаются
+func (a *Archiver) History(since time.Time) ([]HistoryRecord, error) {
+	var records []HistoryRecord
+	files := []string{}
+	for n := HISTORY_BACKUPS; n >= 1; n-- {
+		files = append(files, fmt.Sprintf("%s.%d", a.historyFile, n))
+	}
+	files = append(files, a.historyFile)
+
+	for _, path := range files {
+		file, err := a.fs.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return records, fmt.Errorf("ошибка чтения журнала запусков %s: %v", path, err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record HistoryRecord
+			if json.Unmarshal(scanner.Bytes(), &record) != nil || record.Start.Before(since) {
+				continue
+			}
+			records = append(records, record)
+		}
+		file.Close()
+	}
+	return records, nil
+}
+
+// AggregateHistory группирует записи по периодам p. Периоды без запусков тоже попадают
+// в результат, чтобы пропуски были видны
+func AggregateHistory(records []HistoryRecord, p Period, from, to time.Time) []HistoryBucket {
+	var buckets []HistoryBucket
+	index := map[int64]int{}
+	for start := p.Start(from); start.Before(to); start = p.Next(start) {
+		index[start.Unix()] = len(buckets)
+		buckets = append(buckets, HistoryBucket{Start: start})
+	}
+
+	for _, record := range records {
+		n, ok := index[p.Start(record.Start.In(from.Location())).Unix()]
+		if !ok {
+			continue
+		}
+		bucket := &buckets[n]
+		bucket.Runs++
+		if record.Error != "" {
+			bucket.Errors++
+		}
+		bucket.Bytes += record.Bytes
+		bucket.Lines += record.Lines
+		if record.Archive != "" {
+			bucket.Archives++
+		}
+		bucket.Archives += record.Parts
+		if record.DurationMs > bucket.MaxDurationMs {
+			bucket.MaxDurationMs = record.DurationMs
+		}
+	}
+	return buckets
+}
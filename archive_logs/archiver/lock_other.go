@@ -0,0 +1,8 @@
+//go:build !unix
+
+package archiver
+
+// acquireLock не реализован вне Unix: одновременные запуски не исключаются
+func acquireLock(path string) (func(), error) {
+	return func() {}, nil
+}
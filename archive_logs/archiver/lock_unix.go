@@ -0,0 +1,29 @@
+//go:build unix
+
+package archiver
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// acquireLock захватывает файл блокировки без ожидания. Блокировка снимается и при аварийном
+// завершении процесса, поэтому зависший файл не мешает следующим запускам
+func acquireLock(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,152 @@
+package archiver
+
+import (
+	"io/fs"
+	"strings"
+	"time"
+
+	"xui_log_archiver/fsys"
+	"xui_log_archiver/metrics"
+)
+
+// Metrics - набор метрик Prometheus, которые обновляет архиватор
+type Metrics struct {
+	registry        *metrics.Registry
+	runs            *metrics.Vec
+	errors          *metrics.Vec
+	lines           *metrics.Vec
+	bytes           *metrics.Vec
+	lag             *metrics.Vec
+	lastRun         *metrics.Vec
+	lastSuccess     *metrics.Vec
+	lastArchive     *metrics.Vec
+	archiveDirBytes *metrics.Vec
+	archives        *metrics.Vec
+	duration        *metrics.Vec
+	freeBytes       *metrics.Vec
+	diskEmergency   *metrics.Vec
+}
+
+// errorStages - этапы, для которых счетчик ошибок выводится даже с нулевым значением
+var errorStages = []string{"mkdir", "stat_source", "extract", "position", "archive", "compress", "truncate", "disk"}
+
+// NewMetrics регистрирует метрики архиватора в реестре
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	m := &Metrics{
+		registry: registry,
+		runs: registry.Register("xui_archiver_runs_total",
+			"Количество запусков архивирования", metrics.Counter),
+		errors: registry.Register("xui_archiver_errors_total",
+			"Количество ошибок по этапам архивирования", metrics.Counter, "stage"),
+		lines: registry.Register("xui_archiver_lines_processed_total",
+			"Количество строк, перенесенных из access.log в накопитель", metrics.Counter),
+		bytes: registry.Register("xui_archiver_bytes_processed_total",
+			"Количество байт access.log, обработанных архиватором", metrics.Counter),
+		lag: registry.Register("xui_archiver_lag_bytes",
+			"Сколько байт access.log еще не обработано", metrics.Gauge),
+		lastRun: registry.Register("xui_archiver_last_run_timestamp_seconds",
+			"Время последнего запуска архивирования", metrics.Gauge),
+		lastSuccess: registry.Register("xui_archiver_last_success_timestamp_seconds",
+			"Время последнего успешного запуска архивирования", metrics.Gauge),
+		lastArchive: registry.Register("xui_archiver_last_archive_timestamp_seconds",
+			"Время создания последнего архива", metrics.Gauge),
+		archiveDirBytes: registry.Register("xui_archiver_archive_dir_bytes",
+			"Суммарный размер директории архивов", metrics.Gauge),
+		archives: registry.Register("xui_archiver_archives",
+			"Количество архивов в директории архивов", metrics.Gauge),
+		duration: registry.Register("xui_archiver_operation_duration_seconds",
+			"Длительность последнего выполнения операции", metrics.Gauge, "operation"),
+		freeBytes: registry.Register("xui_archiver_free_bytes",
+			"Свободное место для накопителя и архивов", metrics.Gauge),
+		diskEmergency: registry.Register("xui_archiver_disk_emergency",
+			"1, если архиватор в аварийном режиме из-за нехватки места", metrics.Gauge),
+	}
+	for _, stage := range errorStages {
+		m.errors.Add(0, stage)
+	}
+	return m
+}
+
+// Registry возвращает реестр, в котором зарегистрированы метрики
+func (m *Metrics) Registry() *metrics.Registry {
+	return m.registry
+}
+
+// SetMetrics подключает метрики к архиватору и добавляет вычисляемые при сборе значения
+func (a *Archiver) SetMetrics(m *Metrics) {
+	a.metrics = m
+	m.registry.OnCollect(func() {
+		m.lag.Set(float64(a.LagBytes()))
+		size, count := a.ArchiveDirUsage()
+		m.archiveDirBytes.Set(float64(size))
+		m.archives.Set(float64(count))
+		if free, err := a.FreeSpace(); err == nil {
+			m.freeBytes.Set(float64(free))
+		}
+		emergency := 0.0
+		if a.loadRunState().DiskEmergency != nil {
+			emergency = 1
+		}
+		m.diskEmergency.Set(emergency)
+	})
+}
+
+// LagBytes возвращает количество байт access.log, которые еще не обработаны
+func (a *Archiver) LagBytes() int64 {
+	info, err := a.fs.Stat(a.logFile)
+	if err != nil {
+		return 0
+	}
+	lag := info.Size() - a.getLastProcessedPosition()
+	if lag < 0 {
+		// Файл был очищен, при следующем запуске он будет прочитан с начала
+		return info.Size()
+	}
+	return lag
+}
+
+// ArchiveDirUsage возвращает суммарный размер и количество архивов в директории архивов
+func (a *Archiver) ArchiveDirUsage() (int64, int) {
+	var size int64
+	count := 0
+	fsys.Walk(a.fs, a.archiveDir, func(path string, info fs.FileInfo) error {
+		size += info.Size()
+		if strings.HasSuffix(path, a.codec.Extension()) {
+			count++
+		}
+		return nil
+	})
+	return size, count
+}
+
+func (a *Archiver) observeError(stage string) {
+	if a.metrics != nil {
+		a.metrics.errors.Inc(stage)
+	}
+}
+
+func (a *Archiver) observeDuration(operation string, duration time.Duration) {
+	if a.metrics != nil {
+		a.metrics.duration.Set(duration.Seconds(), operation)
+	}
+}
+
+func (a *Archiver) observeArchive(at time.Time) {
+	if a.metrics != nil {
+		a.metrics.lastArchive.Set(float64(at.Unix()))
+	}
+}
+
+// observeRun обновляет метрики по итогам запуска
+func (a *Archiver) observeRun(stats RunStats, err error) {
+	if a.metrics == nil {
+		return
+	}
+	a.metrics.runs.Inc()
+	a.metrics.lastRun.Set(float64(stats.StartTime.Unix()))
+	a.metrics.lines.Add(float64(stats.LinesProcessed))
+	a.metrics.bytes.Add(float64(stats.BytesProcessed))
+	if err == nil {
+		a.metrics.lastSuccess.Set(float64(stats.StartTime.Add(stats.Duration).Unix()))
+	}
+}
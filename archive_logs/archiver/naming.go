@@ -0,0 +1,255 @@
+package archiver
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_NAMING - шаблон имени архива по умолчанию: access_20261016_00.log.
+// {time} - начало периода, {node} - имя узла
+const DEFAULT_NAMING = "access_{time}.log"
+
+// Время в имени архива
+const (
+	// ZONE_LOCAL - местное время без пояса, как в прежних версиях. При переходе на зимнее
+	// время два разных часа получают одно имя, второй архив - с суффиксом _2
+	ZONE_LOCAL = "local"
+	// ZONE_UTC - время UTC с суффиксом Z: access_20261016_00Z.log. Периоды, дни и месяцы
+	// уплотнения тоже отсчитываются по UTC
+	ZONE_UTC = "utc"
+	// ZONE_OFFSET - местное время со смещением от UTC: access_20261025_02+0200.log
+	ZONE_OFFSET = "offset"
+)
+
+// Точность времени в имени архива
+const (
+	LAYOUT_MONTH  = "200601"
+	LAYOUT_DAY    = "20060102"
+	LAYOUT_HOUR   = "20060102_15"
+	LAYOUT_MINUTE = "20060102_1504"
+	// LAYOUT_LEGACY - время в именах архивов bash-архиватора: access_20261016_010003.log.
+	// Это момент создания архива в начале часа, а в архиве - строки предыдущего часа
+	LAYOUT_LEGACY = "20060102_150405"
+)
+
+// Naming - шаблон имени архива. Нулевое значение - DEFAULT_NAMING в местном времени.
+// После времени архиватор сам добавляет суффикс повторного архива периода _2 и номер части .part2
+type Naming struct {
+	template string
+	node     string
+	zone     string
+	pattern  *regexp.Regexp
+}
+
+var defaultPattern = compileNaming(DEFAULT_NAMING)
+
+// ParseNaming проверяет шаблон имени архива. Шаблон начинается с access_ (по этому префиксу
+// архивы находят merge, дашборд и аварийная очистка), заканчивается на .log и содержит {time}.
+// {node} заменяется на node, а если он пуст - на имя хоста до первой точки
+func ParseNaming(template, node, zone string) (Naming, error) {
+	if template == "" {
+		template = DEFAULT_NAMING
+	}
+	if !strings.HasPrefix(template, "access_") || !strings.HasSuffix(template, ".log") {
+		return Naming{}, fmt.Errorf("шаблон имени архива %q должен начинаться с access_ и заканчиваться на .log", template)
+	}
+	if strings.Count(template, "{time}") != 1 || strings.Count(template, "{node}") > 1 {
+		return Naming{}, fmt.Errorf("в шаблоне имени архива %q должен быть один {time} и не больше одного {node}", template)
+	}
+	if rest := strings.NewReplacer("{time}", "", "{node}", "").Replace(template); strings.ContainsAny(rest, "{}/") {
+		return Naming{}, fmt.Errorf("в шаблоне имени архива %q допустимы только подстановки {time} и {node}", template)
+	}
+
+	n := Naming{template: template, zone: strings.ToLower(strings.TrimSpace(zone))}
+	switch n.zone {
+	case "":
+		n.zone = ZONE_LOCAL
+	case ZONE_LOCAL, ZONE_UTC, ZONE_OFFSET:
+	default:
+		return Naming{}, fmt.Errorf("некорректное время в имени архива %q: ожидается local, utc или offset", zone)
+	}
+
+	if strings.Contains(template, "{node}") {
+		if node == "" {
+			host, err := os.Hostname()
+			if err != nil {
+				return Naming{}, fmt.Errorf("не удалось определить имя узла для {node}: %v, задайте его явно", err)
+			}
+			node, _, _ = strings.Cut(host, ".")
+		}
+		n.node = sanitizeNode(node)
+		if n.node == "" {
+			return Naming{}, fmt.Errorf("имя узла %q не подходит для имени архива: нужны латинские буквы, цифры или -", node)
+		}
+	}
+	n.pattern = compileNaming(template)
+	return n, nil
+}
+
+// sanitizeNode оставляет в имени узла латинские буквы, цифры и -: точка и _ в имени архива
+// отделяют номер части и повторного архива
+func sanitizeNode(node string) string {
+	var b strings.Builder
+	for _, r := range node {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// compileNaming строит выражение, которое разбирает имена по шаблону, в том числе
+// с суффиксами _2 и .part2, без расширения сжатия
+func compileNaming(template string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(strings.TrimSuffix(template, ".log"))
+	expr = strings.Replace(expr, regexp.QuoteMeta("{time}"), `(?P<time>\d{8}_\d{6}|\d{6}|\d{8}(?:_\d{2}|_\d{4})?)(?P<zone>Z|[+-]\d{4})?`, 1)
+	expr = strings.Replace(expr, regexp.QuoteMeta("{node}"), `(?P<node>[A-Za-z0-9-]+)`, 1)
+	return regexp.MustCompile(`^` + expr + `(?:_(?P<seq>\d+))?(?:\.part(?P<part>\d+))?\.log$`)
+}
+
+// Template возвращает шаблон имени
+func (n Naming) Template() string {
+	if n.template == "" {
+		return DEFAULT_NAMING
+	}
+	return n.template
+}
+
+// Node возвращает имя узла, которое подставляется в {node}
+func (n Naming) Node() string {
+	return n.node
+}
+
+// Zone возвращает время в имени: local, utc или offset
+func (n Naming) Zone() string {
+	if n.zone == "" {
+		return ZONE_LOCAL
+	}
+	return n.zone
+}
+
+// In переводит t в часовой пояс имен: с ZONE_UTC периоды отсчитываются по UTC
+func (n Naming) In(t time.Time) time.Time {
+	if n.zone == ZONE_UTC {
+		return t.UTC()
+	}
+	return t
+}
+
+// ArchiveName возвращает имя архива периода p, который начался в start
+func (n Naming) ArchiveName(p Period, start time.Time) string {
+	return n.name(n.node, p.layout(), start)
+}
+
+// name возвращает имя архива узла node со временем start с точностью layout. Если время
+// в поясе имени не попадает на границу layout, например сутки по местному времени в UTC,
+// время записывается с минутами, чтобы имя не указывало на другой период
+func (n Naming) name(node, layout string, start time.Time) string {
+	t := n.In(start)
+	if n.Zone() != ZONE_LOCAL && !n.exact(layout, t) {
+		layout = LAYOUT_MINUTE
+	}
+	stamp := t.Format(layout)
+	switch n.Zone() {
+	case ZONE_UTC:
+		stamp += "Z"
+	case ZONE_OFFSET:
+		stamp += t.Format("-0700")
+	}
+	return strings.NewReplacer("{time}", stamp, "{node}", node).Replace(n.Template())
+}
+
+func (n Naming) exact(layout string, t time.Time) bool {
+	parsed, err := time.Parse(layout+"-0700", t.Format(layout+"-0700"))
+	return err == nil && parsed.Equal(t)
+}
+
+// fileName возвращает имя архива из сведений о нем с суффиксами повторного архива и части
+func (n Naming) fileName(node, layout string, start time.Time, seq, part int) string {
+	name := strings.TrimSuffix(n.name(node, layout, start), ".log")
+	if seq > 0 {
+		name += fmt.Sprintf("_%d", seq)
+	}
+	if part > 0 {
+		name += fmt.Sprintf(".part%d", part)
+	}
+	return name + ".log"
+}
+
+// ArchiveInfo - сведения об архиве из его имени
+type ArchiveInfo struct {
+	Node  string    `json:"node,omitempty"`
+	Start time.Time `json:"start"`
+	// Layout - точность времени: LAYOUT_MONTH у архива месяца, LAYOUT_DAY у архива дня и т.д.
+	Layout string `json:"layout"`
+	// Zone - пояс из имени: Z, смещение вроде +0300 или пусто для местного времени
+	Zone string `json:"zone,omitempty"`
+	// Seq - номер повторного архива периода (_2), 0 - основной архив
+	Seq int `json:"seq,omitempty"`
+	// Part - номер части (.part2), 0 - не часть
+	Part int `json:"part,omitempty"`
+}
+
+// Parse разбирает имя архива без расширения сжатия. Время без пояса считается временем в loc,
+// время с поясом переводится в loc
+func (n Naming) Parse(name string, loc *time.Location) (ArchiveInfo, bool) {
+	pattern := n.pattern
+	if pattern == nil {
+		pattern = defaultPattern
+	}
+	match := pattern.FindStringSubmatch(name)
+	if match == nil {
+		return ArchiveInfo{}, false
+	}
+	group := func(name string) string {
+		if i := pattern.SubexpIndex(name); i >= 0 {
+			return match[i]
+		}
+		return ""
+	}
+
+	info := ArchiveInfo{Node: group("node"), Zone: group("zone")}
+	stamp := group("time")
+	switch len(stamp) {
+	case len(LAYOUT_LEGACY):
+		info.Layout = LAYOUT_LEGACY
+	case len(LAYOUT_MONTH):
+		info.Layout = LAYOUT_MONTH
+	case len(LAYOUT_DAY):
+		info.Layout = LAYOUT_DAY
+	case len(LAYOUT_HOUR):
+		info.Layout = LAYOUT_HOUR
+	default:
+		info.Layout = LAYOUT_MINUTE
+	}
+
+	var err error
+	switch {
+	case info.Zone == "Z":
+		info.Start, err = time.ParseInLocation(info.Layout, stamp, time.UTC)
+	case info.Zone != "":
+		info.Start, err = time.Parse(info.Layout+"-0700", stamp+info.Zone)
+	default:
+		info.Start, err = time.ParseInLocation(info.Layout, stamp, loc)
+	}
+	if err != nil {
+		return ArchiveInfo{}, false
+	}
+	info.Start = info.Start.In(loc)
+	if info.Layout == LAYOUT_LEGACY {
+		// Архив часа, который закончился к моменту создания архива
+		start := info.Start
+		info.Start = time.Date(start.Year(), start.Month(), start.Day(), start.Hour()-1, 0, 0, 0, loc)
+		info.Layout = LAYOUT_HOUR
+	}
+	info.Seq, _ = strconv.Atoi(group("seq"))
+	info.Part, _ = strconv.Atoi(group("part"))
+	return info, true
+}
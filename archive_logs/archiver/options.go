@@ -0,0 +1,181 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"xui_log_archiver/fsys"
+)
+
+// Ошибки запуска архивирования. Проверяются через errors.Is
+var (
+	// ErrSourceMissing - access.log не существует: Xray не запущен или лог отключен
+	ErrSourceMissing = errors.New("access.log не найден")
+	// ErrLocked - другой запуск для тех же файлов еще выполняется
+	ErrLocked = errors.New("архивирование уже выполняется другим процессом")
+	// ErrRotationDetected - access.log стал меньше обработанной позиции: его очистили или заменили.
+	// Возвращается только с Options.FailOnRotation, иначе чтение продолжается с начала файла
+	ErrRotationDetected = errors.New("access.log был очищен или заменен")
+	// ErrLowDiskSpace - свободного места меньше Options.MinFree: архиватор в аварийном режиме
+	// и не читает access.log, пока место не освободится
+	ErrLowDiskSpace = errors.New("мало места на диске")
+)
+
+// AcquireLock захватывает файл блокировки без ожидания, как это делают запуски архиватора:
+// для других процессов, работающих с теми же файлами. Если файл занят, возвращает ErrLocked
+func AcquireLock(path string) (func(), error) {
+	return acquireLock(path)
+}
+
+// Clock - источник текущего времени. Позволяет проверять смену периодов без ожидания
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Codec сжимает накопитель в архив
+type Codec interface {
+	// Extension - расширение сжатого архива, например ".gz"
+	Extension() string
+	// Compress сжимает src в dst
+	Compress(dst io.Writer, src io.Reader) error
+	// Decompress открывает сжатый архив для чтения
+	Decompress(src io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCodec сжимает архивы в gzip, их читают merge, дашборд и zcat
+type GzipCodec struct {
+	// Level - уровень сжатия gzip, 0 - по умолчанию
+	Level int
+}
+
+func (GzipCodec) Extension() string { return ".gz" }
+
+func (c GzipCodec) Compress(dst io.Writer, src io.Reader) error {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	writer, err := gzip.NewWriterLevel(dst, level)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, src); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// MaxCompression возвращает gzip с наилучшим сжатием
+func (GzipCodec) MaxCompression() Codec { return GzipCodec{Level: gzip.BestCompression} }
+
+func (GzipCodec) Decompress(src io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(src)
+}
+
+// Options - настройки архиватора для NewWithOptions. Все поля необязательные
+type Options struct {
+	// Paths - файлы экземпляра x-ui, по умолчанию DefaultPaths()
+	Paths Paths
+	// Clock - источник времени, по умолчанию системные часы
+	Clock Clock
+	// Codec - сжатие архивов, по умолчанию GzipCodec
+	Codec Codec
+	// FS - файловая система, по умолчанию fsys.OS. Блокировка запуска всегда берется
+	// в файловой системе ОС
+	FS fsys.FS
+	// Logger - журнал архиватора, по умолчанию сообщения отбрасываются
+	Logger *slog.Logger
+	// Period - период архива, по умолчанию HOURLY
+	Period Period
+	// Naming - шаблон имени архива, по умолчанию DEFAULT_NAMING в местном времени
+	Naming Naming
+	// MaxPending - максимальный размер накопителя в байтах, 0 - без ограничения
+	MaxPending int64
+	// TruncateAbove - размер access.log в байтах, после которого архиватор сам усекает его,
+	// как только все прочитанное попало в архивы. 0 - не усекать
+	TruncateAbove int64
+	// TruncateKeep - сколько последних байт оставить при усечении, по умолчанию DEFAULT_TRUNCATE_KEEP
+	TruncateKeep int64
+	// MinFree - свободное место в байтах, ниже которого архиватор переходит в аварийный режим.
+	// 0 - место не проверяется
+	MinFree int64
+	// ResumeFree - свободное место, при котором аварийный режим снимается, по умолчанию 2*MinFree
+	ResumeFree int64
+	// EmergencyKeep - в аварийном режиме удаляются архивы старше этого срока, пока места
+	// не станет ResumeFree. 0 - архивы не удаляются
+	EmergencyKeep time.Duration
+	// Alert вызывается при входе в аварийный режим и выходе из него
+	Alert func(Alert)
+	// FailOnRotation - вернуть ErrRotationDetected вместо чтения очищенного access.log с начала,
+	// не меняя позицию, чтобы вызывающий код сначала обработал замененный файл
+	FailOnRotation bool
+}
+
+// NewWithOptions создает архиватор для встраивания в другие программы: ничего не пишет
+// в stdout и в файлы журнала, пока это не задано в Options или через SetLogging
+func NewWithOptions(opts Options) *Archiver {
+	if opts.Paths == (Paths{}) {
+		opts.Paths = DefaultPaths()
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.Codec == nil {
+		opts.Codec = GzipCodec{}
+	}
+	if opts.FS == nil {
+		opts.FS = fsys.OS
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if opts.Period.Duration() == 0 {
+		opts.Period = HOURLY
+	}
+	if opts.TruncateKeep <= 0 {
+		opts.TruncateKeep = DEFAULT_TRUNCATE_KEEP
+	}
+	if opts.ResumeFree < opts.MinFree {
+		opts.ResumeFree = 2 * opts.MinFree
+	}
+
+	paths := opts.Paths
+	return &Archiver{
+		logFile:        paths.LogFile,
+		archiveDir:     paths.ArchiveDir,
+		stateFile:      paths.StateFile,
+		positionFile:   paths.PositionFile,
+		tempHourlyLog:  paths.TempHourlyLog,
+		runStateFile:   paths.RunStateFile,
+		historyFile:    paths.HistoryFile,
+		lockFile:       paths.LockFile,
+		outboxDir:      paths.OutboxDir,
+		period:         opts.Period,
+		naming:         opts.Naming,
+		maxPending:     opts.MaxPending,
+		truncateAbove:  opts.TruncateAbove,
+		truncateKeep:   opts.TruncateKeep,
+		minFree:        opts.MinFree,
+		resumeFree:     opts.ResumeFree,
+		emergencyKeep:  opts.EmergencyKeep,
+		onAlert:        opts.Alert,
+		failOnRotation: opts.FailOnRotation,
+		clock:          opts.Clock,
+		codec:          opts.Codec,
+		fs:             opts.FS,
+		log:            opts.Logger,
+	}
+}
+
+// since возвращает время, прошедшее с start, по часам архиватора
+func (a *Archiver) since(start time.Time) time.Duration {
+	return a.clock.Now().Sub(start)
+}
@@ -0,0 +1,93 @@
+package archiver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Period - период, за который накопитель сжимается в один архив
+type Period struct {
+	every time.Duration
+}
+
+var (
+	// HOURLY - архив каждый час (по умолчанию)
+	HOURLY = Period{time.Hour}
+	// DAILY - архив раз в сутки, в полночь
+	DAILY = Period{24 * time.Hour}
+)
+
+// ParsePeriod разбирает период архивирования: hourly, daily или длительность вроде 15m и 2h.
+// Длительность должна быть целым числом минут и делить сутки без остатка,
+// чтобы границы периодов каждый день приходились на одно и то же время
+func ParsePeriod(s string) (Period, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "hourly", "1h":
+		return HOURLY, nil
+	case "daily", "24h":
+		return DAILY, nil
+	}
+
+	every, err := time.ParseDuration(s)
+	if err != nil {
+		return Period{}, fmt.Errorf("некорректный период архивирования %q: ожидается hourly, daily или длительность вроде 15m", s)
+	}
+	if every < time.Minute || every%time.Minute != 0 || (24*time.Hour)%every != 0 {
+		return Period{}, fmt.Errorf("период архивирования %q должен быть целым числом минут и делить сутки без остатка", s)
+	}
+	return Period{every}, nil
+}
+
+// Duration возвращает длительность периода
+func (p Period) Duration() time.Duration {
+	if p.every == 0 {
+		return time.Hour
+	}
+	return p.every
+}
+
+// String возвращает период в том виде, в каком он задается в настройках
+func (p Period) String() string {
+	switch p.Duration() {
+	case time.Hour:
+		return "hourly"
+	case 24 * time.Hour:
+		return "daily"
+	}
+	return fmt.Sprintf("%dm", int(p.Duration()/time.Minute))
+}
+
+// Start возвращает начало периода, в который попадает t. Периоды отсчитываются от полуночи
+func (p Period) Start(t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if p.Duration() >= 24*time.Hour {
+		return midnight
+	}
+	return midnight.Add(t.Sub(midnight) / p.Duration() * p.Duration())
+}
+
+// Next возвращает начало следующего периода после t
+func (p Period) Next(t time.Time) time.Time {
+	if p.Duration() >= 24*time.Hour {
+		return p.Start(t).AddDate(0, 0, 1)
+	}
+	return p.Start(t).Add(p.Duration())
+}
+
+// layout - формат времени в имени архива: точность имени совпадает с периодом
+func (p Period) layout() string {
+	switch {
+	case p.Duration() >= 24*time.Hour:
+		return LAYOUT_DAY
+	case p.Duration()%time.Hour == 0:
+		return LAYOUT_HOUR
+	}
+	return LAYOUT_MINUTE
+}
+
+// ArchiveName возвращает имя архива периода, который начался в start, по шаблону
+// по умолчанию. Имя по настроенному шаблону возвращает Naming.ArchiveName
+func (p Period) ArchiveName(start time.Time) string {
+	return Naming{}.ArchiveName(p, start)
+}
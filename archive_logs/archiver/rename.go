@@ -0,0 +1,189 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"xui_log_archiver/fsys"
+)
+
+// RenameOptions - настройки переименования архивов
+type RenameOptions struct {
+	// From - шаблон, по которому названы существующие архивы. По умолчанию DEFAULT_NAMING
+	// в местном времени, как у прежних версий
+	From Naming
+	// DryRun - только показать новые имена
+	DryRun bool
+}
+
+// RenamedArchive - архив и его новое имя
+type RenamedArchive struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RenameStats - итоги переименования
+type RenameStats struct {
+	Renamed []RenamedArchive `json:"renamed"`
+	// Current - архивы, которые уже названы по шаблону архиватора
+	Current int `json:"current"`
+	// Unknown - файлы access_*, имена которых не подходят ни под один из шаблонов
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// RenameArchives переименовывает архивы, названные по шаблону opts.From, по шаблону архиватора,
+// в том числе уплотненные архивы в YYYY/MM/DD. Содержимое архивов не меняется, каждый архив
+// переименовывается одной операцией, поэтому прерванное переименование можно просто повторить.
+//
+// Местное время без пояса при переходе на зимнее время неоднозначно: какой из двух часов
+// в архиве, определяется по времени изменения файла - архиватор создает архив сразу после
+// конца периода
+func (a *Archiver) RenameArchives(ctx context.Context, opts RenameOptions) (RenameStats, error) {
+	stats := RenameStats{Renamed: []RenamedArchive{}}
+
+	unlock, err := acquireLock(a.lockFile)
+	if err != nil {
+		if err != ErrLocked {
+			err = fmt.Errorf("ошибка блокировки %s: %v", a.lockFile, err)
+		}
+		return stats, err
+	}
+	defer unlock()
+
+	type archive struct {
+		path    string
+		modTime time.Time
+	}
+	var archives []archive
+	fsys.Walk(a.fs, a.archiveDir, func(path string, info fs.FileInfo) error {
+		if strings.HasPrefix(info.Name(), "access_") && !strings.HasSuffix(info.Name(), ".tmp") {
+			archives = append(archives, archive{path, info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(archives, func(i, j int) bool { return archives[i].path < archives[j].path })
+
+	loc := a.clock.Now().Location()
+	taken := map[string]bool{}
+	for _, archive := range archives {
+		if err := ctx.Err(); err != nil {
+			return stats, fmt.Errorf("переименование прервано: %w", err)
+		}
+		dir, name := filepath.Split(archive.path)
+		stem, ext := a.splitExtension(name)
+
+		if a.namedCurrently(stem, loc) {
+			stats.Current++
+			continue
+		}
+		info, ok := opts.From.Parse(stem, loc)
+		if !ok {
+			stats.Unknown = append(stats.Unknown, archive.path)
+			continue
+		}
+		if info.Zone == "" {
+			a.resolveLocalStart(&info, archive.modTime)
+		}
+		node := info.Node
+		if node == "" {
+			node = a.naming.node
+		}
+
+		target := filepath.Join(dir, a.naming.fileName(node, info.Layout, info.Start, info.Seq, info.Part)+ext)
+		if target == archive.path {
+			stats.Current++
+			continue
+		}
+		// Два архива могли получить одно новое имя, если время в старых именах неоднозначно:
+		// второй получает следующий суффикс повторного архива
+		for seq := max(info.Seq, 1) + 1; taken[target] || a.exists(target); seq++ {
+			target = filepath.Join(dir, a.naming.fileName(node, info.Layout, info.Start, seq, info.Part)+ext)
+		}
+		taken[target] = true
+
+		if !opts.DryRun {
+			if err := a.fs.Rename(archive.path, target); err != nil {
+				return stats, fmt.Errorf("ошибка переименования %s: %v", archive.path, err)
+			}
+			a.log.Info("Архив переименован", "from", archive.path, "to", target)
+		}
+		stats.Renamed = append(stats.Renamed, RenamedArchive{From: archive.path, To: target})
+	}
+	return stats, nil
+}
+
+// MisnamedArchives возвращает архивы, названные не по шаблону архиватора: например, созданные
+// до смены шаблона и еще не переименованные
+func (a *Archiver) MisnamedArchives() []string {
+	loc := a.clock.Now().Location()
+	var misnamed []string
+	fsys.Walk(a.fs, a.archiveDir, func(path string, info fs.FileInfo) error {
+		name := info.Name()
+		if !strings.HasPrefix(name, "access_") || strings.HasSuffix(name, ".tmp") {
+			return nil
+		}
+		if stem, _ := a.splitExtension(name); !a.namedCurrently(stem, loc) {
+			misnamed = append(misnamed, path)
+		}
+		return nil
+	})
+	return misnamed
+}
+
+// namedCurrently сообщает, что имя архива без расширения сжатия совпадает с именем,
+// которое архиватор дал бы ему сейчас
+func (a *Archiver) namedCurrently(stem string, loc *time.Location) bool {
+	info, ok := a.naming.Parse(stem, loc)
+	return ok && a.naming.fileName(info.Node, info.Layout, info.Start, info.Seq, info.Part) == stem
+}
+
+// splitExtension отделяет от имени архива расширение сжатия. Несжатые архивы, оставшиеся
+// после сбоя, заканчиваются на .log
+func (a *Archiver) splitExtension(name string) (string, string) {
+	for _, ext := range []string{a.codec.Extension(), ".gz"} {
+		if strings.HasSuffix(name, ".log"+ext) {
+			return strings.TrimSuffix(name, ext), ext
+		}
+	}
+	return name, ""
+}
+
+// resolveLocalStart уточняет начало периода для местного времени без пояса. При переходе
+// на зимнее время одно и то же время бывает дважды: выбирается последний из вариантов,
+// период которого закончился до создания архива. Архив второго варианта прежние версии
+// назвали с суффиксом _2, в новом имени он уже не нужен
+func (a *Archiver) resolveLocalStart(info *ArchiveInfo, created time.Time) {
+	duration := a.period.Duration()
+	if info.Layout != LAYOUT_MINUTE && info.Layout != LAYOUT_HOUR || duration >= 24*time.Hour {
+		return
+	}
+	wall := info.Start.Format("20060102150405")
+	candidates := []time.Time{info.Start}
+	for _, shift := range []time.Duration{-time.Hour, time.Hour} {
+		if other := info.Start.Add(shift); other.Format("20060102150405") == wall {
+			candidates = append(candidates, other)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+	start := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if !candidate.Add(duration).After(created) {
+			start = candidate
+		}
+	}
+	if !start.Equal(candidates[0]) {
+		switch {
+		case info.Seq == 2:
+			info.Seq = 0
+		case info.Seq > 2:
+			info.Seq--
+		}
+	}
+	info.Start = start
+}
@@ -0,0 +1,1258 @@
+package archiver_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/fsys"
+	"xui_log_archiver/shipper"
+)
+
+// fakeClock - часы, которые двигает сценарий
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// faultFS - файловая система ОС, в которой операция op над файлом с именем base завершается ошибкой.
+// Так имитируется сбой посреди запуска: следующий запуск идет как после перезапуска процесса.
+// Если задан do, вместо ошибки перед операцией выполняется do: так другой процесс вклинивается в запуск
+type faultFS struct {
+	fsys.FS
+	op, base string
+	do       func()
+}
+
+var errInjected = errors.New("injected failure")
+
+func (f *faultFS) check(op, name string) error {
+	if op == f.op && filepath.Base(name) == f.base {
+		if f.do != nil {
+			f.do()
+			return nil
+		}
+		return errInjected
+	}
+	return nil
+}
+
+func (f *faultFS) Rename(oldpath, newpath string) error {
+	if err := f.check("rename", oldpath); err != nil {
+		return err
+	}
+	return f.FS.Rename(oldpath, newpath)
+}
+
+func (f *faultFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	if err := f.check("write", name); err != nil {
+		return err
+	}
+	return f.FS.WriteFile(name, data, perm)
+}
+
+func (f *faultFS) Remove(name string) error {
+	if err := f.check("remove", name); err != nil {
+		return err
+	}
+	return f.FS.Remove(name)
+}
+
+func (f *faultFS) Create(name string) (fsys.File, error) {
+	if err := f.check("create", name); err != nil {
+		return nil, err
+	}
+	return f.FS.Create(name)
+}
+
+// scenario - экземпляр x-ui во временной директории. Каждый запуск создает новый архиватор,
+// как cron, поэтому между запусками сохраняется только то, что записано на диск
+type scenario struct {
+	t       *testing.T
+	paths   archiver.Paths
+	clock   *fakeClock
+	fs      fsys.FS
+	opts    archiver.Options
+	written []string
+	seq     int
+	// email возвращает email клиента строки, по умолчанию userN
+	email func(seq int) string
+}
+
+func newScenario(t *testing.T, start time.Time) *scenario {
+	t.Helper()
+	paths := archiver.PathsFor(t.TempDir())
+	if err := os.WriteFile(paths.LogFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &scenario{t: t, paths: paths, clock: &fakeClock{now: start}, fs: fsys.OS}
+}
+
+// at переводит часы на время в зоне часов сценария
+func (s *scenario) at(hour, minute int) {
+	now := s.clock.now
+	s.clock.now = time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+}
+
+// log дописывает в access.log n строк с текущим временем часов
+func (s *scenario) log(n int) {
+	s.t.Helper()
+	file, err := os.OpenFile(s.paths.LogFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer file.Close()
+	for i := 0; i < n; i++ {
+		s.seq++
+		email := fmt.Sprintf("user%d", s.seq)
+		if s.email != nil {
+			email = s.email(s.seq)
+		}
+		line := fmt.Sprintf("%s from 10.0.0.1:%d accepted tcp:example.com:443 [in >> direct] email: %s",
+			s.clock.now.Format("2006/01/02 15:04:05.000000"), 10000+s.seq, email)
+		if _, err := fmt.Fprintln(file, line); err != nil {
+			s.t.Fatal(err)
+		}
+		s.written = append(s.written, line)
+	}
+}
+
+func (s *scenario) run() (archiver.RunStats, error) {
+	return s.runContext(context.Background())
+}
+
+func (s *scenario) runContext(ctx context.Context) (archiver.RunStats, error) {
+	opts := s.opts
+	opts.Paths, opts.Clock, opts.FS = s.paths, s.clock, s.fs
+	arch := archiver.NewWithOptions(opts)
+	defer arch.Close()
+	return arch.Run(ctx)
+}
+
+func (s *scenario) mustRun() archiver.RunStats {
+	s.t.Helper()
+	stats, err := s.run()
+	if err != nil {
+		s.t.Fatalf("%s: запуск завершился ошибкой: %v", s.clock.now.Format(time.RFC3339), err)
+	}
+	return stats
+}
+
+// archives возвращает строки каждого архива, сжатого или оставшегося несжатым, по пути
+// относительно директории архивов: уплотненные архивы лежат в YYYY/MM/DD
+func (s *scenario) archives() map[string][]string {
+	s.t.Helper()
+	archives := map[string][]string{}
+	fsys.Walk(fsys.OS, s.paths.ArchiveDir, func(path string, info fs.FileInfo) error {
+		if strings.HasPrefix(info.Name(), "access_") && !strings.HasSuffix(path, ".tmp") {
+			name, _ := filepath.Rel(s.paths.ArchiveDir, path)
+			archives[name] = s.read(path)
+		}
+		return nil
+	})
+	return archives
+}
+
+func (s *scenario) names() []string {
+	var names []string
+	for name := range s.archives() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *scenario) read(path string) []string {
+	s.t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		s.t.Fatal(err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			s.t.Fatalf("%s: %v", path, err)
+		}
+		reader = gz
+	}
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+// checkExactlyOnce проверяет, что каждая записанная строка попала ровно в один архив или в накопитель
+func (s *scenario) checkExactlyOnce() {
+	s.t.Helper()
+	seen := map[string]int{}
+	for _, lines := range s.archives() {
+		for _, line := range lines {
+			seen[line]++
+		}
+	}
+	for _, line := range s.read(s.paths.TempHourlyLog) {
+		seen[line]++
+	}
+	for _, line := range s.written {
+		switch seen[line] {
+		case 1:
+		case 0:
+			s.t.Errorf("строка потеряна: %s", line)
+		default:
+			s.t.Errorf("строка записана %d раз: %s", seen[line], line)
+		}
+		delete(seen, line)
+	}
+	for line := range seen {
+		s.t.Errorf("лишняя строка: %s", line)
+	}
+}
+
+func TestRolloverAtPeriodBoundary(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 15, 0, 0, time.UTC))
+	s.log(5)
+	stats := s.mustRun()
+	if stats.RolledOver || stats.LinesProcessed != 5 {
+		t.Fatalf("10:15: ожидалось 5 строк без архива, получено %+v", stats)
+	}
+	if want := time.Date(2026, 5, 4, 11, 0, 0, 0, time.UTC); !stats.NextRollover.Equal(want) {
+		t.Errorf("следующий архив %v, ожидалось %v", stats.NextRollover, want)
+	}
+
+	s.clock.now = time.Date(2026, 5, 4, 10, 59, 59, 0, time.UTC)
+	s.log(2)
+	if stats := s.mustRun(); stats.RolledOver {
+		t.Fatalf("10:59:59: архив создан раньше конца часа: %s", stats.ArchiveFile)
+	}
+
+	s.at(11, 0)
+	s.log(3)
+	stats = s.mustRun()
+	if filepath.Base(stats.ArchiveFile) != "access_20260504_10.log.gz" {
+		t.Fatalf("11:00: создан архив %q", stats.ArchiveFile)
+	}
+	// Строки, прочитанные в запуске 11:00, относятся к накопителю, который запечатывается этим запуском
+	if lines := s.archives()["access_20260504_10.log.gz"]; len(lines) != 10 {
+		t.Errorf("в архиве %d строк, ожидалось 10", len(lines))
+	}
+	s.checkExactlyOnce()
+}
+
+func TestMissedRunsKeepAccumulatorPeriod(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 50, 0, 0, time.UTC))
+	s.log(3)
+	s.mustRun()
+
+	s.at(11, 0)
+	s.mustRun()
+	s.at(11, 40)
+	s.log(4)
+	s.mustRun()
+
+	// Запуски 12:00 - 13:00 пропущены: архив называется по периоду накопителя, а не по времени запуска
+	s.at(13, 5)
+	stats := s.mustRun()
+	if filepath.Base(stats.ArchiveFile) != "access_20260504_11.log.gz" {
+		t.Fatalf("создан архив %q, ожидался access_20260504_11.log.gz", stats.ArchiveFile)
+	}
+	if got := s.names(); len(got) != 2 {
+		t.Errorf("архивы %v, ожидалось 2", got)
+	}
+	s.checkExactlyOnce()
+}
+
+func TestTruncatedSourceIsReadFromStart(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.log(10)
+	s.mustRun()
+
+	if err := os.Truncate(s.paths.LogFile, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.at(10, 15)
+	s.log(3)
+	stats := s.mustRun()
+	if !stats.RotationDetected || stats.LinesProcessed != 3 {
+		t.Fatalf("ожидалось обнаружение очистки и 3 строки, получено %+v", stats)
+	}
+	s.checkExactlyOnce()
+}
+
+func TestFailOnRotationKeepsPosition(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.opts.FailOnRotation = true
+	s.log(10)
+	s.mustRun()
+	position, _ := os.ReadFile(s.paths.PositionFile)
+
+	if err := os.Truncate(s.paths.LogFile, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.log(1)
+	if _, err := s.run(); !errors.Is(err, archiver.ErrRotationDetected) {
+		t.Fatalf("ожидалась ErrRotationDetected, получено %v", err)
+	}
+	if after, _ := os.ReadFile(s.paths.PositionFile); string(after) != string(position) {
+		t.Errorf("позиция изменилась: %s -> %s", position, after)
+	}
+}
+
+func TestMissingSource(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	os.Remove(s.paths.LogFile)
+	if _, err := s.run(); !errors.Is(err, archiver.ErrSourceMissing) {
+		t.Fatalf("ожидалась ErrSourceMissing, получено %v", err)
+	}
+}
+
+func TestCrashMidRun(t *testing.T) {
+	paths := archiver.PathsFor("")
+	cases := []struct {
+		name, op, base string
+		want           []string
+	}{
+		// Накопитель не переименован в архив: архив создается следующим запуском
+		{"rename accumulator", "rename", filepath.Base(paths.TempHourlyLog),
+			[]string{"access_20260504_10.log.gz"}},
+		// Архив не сжат: остается несжатым, строки не теряются
+		{"compress archive", "create", "access_20260504_10.log.gz.tmp",
+			[]string{"access_20260504_10.log", "access_20260504_11.log.gz"}},
+		// Архив создан, а новый накопитель нет: следующий запуск создаст его сам
+		{"recreate accumulator", "write", filepath.Base(paths.TempHourlyLog),
+			[]string{"access_20260504_10.log.gz", "access_20260504_11.log.gz"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newScenario(t, time.Date(2026, 5, 4, 10, 30, 0, 0, time.UTC))
+			s.log(5)
+			s.mustRun()
+
+			s.at(11, 0)
+			s.log(2)
+			s.fs = &faultFS{FS: fsys.OS, op: c.op, base: c.base}
+			s.run()
+
+			s.fs = fsys.OS
+			s.at(11, 10)
+			s.log(2)
+			s.mustRun()
+			s.at(12, 0)
+			s.mustRun()
+
+			s.checkExactlyOnce()
+			if got := s.names(); strings.Join(got, ",") != strings.Join(c.want, ",") {
+				t.Errorf("архивы %v, ожидалось %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCrashBeforePositionSaved(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 30, 0, 0, time.UTC))
+	s.log(5)
+	s.fs = &faultFS{FS: fsys.OS, op: "write", base: filepath.Base(s.paths.PositionFile)}
+	if _, err := s.run(); err == nil {
+		t.Fatal("ожидалась ошибка записи позиции")
+	}
+
+	// Строки уже в накопителе, а позиция не сохранена: повторное чтение дает дубликаты,
+	// но не потерю строк. Дубликаты отбрасывает merge
+	s.fs = fsys.OS
+	s.at(10, 40)
+	s.mustRun()
+	pending := s.read(s.paths.TempHourlyLog)
+	if len(pending) != 10 {
+		t.Fatalf("в накопителе %d строк, ожидалось 10 (5 строк дважды)", len(pending))
+	}
+}
+
+func TestCancelledRunResumes(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 30, 0, 0, time.UTC))
+	s.log(5000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stats, err := s.runContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ожидалась context.Canceled, получено %v", err)
+	}
+	if stats.LinesProcessed == 0 || stats.LinesProcessed >= 5000 {
+		t.Fatalf("прерванный запуск обработал %d строк", stats.LinesProcessed)
+	}
+
+	stats = s.mustRun()
+	if stats.LinesProcessed == 0 {
+		t.Fatal("продолжение не обработало оставшиеся строки")
+	}
+	s.checkExactlyOnce()
+}
+
+func TestConcurrentRunIsLocked(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 30, 0, 0, time.UTC))
+	s.log(1)
+	// Пока первый запуск держит блокировку, второй должен быть отклонен
+	s.fs = &blockingFS{FS: fsys.OS, inner: func() {
+		if _, err := s.runContext(context.Background()); !errors.Is(err, archiver.ErrLocked) {
+			t.Errorf("ожидалась ErrLocked, получено %v", err)
+		}
+	}}
+	s.mustRun()
+}
+
+// blockingFS вызывает inner один раз при записи позиции, то есть посреди запуска
+type blockingFS struct {
+	fsys.FS
+	inner func()
+}
+
+func (f *blockingFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	if f.inner != nil && strings.HasSuffix(name, "position.txt") {
+		inner := f.inner
+		f.inner = nil
+		inner()
+	}
+	return f.FS.WriteFile(name, data, perm)
+}
+
+func TestDSTFallBack(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 25.10.2026 в 03:00 CEST часы переводятся на 02:00 CET: час 02 проходит дважды.
+	// Время задается в UTC, потому что 02:10 по Берлину неоднозначно
+	utc := func(hour, minute int) time.Time {
+		return time.Date(2026, 10, 25, hour, minute, 0, 0, time.UTC).In(berlin)
+	}
+	s := newScenario(t, time.Date(2026, 10, 24, 23, 30, 0, 0, time.UTC).In(berlin)) // 01:30 CEST
+	s.log(3)
+	s.mustRun()
+
+	s.clock.now = utc(0, 10) // 02:10 CEST
+	s.log(3)
+	s.mustRun()
+	s.log(2)
+
+	s.clock.now = utc(1, 10) // 02:10 CET
+	s.mustRun()
+	s.log(3)
+
+	s.clock.now = utc(2, 5) // 03:05 CET
+	s.mustRun()
+
+	want := []string{"access_20261025_01.log.gz", "access_20261025_02.log.gz", "access_20261025_02_2.log.gz"}
+	if got := s.names(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("архивы %v, ожидалось %v", got, want)
+	}
+	s.checkExactlyOnce()
+}
+
+func TestDSTSpringForward(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 29.03.2026 в 02:00 CET часы переводятся на 03:00 CEST: часа 02 нет
+	s := newScenario(t, time.Date(2026, 3, 29, 1, 50, 0, 0, berlin))
+	s.log(3)
+	s.mustRun()
+
+	s.clock.now = s.clock.now.Add(20 * time.Minute) // 03:10 CEST
+	stats := s.mustRun()
+	if filepath.Base(stats.ArchiveFile) != "access_20260329_01.log.gz" {
+		t.Fatalf("создан архив %q", stats.ArchiveFile)
+	}
+	s.log(2)
+
+	s.at(4, 0)
+	stats = s.mustRun()
+	if filepath.Base(stats.ArchiveFile) != "access_20260329_03.log.gz" {
+		t.Fatalf("создан архив %q", stats.ArchiveFile)
+	}
+	s.checkExactlyOnce()
+}
+
+func TestDailyPeriodAcrossDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	// Сутки перевода часов длятся 25 часов, архив все равно создается в полночь
+	s := newScenario(t, time.Date(2026, 10, 25, 0, 30, 0, 0, berlin))
+	s.opts.Period = archiver.DAILY
+	s.log(2)
+	s.mustRun()
+
+	s.at(23, 59)
+	s.log(2)
+	if stats := s.mustRun(); stats.RolledOver {
+		t.Fatalf("архив создан до полуночи: %s", stats.ArchiveFile)
+	}
+
+	s.clock.now = time.Date(2026, 10, 26, 0, 0, 0, 0, berlin)
+	stats := s.mustRun()
+	if filepath.Base(stats.ArchiveFile) != "access_20261025.log.gz" {
+		t.Fatalf("создан архив %q", stats.ArchiveFile)
+	}
+	s.checkExactlyOnce()
+}
+
+func TestBackfillRotatedAndCurrentLog(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 8, 10, 0, 0, time.UTC))
+	s.log(3)
+	s.at(9, 20)
+	s.log(3)
+
+	// Ротация: access.log сжат в access.log.1.gz, новый access.log начат заново
+	rotated := s.paths.LogFile + ".1.gz"
+	data, err := os.ReadFile(s.paths.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	if err := (archiver.GzipCodec{}).Compress(&buf, strings.NewReader(string(data))); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(rotated, []byte(buf.String()), 0644)
+	os.WriteFile(s.paths.LogFile, nil, 0644)
+
+	s.at(9, 50)
+	s.log(2)
+	s.at(10, 5)
+	s.log(2)
+
+	arch := archiver.NewWithOptions(archiver.Options{Paths: s.paths, Clock: s.clock})
+	stats, err := arch.Backfill(context.Background(), []string{rotated, s.paths.LogFile}, archiver.BackfillOptions{})
+	arch.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Lines != 8 || stats.Live != 2 {
+		t.Errorf("импортировано %d строк, текущего периода %d, ожидалось 8 и 2", stats.Lines, stats.Live)
+	}
+	want := []string{"access_20260504_08.log.gz", "access_20260504_09.log.gz"}
+	if got := s.names(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("архивы %v, ожидалось %v", got, want)
+	}
+
+	// Обычный запуск продолжает с первой строки текущего периода
+	if run := s.mustRun(); run.LinesProcessed != 2 {
+		t.Errorf("запуск после импорта обработал %d строк, ожидалось 2", run.LinesProcessed)
+	}
+	s.checkExactlyOnce()
+
+	// Повторный импорт с объединением не создает дубликатов
+	arch = archiver.NewWithOptions(archiver.Options{Paths: s.paths, Clock: s.clock})
+	defer arch.Close()
+	if _, err := arch.Backfill(context.Background(), []string{s.paths.LogFile}, archiver.BackfillOptions{}); err == nil {
+		t.Error("импорт access.log, который уже читает архиватор, должен завершиться ошибкой")
+	}
+	stats, err = arch.Backfill(context.Background(), []string{rotated}, archiver.BackfillOptions{Merge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Periods) != 2 || stats.Periods[1].Action != archiver.BACKFILL_MERGED || stats.Periods[1].Duplicates != 3 {
+		t.Errorf("итоги объединения %+v", stats.Periods)
+	}
+	s.checkExactlyOnce()
+}
+
+func TestBackfillRotatedLogWithCurrentPeriod(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.log(2)
+	s.mustRun()
+	s.at(10, 20)
+	s.log(2)
+
+	// Ротация посреди часа: две строки архиватор уже прочитал, две - нет
+	rotated := s.paths.LogFile + ".1"
+	if err := os.Rename(s.paths.LogFile, rotated); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(s.paths.LogFile, nil, 0644)
+
+	arch := archiver.NewWithOptions(archiver.Options{Paths: s.paths, Clock: s.clock})
+	stats, err := arch.Backfill(context.Background(), []string{rotated}, archiver.BackfillOptions{})
+	arch.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Live != 4 || stats.Appended != 2 || len(stats.Periods) != 0 {
+		t.Errorf("текущего периода %d строк, дописано %d, периодов %d, ожидалось 4, 2 и 0",
+			stats.Live, stats.Appended, len(stats.Periods))
+	}
+
+	s.at(10, 30)
+	s.log(1)
+	s.mustRun()
+	s.at(11, 5)
+	s.mustRun()
+	if got := s.names(); len(got) != 1 || got[0] != "access_20260504_10.log.gz" {
+		t.Errorf("архивы %v", got)
+	}
+	s.checkExactlyOnce()
+}
+
+// xrayFS вызывает write сразу после усечения access.log, как Xray, который пишет в файл
+// между усечением и возвращением хвоста
+type xrayFS struct {
+	fsys.FS
+	write func()
+}
+
+func (f *xrayFS) Truncate(name string, size int64) error {
+	if err := f.FS.Truncate(name, size); err != nil {
+		return err
+	}
+	if filepath.Base(name) == "access.log" {
+		f.write()
+	}
+	return nil
+}
+
+// restoreFailFS не дает открыть access.log на запись: сбой после усечения до возвращения хвоста
+type restoreFailFS struct {
+	fsys.FS
+}
+
+func (f *restoreFailFS) OpenFile(name string, flag int, perm os.FileMode) (fsys.File, error) {
+	if filepath.Base(name) == "access.log" {
+		return nil, errInjected
+	}
+	return f.FS.OpenFile(name, flag, perm)
+}
+
+func sourceSize(t *testing.T, s *scenario) int64 {
+	t.Helper()
+	info, err := os.Stat(s.paths.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestTruncateKeepsTail(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.opts.TruncateAbove, s.opts.TruncateKeep = 2000, 500
+	s.log(50)
+	if stats := s.mustRun(); stats.TruncatedBytes != 0 {
+		t.Fatalf("усечение до архивирования строк: %+v", stats)
+	}
+
+	s.at(11, 5)
+	s.log(2)
+	stats := s.mustRun()
+	if !stats.RolledOver || stats.TruncatedBytes == 0 {
+		t.Fatalf("ожидались архив и усечение, получено %+v", stats)
+	}
+	if size := sourceSize(t, s); size > 500 {
+		t.Errorf("после усечения осталось %d байт, ожидалось не больше 500", size)
+	}
+	// Хвост - последние строки целиком
+	tail := s.read(s.paths.LogFile)
+	if len(tail) == 0 || tail[len(tail)-1] != s.written[len(s.written)-1] {
+		t.Errorf("хвост не заканчивается последней строкой: %v", tail)
+	}
+
+	s.at(11, 30)
+	s.log(5)
+	if stats := s.mustRun(); stats.LinesProcessed != 5 || stats.RotationDetected {
+		t.Fatalf("после усечения ожидалось 5 новых строк без ротации, получено %+v", stats)
+	}
+	s.at(12, 5)
+	s.mustRun()
+	s.checkExactlyOnce()
+}
+
+func TestTruncateKeepsTailWithMultibyteLines(t *testing.T) {
+	// Граница начала хвоста попадает в разные места кириллических строк, в том числе внутрь символа
+	for keep := int64(1000); keep < 3000; keep += 400 {
+		s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+		s.opts.TruncateAbove, s.opts.TruncateKeep = 2000, keep
+		s.email = func(seq int) string { return strings.Repeat("x", seq%3) + strings.Repeat("ж", 150) }
+		s.log(50)
+		s.mustRun()
+
+		s.at(11, 5)
+		s.log(2)
+		if stats := s.mustRun(); stats.TruncatedBytes == 0 {
+			t.Fatalf("хвост %d: ожидалось усечение, получено %+v", keep, stats)
+		}
+		s.at(11, 30)
+		s.log(3)
+		if stats := s.mustRun(); stats.LinesProcessed != 3 {
+			t.Fatalf("хвост %d: после усечения ожидалось 3 новые строки, получено %+v", keep, stats)
+		}
+		s.at(12, 5)
+		s.mustRun()
+		s.checkExactlyOnce()
+	}
+}
+
+func TestTruncateRaceWithWriter(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.opts.TruncateAbove, s.opts.TruncateKeep = 2000, 500
+	s.log(50)
+	s.mustRun()
+
+	s.at(11, 5)
+	s.fs = &xrayFS{FS: fsys.OS, write: func() { s.log(3) }}
+	stats := s.mustRun()
+	if stats.TruncatedBytes == 0 || stats.LinesProcessed != 3 {
+		t.Fatalf("ожидалось усечение и 3 строки, записанные во время него, получено %+v", stats)
+	}
+
+	s.fs = fsys.OS
+	s.at(11, 30)
+	s.log(2)
+	if stats := s.mustRun(); stats.LinesProcessed != 2 {
+		t.Fatalf("ожидалось 2 новые строки, получено %+v", stats)
+	}
+	s.at(12, 5)
+	s.mustRun()
+	s.checkExactlyOnce()
+}
+
+func TestTruncateKeepsLinesWrittenAfterTailRead(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.opts.TruncateAbove, s.opts.TruncateKeep = 2000, 500
+	s.log(50)
+	s.mustRun()
+
+	// Xray дописывает строки, когда хвост уже прочитан, а состояние усечения сохраняется
+	s.at(11, 5)
+	written := false
+	s.fs = &faultFS{FS: fsys.OS, op: "rename", base: filepath.Base(s.paths.RunStateFile) + ".tmp", do: func() {
+		if !written {
+			written = true
+			s.log(3)
+		}
+	}}
+	stats := s.mustRun()
+	if !written || stats.TruncatedBytes == 0 || stats.LinesProcessed != 3 {
+		t.Fatalf("ожидалось усечение и 3 строки, записанные во время него, получено %+v", stats)
+	}
+
+	s.fs = fsys.OS
+	s.at(11, 30)
+	s.log(2)
+	if stats := s.mustRun(); stats.LinesProcessed != 2 {
+		t.Fatalf("ожидалось 2 новые строки, получено %+v", stats)
+	}
+	s.at(12, 5)
+	s.mustRun()
+	s.checkExactlyOnce()
+}
+
+func TestCrashBeforeTailRestored(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.opts.TruncateAbove, s.opts.TruncateKeep = 2000, 500
+	s.log(50)
+	s.mustRun()
+
+	s.at(11, 5)
+	s.fs = &restoreFailFS{FS: fsys.OS}
+	if _, err := s.run(); err == nil {
+		t.Fatal("ожидалась ошибка возвращения хвоста")
+	}
+	if size := sourceSize(t, s); size != 0 {
+		t.Fatalf("access.log после сбоя: %d байт, ожидался пустой", size)
+	}
+
+	// Хвост уже в архиве, поэтому новые строки читаются с начала файла без ротации
+	s.fs = fsys.OS
+	s.at(11, 30)
+	s.log(4)
+	if stats := s.mustRun(); stats.LinesProcessed != 4 || stats.RotationDetected {
+		t.Fatalf("ожидалось 4 новые строки без ротации, получено %+v", stats)
+	}
+	s.at(12, 5)
+	s.mustRun()
+	s.checkExactlyOnce()
+}
+
+// spaceFS сообщает заданное свободное место. Удаленные файлы освобождают место
+type spaceFS struct {
+	fsys.FS
+	free int64
+}
+
+func (f *spaceFS) Free(path string) (int64, error) { return f.free, nil }
+
+func (f *spaceFS) Remove(name string) error {
+	info, err := f.FS.Stat(name)
+	if err := f.FS.Remove(name); err != nil {
+		return err
+	}
+	if err == nil {
+		f.free += info.Size()
+	}
+	return nil
+}
+
+func TestLowDiskSpaceEmergency(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	disk := &spaceFS{FS: fsys.OS, free: 1 << 30}
+	var alerts []archiver.Alert
+	s.fs = disk
+	s.opts.MinFree, s.opts.ResumeFree = 100<<20, 200<<20
+	s.opts.Alert = func(alert archiver.Alert) { alerts = append(alerts, alert) }
+	s.log(10)
+	s.mustRun()
+
+	// Места мало: накопитель сжимается, новые строки остаются в access.log
+	disk.free = 50 << 20
+	s.at(10, 20)
+	s.log(5)
+	stats, err := s.run()
+	if !errors.Is(err, archiver.ErrLowDiskSpace) || !stats.DiskEmergency || stats.LinesProcessed != 0 {
+		t.Fatalf("ожидался аварийный режим без чтения строк, получено %+v, %v", stats, err)
+	}
+	if len(stats.PartFiles) != 1 {
+		t.Errorf("накопитель не сжат в часть архива: %+v", stats)
+	}
+	if len(alerts) != 1 || !alerts[0].Emergency {
+		t.Fatalf("ожидалось одно оповещение об аварии, получено %+v", alerts)
+	}
+
+	// Места больше порога входа, но меньше порога выхода: режим сохраняется без повторного оповещения
+	disk.free = 150 << 20
+	s.at(10, 30)
+	if _, err := s.run(); !errors.Is(err, archiver.ErrLowDiskSpace) {
+		t.Fatalf("ожидался аварийный режим до ResumeFree, получено %v", err)
+	}
+	if len(alerts) != 1 {
+		t.Errorf("повторное оповещение: %+v", alerts)
+	}
+
+	disk.free = 1 << 30
+	s.at(10, 40)
+	if stats := s.mustRun(); stats.LinesProcessed != 5 {
+		t.Fatalf("после освобождения места ожидалось 5 строк, получено %+v", stats)
+	}
+	if len(alerts) != 2 || alerts[1].Emergency {
+		t.Errorf("ожидалось оповещение о восстановлении, получено %+v", alerts)
+	}
+	s.at(11, 5)
+	s.mustRun()
+	s.checkExactlyOnce()
+}
+
+func TestEmergencyRetentionDeletesOldest(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	disk := &spaceFS{FS: fsys.OS, free: 1 << 30}
+	s.fs = disk
+	s.opts.MinFree, s.opts.ResumeFree, s.opts.EmergencyKeep = 100, 101, 12*time.Hour
+	for hour := 10; hour <= 13; hour++ {
+		s.at(hour, 5)
+		s.log(3)
+		s.mustRun()
+	}
+	// Время архивов - по часам сценария: архив периода создается в начале следующего
+	before := s.names()
+	for i, name := range before {
+		created := time.Date(2026, 5, 4, 11+i, 5, 0, 0, time.UTC)
+		if err := os.Chtimes(filepath.Join(s.paths.ArchiveDir, name), created, created); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Архивы старше 12 часов удаляются с самого старого, пока места не станет ResumeFree
+	s.clock.now = s.clock.now.Add(24 * time.Hour)
+	s.log(1)
+	disk.free = 99
+	stats, err := s.run()
+	if !errors.Is(err, archiver.ErrLowDiskSpace) {
+		t.Fatalf("ожидалась ErrLowDiskSpace, получено %v", err)
+	}
+	if len(stats.DeletedArchives) != 1 || filepath.Base(stats.DeletedArchives[0]) != before[0] {
+		t.Fatalf("ожидалось удаление только %s, удалены %v", before[0], stats.DeletedArchives)
+	}
+}
+
+func TestEmergencyRetentionKeepsQueuedArchivesLast(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	disk := &spaceFS{FS: fsys.OS, free: 1 << 30}
+	s.fs = disk
+	s.opts.MinFree, s.opts.ResumeFree, s.opts.EmergencyKeep = 100, 101, 12*time.Hour
+	for hour := 10; hour <= 13; hour++ {
+		s.at(hour, 5)
+		s.log(3)
+		s.mustRun()
+	}
+	before := s.names()
+	for i, name := range before {
+		created := time.Date(2026, 5, 4, 11+i, 5, 0, 0, time.UTC)
+		if err := os.Chtimes(filepath.Join(s.paths.ArchiveDir, name), created, created); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ship := shipper.New(shipper.Options{OutboxDir: s.paths.OutboxDir, ArchiveDir: s.paths.ArchiveDir})
+	if err := ship.Enqueue(filepath.Join(s.paths.ArchiveDir, before[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	// Самый старый архив ждет отправки: удаляется следующий за ним
+	s.clock.now = s.clock.now.Add(24 * time.Hour)
+	s.log(1)
+	disk.free = 99
+	stats, _ := s.run()
+	if len(stats.DeletedArchives) != 1 || filepath.Base(stats.DeletedArchives[0]) != before[1] {
+		t.Fatalf("ожидалось удаление только %s, удалены %v", before[1], stats.DeletedArchives)
+	}
+
+	// Места не хватает и без него: архив из очереди удаляется последним вместе с записью в очереди
+	disk.free = -1 << 30
+	s.clock.now = s.clock.now.Add(time.Minute)
+	stats, _ = s.run()
+	if n := len(stats.DeletedArchives); n == 0 || filepath.Base(stats.DeletedArchives[n-1]) != before[0] {
+		t.Fatalf("архив из очереди должен удаляться последним, удалены %v", stats.DeletedArchives)
+	}
+	if items, err := ship.Queue(); err != nil || len(items) != 0 {
+		t.Errorf("удаленный архив остался в очереди отправки: %+v, %v", items, err)
+	}
+}
+
+// fullFile принимает не больше room байт, дальше запись завершается ошибкой, как на полном диске
+type fullFile struct {
+	fsys.File
+	room *int
+}
+
+func (f *fullFile) Write(p []byte) (int, error) {
+	if len(p) > *f.room {
+		n, _ := f.File.Write(p[:*f.room])
+		*f.room = 0
+		return n, errors.New("no space left on device")
+	}
+	*f.room -= len(p)
+	return f.File.Write(p)
+}
+
+type fullFS struct {
+	fsys.FS
+	room int
+}
+
+func (f *fullFS) OpenFile(name string, flag int, perm os.FileMode) (fsys.File, error) {
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil || filepath.Base(name) != "temp_hourly_archive.log" {
+		return file, err
+	}
+	return &fullFile{File: file, room: &f.room}, nil
+}
+
+func TestDiskFullMidWriteLeavesNoPartialLines(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.log(3)
+	s.mustRun()
+	before, _ := os.ReadFile(s.paths.TempHourlyLog)
+
+	s.at(10, 20)
+	s.log(2000)
+	s.fs = &fullFS{FS: fsys.OS, room: 100 << 10}
+	if _, err := s.run(); err == nil {
+		t.Fatal("ожидалась ошибка записи в накопитель")
+	}
+	if after, _ := os.ReadFile(s.paths.TempHourlyLog); len(after) != len(before) {
+		t.Fatalf("в накопителе остались строки оборванной записи: %d байт вместо %d", len(after), len(before))
+	}
+
+	s.fs = fsys.OS
+	s.mustRun()
+	s.at(11, 5)
+	s.mustRun()
+	s.checkExactlyOnce()
+}
+
+func (s *scenario) archiver() *archiver.Archiver {
+	opts := s.opts
+	opts.Paths, opts.Clock, opts.FS = s.paths, s.clock, s.fs
+	return archiver.NewWithOptions(opts)
+}
+
+func (s *scenario) compact(opts archiver.CompactOptions) (archiver.CompactStats, error) {
+	arch := s.archiver()
+	defer arch.Close()
+	return arch.Compact(context.Background(), opts)
+}
+
+// day архивирует строки каждого часа с from по to включительно в день d
+func (s *scenario) day(d time.Time, from, to int) {
+	s.t.Helper()
+	s.clock.now = d
+	for hour := from; hour <= to; hour++ {
+		s.at(hour, 5)
+		s.log(3)
+		s.mustRun()
+	}
+}
+
+func TestCompactDaysIntoHierarchy(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.opts.MaxPending = 300
+	s.day(time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC), 10, 23)
+	s.day(time.Date(2026, 5, 5, 0, 0, 0, 0, time.UTC), 0, 2)
+
+	stats, err := s.compact(archiver.CompactOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily := filepath.Join("2026", "05", "04", "access_20260504.log.gz")
+	if len(stats.Archives) != 1 || !strings.HasSuffix(stats.Archives[0].Archive, daily) {
+		t.Fatalf("ожидался один архив дня %s, получено %+v", daily, stats.Archives)
+	}
+	for _, name := range s.names() {
+		if !strings.HasPrefix(name, "access_20260505") && name != daily {
+			t.Errorf("архив %s не уплотнен", name)
+		}
+	}
+	// Строки дня идут по порядку: части периода раньше его остатка
+	lines := s.archives()[daily]
+	if !sort.StringsAreSorted(lines) {
+		t.Errorf("строки архива дня не по порядку")
+	}
+	s.checkExactlyOnce()
+
+	// Текущий день и уже уплотненные дни не трогаются
+	if stats, err := s.compact(archiver.CompactOptions{}); err != nil || len(stats.Archives) != 0 {
+		t.Fatalf("повторное уплотнение: %+v, %v", stats, err)
+	}
+}
+
+func TestCompactLegacyArchivesAroundMidnight(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 6, 10, 5, 0, 0, time.UTC))
+	s.legacy(time.Date(2026, 5, 4, 23, 0, 2, 0, time.UTC))
+	// Архив, созданный в полночь, - последний час предыдущего дня
+	s.legacy(time.Date(2026, 5, 5, 0, 0, 3, 0, time.UTC))
+	s.legacy(time.Date(2026, 5, 5, 1, 0, 2, 0, time.UTC))
+
+	if _, err := s.compact(archiver.CompactOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	archives := s.archives()
+	first := filepath.Join("2026", "05", "04", "access_20260504.log.gz")
+	second := filepath.Join("2026", "05", "05", "access_20260505.log.gz")
+	if len(archives) != 2 || len(archives[first]) != 6 || len(archives[second]) != 3 {
+		t.Fatalf("ожидались архивы дней %s с 6 строками и %s с 3, получено %v", first, second, s.names())
+	}
+	s.checkExactlyOnce()
+}
+
+func TestCompactMonths(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 4, 29, 22, 5, 0, 0, time.UTC))
+	s.day(time.Date(2026, 4, 29, 0, 0, 0, 0, time.UTC), 22, 23)
+	s.day(time.Date(2026, 4, 30, 0, 0, 0, 0, time.UTC), 0, 23)
+	s.day(time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC), 0, 23)
+	s.day(time.Date(2026, 5, 2, 0, 0, 0, 0, time.UTC), 0, 1)
+
+	if _, err := s.compact(archiver.CompactOptions{Monthly: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join("2026", "04", "access_202604.log.gz"),
+		filepath.Join("2026", "05", "01", "access_20260501.log.gz"),
+		"access_20260502_00.log.gz",
+	}
+	if got := s.names(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("архивы %v, ожидались %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(s.paths.ArchiveDir, "2026", "04", "30")); !os.IsNotExist(err) {
+		t.Errorf("пустая директория дня не удалена: %v", err)
+	}
+	s.checkExactlyOnce()
+}
+
+func TestCompactCrashBeforeInputsRemoved(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 5, 4, 10, 5, 0, 0, time.UTC))
+	s.day(time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC), 10, 23)
+	s.day(time.Date(2026, 5, 5, 0, 0, 0, 0, time.UTC), 0, 0)
+
+	// Архив дня уже на месте, но исходные архивы удалены не все
+	s.fs = &faultFS{FS: fsys.OS, op: "remove", base: "access_20260504_15.log.gz"}
+	if _, err := s.compact(archiver.CompactOptions{}); err == nil {
+		t.Fatal("ожидалась ошибка удаления исходного архива")
+	}
+	if _, err := os.Stat(filepath.Join(s.paths.ArchiveDir, archiver.COMPACT_JOURNAL)); err != nil {
+		t.Fatalf("журнал уплотнения не сохранен: %v", err)
+	}
+
+	s.fs = fsys.OS
+	if _, err := s.compact(archiver.CompactOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s.checkExactlyOnce()
+}
+
+// fallBack проходит перевод часов на зимнее время в Берлине 25.10.2026: час 02 проходит дважды.
+// Время архивов ставится по часам сценария, как у архивов, созданных в это время
+func (s *scenario) fallBack() {
+	s.t.Helper()
+	steps := []time.Time{
+		time.Date(2026, 10, 25, 0, 10, 0, 0, time.UTC), // 02:10 CEST
+		time.Date(2026, 10, 25, 1, 10, 0, 0, time.UTC), // 02:10 CET
+		time.Date(2026, 10, 25, 2, 5, 0, 0, time.UTC),  // 03:05 CET
+	}
+	for _, step := range steps {
+		s.log(3)
+		s.clock.now = step.In(s.clock.now.Location())
+		stats := s.mustRun()
+		if stats.ArchiveFile != "" {
+			os.Chtimes(stats.ArchiveFile, s.clock.now, s.clock.now)
+		}
+	}
+}
+
+func TestNamingWithZoneAvoidsDSTCollision(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	cases := []struct {
+		zone string
+		want []string
+	}{
+		{archiver.ZONE_UTC, []string{"access_node1_20261024_23Z.log.gz", "access_node1_20261025_00Z.log.gz", "access_node1_20261025_01Z.log.gz"}},
+		{archiver.ZONE_OFFSET, []string{"access_node1_20261025_01+0200.log.gz", "access_node1_20261025_02+0100.log.gz", "access_node1_20261025_02+0200.log.gz"}},
+	}
+	for _, c := range cases {
+		t.Run(c.zone, func(t *testing.T) {
+			s := newScenario(t, time.Date(2026, 10, 24, 23, 30, 0, 0, time.UTC).In(berlin)) // 01:30 CEST
+			s.opts.Naming, err = archiver.ParseNaming("access_{node}_{time}.log", "node1", c.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.fallBack()
+			if got := s.names(); strings.Join(got, " ") != strings.Join(c.want, " ") {
+				t.Fatalf("архивы %v, ожидались %v", got, c.want)
+			}
+			s.checkExactlyOnce()
+		})
+	}
+}
+
+// legacy создает архив bash-архиватора: он назван по моменту создания в начале часа
+// и содержит строки предыдущего часа
+func (s *scenario) legacy(created time.Time) {
+	s.t.Helper()
+	path := filepath.Join(s.paths.ArchiveDir, "access_"+created.Format("20060102_150405")+".log.gz")
+	os.MkdirAll(s.paths.ArchiveDir, 0755)
+	file, err := os.Create(path)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	for i := 0; i < 3; i++ {
+		s.seq++
+		line := fmt.Sprintf("%s from 10.0.0.1:%d accepted tcp:example.com:443 [in >> direct] email: user%d",
+			created.Add(-30*time.Minute).Format("2006/01/02 15:04:05.000000"), 10000+s.seq, s.seq)
+		fmt.Fprintln(gz, line)
+		s.written = append(s.written, line)
+	}
+	if err := gz.Close(); err != nil {
+		s.t.Fatal(err)
+	}
+	file.Close()
+	if err := os.Chtimes(path, created, created); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func TestRenameLegacyArchives(t *testing.T) {
+	s := newScenario(t, time.Date(2026, 10, 16, 10, 5, 0, 0, time.UTC))
+	for _, created := range []time.Time{
+		time.Date(2026, 10, 16, 0, 0, 4, 0, time.UTC),
+		time.Date(2026, 10, 16, 1, 0, 3, 0, time.UTC),
+		time.Date(2026, 10, 16, 2, 0, 2, 0, time.UTC),
+	} {
+		s.legacy(created)
+	}
+
+	var err error
+	s.opts.Naming, err = archiver.ParseNaming("", "", archiver.ZONE_UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch := s.archiver()
+	defer arch.Close()
+	stats, err := arch.RenameArchives(context.Background(), archiver.RenameOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// В имени bash-архиватора - момент создания архива, а в архиве - предыдущий час
+	want := []string{"access_20261015_23Z.log.gz", "access_20261016_00Z.log.gz", "access_20261016_01Z.log.gz"}
+	if got := s.names(); len(stats.Renamed) != 3 || strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("архивы %v, ожидались %v", got, want)
+	}
+	s.checkExactlyOnce()
+}
+
+func TestRenameLocalNamesAcrossDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	s := newScenario(t, time.Date(2026, 10, 24, 23, 30, 0, 0, time.UTC).In(berlin))
+	s.fallBack()
+
+	s.opts.Naming, err = archiver.ParseNaming("access_{node}_{time}.log", "node1", archiver.ZONE_UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch := s.archiver()
+	defer arch.Close()
+	if misnamed := arch.MisnamedArchives(); len(misnamed) != 3 {
+		t.Fatalf("архивов не по шаблону %d, ожидалось 3", len(misnamed))
+	}
+	stats, err := arch.RenameArchives(context.Background(), archiver.RenameOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Час 02 по местному времени - это 00 и 01 UTC: какой из них в архиве, видно по времени его создания
+	want := []string{"access_node1_20261024_23Z.log.gz", "access_node1_20261025_00Z.log.gz", "access_node1_20261025_01Z.log.gz"}
+	if got := s.names(); len(stats.Renamed) != 3 || strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("архивы %v, ожидались %v", got, want)
+	}
+	if misnamed := arch.MisnamedArchives(); len(misnamed) != 0 {
+		t.Fatalf("после переименования не по шаблону: %v", misnamed)
+	}
+	s.checkExactlyOnce()
+
+	// Повторное переименование ничего не меняет, а уплотнение понимает новые имена
+	if stats, err := arch.RenameArchives(context.Background(), archiver.RenameOptions{}); err != nil || len(stats.Renamed) != 0 || stats.Current != 3 {
+		t.Fatalf("повторное переименование: %+v, %v", stats, err)
+	}
+	s.clock.now = time.Date(2026, 10, 26, 1, 0, 0, 0, berlin)
+	if _, err := s.compact(archiver.CompactOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		filepath.Join("2026", "10", "24", "access_node1_20261024Z.log.gz"),
+		filepath.Join("2026", "10", "25", "access_node1_20261025Z.log.gz"),
+	}
+	if got := s.names(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("после уплотнения архивы %v, ожидались %v", got, want)
+	}
+	s.checkExactlyOnce()
+}
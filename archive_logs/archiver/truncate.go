@@ -0,0 +1,251 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DEFAULT_TRUNCATE_KEEP - сколько последних байт access.log остается после усечения, чтобы
+// в панели x-ui были видны свежие строки
+const DEFAULT_TRUNCATE_KEEP = 64 << 10
+
+// TRUNCATION_HEAD - сколько байт начала сохраняемого хвоста запоминается, чтобы найти
+// хвост в файле после усечения
+const TRUNCATION_HEAD = 256
+
+// Truncation - начатое усечение access.log. Сохраняется в итогах запусков до усечения,
+// чтобы и после сбоя на любом шаге позиция указывала на первую непрочитанную строку
+type Truncation struct {
+	// Cut - позиция в исходном файле, с которой начинается сохраняемый хвост
+	Cut int64 `json:"cut"`
+	// Size - размер исходного файла, когда хвост был прочитан
+	Size int64 `json:"size"`
+	// Head - начало хвоста. Хранится байтами (в JSON - base64): граница TRUNCATION_HEAD может
+	// попасть внутрь символа UTF-8, и строка JSON исказила бы его
+	Head []byte `json:"head"`
+	// Processed - сколько байт хвоста уже перенесено в накопитель: после усечения позиция
+	// указывает сразу за ними
+	Processed int64 `json:"processed"`
+}
+
+// truncateSource усекает access.log, если он больше порога, оставляя небольшой хвост.
+// Вызывается под блокировкой и только когда накопитель пуст: все прочитанное уже в архивах.
+// Xray открывает лог с O_APPEND и после усечения продолжает писать в начало файла
+func (a *Archiver) truncateSource(stats *RunStats) error {
+	if a.truncateAbove <= 0 || a.PendingBytes() > 0 {
+		return nil
+	}
+	info, err := a.fs.Stat(a.logFile)
+	if err != nil {
+		return err
+	}
+	if info.Size() <= a.truncateAbove {
+		return nil
+	}
+	sealed := a.getLastProcessedPosition()
+	if sealed > info.Size() {
+		return nil
+	}
+	cut, err := a.truncationCut(sealed)
+	if err != nil || cut == 0 {
+		return err
+	}
+
+	// Хвост читаем до конца файла: строки, дописанные после замера размера, тоже останутся в нем
+	tail, err := a.readSource(cut)
+	if err != nil {
+		return err
+	}
+
+	// Пока строки хвоста переносятся в накопитель и сохраняется состояние, Xray может дописать
+	// в файл новые. Их дочитываем и переносим так же, пока файл не перестанет расти
+	read := sealed - cut
+	t := Truncation{Cut: cut, Head: bytes.Clone(tail[:min(len(tail), TRUNCATION_HEAD)])}
+	for {
+		// Незавершенная строка в конце остается в хвосте, но не считается прочитанной: Xray допишет ее
+		// после возвращенного хвоста
+		processed := max(int64(bytes.LastIndexByte(tail, '\n')+1), read)
+		t.Size, t.Processed = cut+int64(len(tail)), processed
+		state := a.loadRunState()
+		state.Truncation = &t
+		if err := a.saveRunState(state); err != nil {
+			return err
+		}
+
+		// Строки после позиции переносим в накопитель до усечения: в access.log они останутся
+		// только в хвосте, который после сбоя может не вернуться
+		lines, err := a.appendBytes(tail[read:processed])
+		if err != nil {
+			return err
+		}
+		stats.LinesProcessed += lines
+		stats.BytesProcessed += processed - read
+		if err := a.updateLastProcessedPosition(cut + processed); err != nil {
+			return err
+		}
+		read = processed
+
+		more, err := a.readSource(t.Size)
+		if err != nil {
+			return err
+		}
+		if len(more) == 0 {
+			break
+		}
+		tail = append(tail, more...)
+	}
+
+	// Файл перестал расти, и усечение идет сразу за последним чтением. Теряются только строки,
+	// которые Xray успеет записать между этим чтением и усечением, как и при copytruncate в logrotate
+	if err := a.fs.Truncate(a.logFile, 0); err != nil {
+		return err
+	}
+	writeErr := a.restoreTail(tail)
+	if err := a.resolveTruncation(stats); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("хвост не возвращен в %s: %v", a.logFile, writeErr)
+	}
+	a.log.Info("access.log усечен", "freed_bytes", cut, "kept_bytes", len(tail))
+	return nil
+}
+
+// truncationCut возвращает начало первой строки, которая целиком попадает в последние
+// truncateKeep байт до позиции sealed. 0 - усекать нечего
+func (a *Archiver) truncationCut(sealed int64) (int64, error) {
+	start := sealed - a.truncateKeep
+	if start <= 0 {
+		return 0, nil
+	}
+	file, err := a.fs.Open(a.logFile)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	// Начинаем на байт раньше: если там перевод строки, start - уже начало строки
+	if _, err := file.Seek(start-1, io.SeekStart); err != nil {
+		return 0, err
+	}
+	window := make([]byte, sealed-start+1)
+	if _, err := io.ReadFull(file, window); err != nil {
+		return 0, err
+	}
+	if i := bytes.IndexByte(window, '\n'); i >= 0 {
+		return start + int64(i), nil
+	}
+	// Одна строка длиннее хвоста: оставляем только непрочитанное
+	return sealed, nil
+}
+
+// readSource читает access.log от позиции offset до конца
+func (a *Archiver) readSource(offset int64) ([]byte, error) {
+	file, err := a.fs.Open(a.logFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(file)
+}
+
+// restoreTail дописывает хвост в усеченный access.log
+func (a *Archiver) restoreTail(tail []byte) error {
+	file, err := a.fs.OpenFile(a.logFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(tail); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// resolveTruncation завершает начатое усечение: определяет, было ли усечение и куда попал хвост,
+// и ставит позицию за прочитанной частью хвоста. Вызывается в конце усечения и в начале
+// запуска, если прошлый запуск прервался посреди усечения
+func (a *Archiver) resolveTruncation(stats *RunStats) error {
+	state := a.loadRunState()
+	t := state.Truncation
+	if t == nil {
+		return nil
+	}
+
+	truncated, err := a.wasTruncated(*t)
+	if err != nil {
+		return fmt.Errorf("ошибка проверки усечения %s: %v", a.logFile, err)
+	}
+	if truncated {
+		content, err := a.fs.ReadFile(a.logFile)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения %s: %v", a.logFile, err)
+		}
+		// Если хвоста нет, сбой случился до его возвращения: все строки файла новые.
+		// Строки, которые Xray успел записать до возвращения хвоста, оказались перед ним
+		position := int64(0)
+		if i := bytes.Index(content, t.Head); i >= 0 {
+			lines, err := a.appendBytes(content[:i])
+			if err != nil {
+				return fmt.Errorf("ошибка добавления новых строк: %v", err)
+			}
+			stats.LinesProcessed += lines
+			stats.BytesProcessed += int64(i)
+			position = int64(i) + t.Processed
+		}
+		if err := a.updateLastProcessedPosition(position); err != nil {
+			return fmt.Errorf("ошибка обновления позиции: %v", err)
+		}
+		stats.TruncatedBytes = t.Cut
+	}
+
+	state.Truncation = nil
+	return a.saveRunState(state)
+}
+
+// wasTruncated проверяет, усечен ли access.log: до усечения хвост лежит на своем месте
+func (a *Archiver) wasTruncated(t Truncation) (bool, error) {
+	info, err := a.fs.Stat(a.logFile)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < t.Size {
+		return true, nil
+	}
+	file, err := a.fs.Open(a.logFile)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(t.Cut, io.SeekStart); err != nil {
+		return false, err
+	}
+	head := make([]byte, len(t.Head))
+	if _, err := io.ReadFull(file, head); err != nil {
+		return false, err
+	}
+	return !bytes.Equal(head, t.Head), nil
+}
+
+// appendBytes дописывает строки в накопитель и возвращает их число
+func (a *Archiver) appendBytes(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if data[len(data)-1] != '\n' {
+		data = append(data[:len(data):len(data)], '\n')
+	}
+	file, err := a.fs.OpenFile(a.tempHourlyLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return 0, err
+	}
+	return bytes.Count(data, []byte{'\n'}), file.Close()
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+
+	"xui_log_archiver/archiver"
+)
+
+func runBackfill(args []string) int {
+	var opts options
+	flags := newFlagSet("backfill", "Раскладывает строки старых логов по архивам периодов по их меткам времени.\n"+
+		"Файлы (обычные или .gz) перечисляются после флагов. Без файлов импортируются ротированные\n"+
+		"access.log.1, access.log.2.gz, ... и сам access.log, если архиватор еще не начал его читать.\n"+
+		"Периоды, у которых уже есть архив, пропускаются или, с --merge, объединяются с ним.", &opts)
+	opts.registerProfile(flags)
+	merge := flags.Bool("merge", false, "объединять строки с существующими архивами периодов вместо пропуска")
+	dryRun := flags.Bool("dry-run", false, "показать, какие архивы будут созданы, ничего не меняя")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return EXIT_OK
+		}
+		return EXIT_USAGE
+	}
+
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	arch := opts.newArchiver(cfg, profile)
+	defer arch.Close()
+
+	files := flags.Args()
+	for i, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			files[i] = abs
+		}
+	}
+	if len(files) == 0 {
+		files = rotatedLogs(profile.Paths.LogFile)
+		if arch.CanBackfillSource() {
+			if _, err := os.Stat(profile.Paths.LogFile); err == nil {
+				files = append(files, profile.Paths.LogFile)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return opts.finish("backfill", nil, fmt.Errorf("нет файлов для импорта: ротированных копий %s не найдено", profile.Paths.LogFile))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stats, err := arch.Backfill(ctx, files, archiver.BackfillOptions{Merge: *merge, DryRun: *dryRun})
+	if err == nil && !opts.json {
+		printBackfill(os.Stdout, stats, *dryRun)
+	}
+	if err == nil && !*dryRun {
+		// Объединенные с --merge архивы могли уже попасть в хранилище: их перезапись там
+		// не выполняется, их можно отправить вручную
+		var created []string
+		for _, period := range stats.Periods {
+			if period.Action == archiver.BACKFILL_CREATED {
+				created = append(created, period.Archive)
+			}
+		}
+		shipArchives(ctx, opts.output(), cfg, profile, created...)
+	}
+	return opts.finish("backfill", stats, err)
+}
+
+// rotatedLogs возвращает ротированные копии лога (access.log.1, access.log.2.gz,
+// access.log-20250101.gz) от старых к новым
+func rotatedLogs(logFile string) []string {
+	var files []string
+	for _, pattern := range []string{logFile + ".*", logFile + "-*"} {
+		matches, _ := filepath.Glob(pattern)
+		files = append(files, matches...)
+	}
+	modTimes := map[string]int64{}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime().UnixNano()
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return modTimes[files[i]] < modTimes[files[j]] })
+	return files
+}
+
+// backfillActions - подписи действий импорта
+var backfillActions = map[string]string{
+	archiver.BACKFILL_CREATED: "создан",
+	archiver.BACKFILL_MERGED:  "объединен",
+	archiver.BACKFILL_SKIPPED: "пропущен, архив уже есть",
+}
+
+// printBackfill выводит итоги импорта по периодам
+func printBackfill(w io.Writer, stats archiver.BackfillStats, dryRun bool) {
+	fmt.Fprintf(w, "Файлы: %d, строк импортировано: %d\n", len(stats.Files), stats.Lines)
+	for _, file := range stats.Files {
+		fmt.Fprintf(w, "  %s\n", file)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Архив\tСтрок\tДубликатов\tДействие")
+	created, merged, skipped := 0, 0, 0
+	for _, period := range stats.Periods {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", filepath.Base(period.Archive), period.Lines, period.Duplicates, backfillActions[period.Action])
+		switch period.Action {
+		case archiver.BACKFILL_CREATED:
+			created++
+		case archiver.BACKFILL_MERGED:
+			merged++
+		case archiver.BACKFILL_SKIPPED:
+			skipped++
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nСоздано архивов: %d, объединено: %d, пропущено: %d\n", created, merged, skipped)
+	if stats.Live > 0 {
+		fmt.Fprintf(w, "Строк текущего периода: %d, их заархивирует обычный запуск\n", stats.Live)
+	}
+	if stats.Appended > 0 {
+		fmt.Fprintf(w, "Из них дописано в накопитель из ротированных файлов: %d\n", stats.Appended)
+	}
+	if stats.Unparsed > 0 {
+		fmt.Fprintf(w, "❗ Строк без метки времени пропущено: %d\n", stats.Unparsed)
+	}
+	if dryRun {
+		fmt.Fprintln(w, "Пробный запуск (--dry-run): архивы не изменены")
+	}
+}
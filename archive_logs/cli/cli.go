@@ -0,0 +1,328 @@
+// Package cli реализует подкоманды xui_log_archiver
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/config"
+	"xui_log_archiver/installer"
+)
+
+// Коды завершения программы
+const (
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	EXIT_USAGE = 2
+)
+
+// PROGRAM_NAME - имя программы в справке
+const PROGRAM_NAME = "xui_log_archiver"
+
+// command - подкоманда командной строки
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+func commands() []command {
+	return []command{
+		{"archive", "Перенести новые строки access.log в накопитель и при необходимости создать архив", runArchive},
+		{"backfill", "Разложить старые и ротированные логи по архивам периодов", runBackfill},
+		{"install", "Установить программу и добавить автозапуск в cron или systemd", runInstall},
+		{"uninstall", "Удалить автозапуск из cron или systemd", runUninstall},
+		{"status", "Показать состояние автозапуска и архивирования", runStatus},
+		{"doctor", "Проверить всю цепочку архивирования и подсказать исправления", runDoctor},
+		{"compact", "Объединить архивы завершенных дней и месяцев в YYYY/MM/DD", runCompact},
+		{"rename", "Переименовать архивы по шаблону имени из файла настроек", runRename},
+		{"upload", "Отправить архивы из очереди в S3-совместимое хранилище или на коллектор", runUpload},
+		{"collect", "Принимать архивы от узлов по HTTPS с проверкой сертификатов", runCollect},
+		{"history", "Показать журнал запусков с итогами по периодам и графиком", runHistory},
+		{"merge", "Объединить архивы в один отсортированный файл без дубликатов", runMerge},
+		{"dashboard", "Запустить веб-дашборд по архивам", runDashboard},
+		{"gen", "Создать тестовые строки access.log Xray или архивы за прошлые периоды", runGen},
+		{"daemon", "Архивировать с интервалом из настроек и отдавать метрики по HTTP", runDaemon},
+		{"update", "Установить новую версию программы с сохранением предыдущей", runUpdate},
+		{"rollback", "Вернуть предыдущую версию программы", runRollback},
+		{"version", "Показать версию программы", runVersion},
+	}
+}
+
+// legacyAliases - флаги старых версий, которые остаются в существующих crontab
+var legacyAliases = map[string]string{
+	"--cron":      "archive",
+	"--dashboard": "dashboard",
+	"--daemon":    "daemon",
+}
+
+// Run выполняет подкоманду и возвращает код завершения
+func Run(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return EXIT_USAGE
+	}
+
+	name, rest := args[0], args[1:]
+	if alias, ok := legacyAliases[name]; ok {
+		name = alias
+		// Старые флаги принимали адрес позиционным аргументом: --dashboard 0.0.0.0:8090
+		if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+			rest = append([]string{"--addr", rest[0]}, rest[1:]...)
+		}
+	}
+
+	switch name {
+	case "help", "-h", "--help":
+		printUsage(os.Stdout)
+		return EXIT_OK
+	case "-v", "--version":
+		name = "version"
+	}
+
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd.run(rest)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Неизвестная команда: %s\n\n", name)
+	printUsage(os.Stderr)
+	return EXIT_USAGE
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Использование: %s <команда> [флаги]\n\n", PROGRAM_NAME)
+	fmt.Fprintln(w, "Без аргументов запускается интерактивное меню.")
+	fmt.Fprintln(w, "\nКоманды:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nСправка по команде: %s <команда> --help\n", PROGRAM_NAME)
+}
+
+// options - флаги, общие для всех команд
+type options struct {
+	json       bool
+	configPath string
+	profile    string
+}
+
+// newFlagSet создает набор флагов команды с общими флагами --json и --config
+func newFlagSet(name, summary string, opts *options) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.BoolVar(&opts.json, "json", false, "вывод результата в формате JSON")
+	flags.StringVar(&opts.configPath, "config", config.Path(), "файл настроек")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Использование: %s %s [флаги]\n\n%s\n\nФлаги:\n", PROGRAM_NAME, name, summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// registerProfile добавляет флаг --profile для команд, работающих с экземпляром x-ui
+func (o *options) registerProfile(flags *flag.FlagSet) {
+	flags.StringVar(&o.profile, "profile", config.DEFAULT_PROFILE, "профиль экземпляра x-ui из файла настроек")
+}
+
+// parseFlags разбирает флаги. Второе значение false, если команду выполнять не нужно
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return EXIT_OK, false
+		}
+		return EXIT_USAGE, false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "Лишние аргументы: %s\n", strings.Join(flags.Args(), " "))
+		flags.Usage()
+		return EXIT_USAGE, false
+	}
+	return EXIT_OK, true
+}
+
+// output возвращает поток для сообщений пользователю: в режиме --json они подавляются,
+// чтобы в stdout был только JSON
+func (o *options) output() io.Writer {
+	if o.json {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// loadConfig читает файл настроек, при ошибке продолжает с настройками по умолчанию
+func (o *options) loadConfig() *config.Config {
+	cfg, err := config.Load(o.configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Предупреждение: %v, используются настройки по умолчанию\n", err)
+	}
+	return cfg
+}
+
+// loadProfile читает файл настроек и находит в нем профиль из --profile.
+// Неизвестный профиль - ошибка использования, а не повод работать с /usr/local/x-ui
+func (o *options) loadProfile() (*config.Config, config.Resolved, bool) {
+	cfg := o.loadConfig()
+	profile, err := cfg.Resolve(o.profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка: %v\n", err)
+		return cfg, profile, false
+	}
+	return cfg, profile, true
+}
+
+// newArchiver создает архиватор для файлов профиля с настройками из файла настроек
+func (o *options) newArchiver(cfg *config.Config, profile config.Resolved) *archiver.Archiver {
+	arch := archiver.NewWithOptions(archiverOptions(cfg, profile))
+	if err := arch.SetLogging(cfg.Logging); err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка настройки логирования: %v\n", err)
+	}
+	return arch
+}
+
+// archiverOptions возвращает настройки архиватора профиля из файла настроек
+func archiverOptions(cfg *config.Config, profile config.Resolved) archiver.Options {
+	period, err := archiver.ParsePeriod(profile.Schedule.Rollover)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Предупреждение: %v, архив создается каждый час\n", err)
+	}
+	naming, err := profile.Naming.Parse()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Предупреждение: %v, архивы называются по шаблону %s\n", err, archiver.DEFAULT_NAMING)
+	}
+	opts := archiver.Options{
+		Paths:         profile.Paths,
+		Period:        period,
+		Naming:        naming,
+		MaxPending:    profile.Schedule.MaxAccumulatorMB << 20,
+		TruncateAbove: profile.Schedule.TruncateAboveMB << 20,
+		TruncateKeep:  profile.Schedule.TruncateKeepKB << 10,
+		MinFree:       archiver.DEFAULT_MIN_FREE,
+		ResumeFree:    cfg.Disk.ResumeFreeMB << 20,
+	}
+	switch {
+	case cfg.Disk.MinFreeMB < 0:
+		opts.MinFree = 0
+	case cfg.Disk.MinFreeMB > 0:
+		opts.MinFree = cfg.Disk.MinFreeMB << 20
+	}
+	if cfg.Disk.EmergencyKeep != "" {
+		keep, err := parseSince(cfg.Disk.EmergencyKeep)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Предупреждение: disk.emergency_keep: %v, архивы в аварийном режиме не удаляются\n", err)
+		}
+		opts.EmergencyKeep = keep
+	}
+	if cfg.Disk.AlertCommand != "" {
+		opts.Alert = alertCommand(cfg.Disk.AlertCommand, profile.Name)
+	}
+	return opts
+}
+
+// ALERT_TIMEOUT - сколько ждать команду оповещения, чтобы она не задерживала архивирование
+const ALERT_TIMEOUT = 30 * time.Second
+
+// alertCommand возвращает обработчик оповещений, который запускает команду через sh.
+// Оповещение передается в переменных окружения XUI_ALERT_*
+func alertCommand(command, profile string) func(archiver.Alert) {
+	return func(alert archiver.Alert) {
+		ctx, cancel := context.WithTimeout(context.Background(), ALERT_TIMEOUT)
+		defer cancel()
+		emergency := "0"
+		if alert.Emergency {
+			emergency = "1"
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = append(os.Environ(),
+			"XUI_ALERT_PROFILE="+profile,
+			"XUI_ALERT_EMERGENCY="+emergency,
+			"XUI_ALERT_MESSAGE="+alert.Message,
+			fmt.Sprintf("XUI_ALERT_FREE_BYTES=%d", alert.FreeBytes),
+			"XUI_ALERT_TIME="+alert.Time.Format(time.RFC3339),
+		)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка команды оповещения: %v %s\n", err, strings.TrimSpace(string(output)))
+		}
+	}
+}
+
+// confirm спрашивает подтверждение у пользователя. Без терминала требует явного --yes
+func confirm(question string, yes bool) (int, bool) {
+	if yes {
+		return EXIT_OK, true
+	}
+
+	if !isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "Нет терминала для подтверждения, используйте --yes")
+		return EXIT_USAGE, false
+	}
+
+	fmt.Printf("%s [y/N]: ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" && answer != "д" && answer != "да" {
+		fmt.Println("Отменено")
+		return EXIT_ERROR, false
+	}
+	return EXIT_OK, true
+}
+
+// confirm спрашивает подтверждение для команды name. В режиме --json вопрос испортил бы вывод,
+// и без --yes команда завершается ошибкой в JSON
+func (o *options) confirm(name, question string, yes bool) (int, bool) {
+	if o.json && !yes {
+		o.finish(name, nil, errors.New("нужно подтверждение: с --json добавьте --yes"))
+		return EXIT_USAGE, false
+	}
+	return confirm(question, yes)
+}
+
+// finishChange печатает результат операции установщика: changed или unchanged
+func (o *options) finishChange(name string, result installer.Result, err error) int {
+	if err == nil && !o.json {
+		if result.Changed {
+			fmt.Println("changed")
+		} else {
+			fmt.Println("unchanged")
+		}
+	}
+	return o.finish(name, result, err)
+}
+
+// jsonResult - единый формат ответа команд в режиме --json
+type jsonResult struct {
+	Command string      `json:"command"`
+	OK      bool        `json:"ok"`
+	Error   string      `json:"error,omitempty"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// finish печатает результат команды и возвращает код завершения
+func (o *options) finish(name string, result interface{}, err error) int {
+	if o.json {
+		resp := jsonResult{Command: name, OK: err == nil, Result: result}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(resp)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка: %v\n", err)
+	}
+
+	if err != nil {
+		return EXIT_ERROR
+	}
+	return EXIT_OK
+}
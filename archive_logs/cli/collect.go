@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"xui_log_archiver/collector"
+	"xui_log_archiver/config"
+)
+
+func runCollect(args []string) int {
+	var opts options
+	flags := newFlagSet("collect", "Принимает архивы от узлов по HTTPS с проверкой сертификатов узлов и хранит их\n"+
+		"в <dir>/<узел>/ - имя узла берется из CN его сертификата. Одинаковые архивы хранятся один раз.\n"+
+		"Сертификаты для узла выпускает collect --issue <узел> --hosts <имя или IP коллектора>.", &opts)
+	addr := flags.String("addr", collector.DEFAULT_ADDR, "адрес HTTPS-сервера")
+	dir := flags.String("dir", collector.DEFAULT_DIR, "директория архивов узлов")
+	tlsDir := flags.String("tls-dir", "", "директория сертификатов коллектора, по умолчанию <dir>/tls")
+	certFile := flags.String("cert", "", "сертификат сервера вместо <tls-dir>/server.pem")
+	keyFile := flags.String("key", "", "ключ сервера вместо <tls-dir>/server.key")
+	clientCA := flags.String("client-ca", "", "CA сертификатов узлов вместо <tls-dir>/ca.pem")
+	hosts := flags.String("hosts", "", "имена и IP-адреса коллектора через запятую для сертификата сервера")
+	issue := flags.String("issue", "", "выпустить сертификат узла (и CA с сертификатом сервера, если их нет) и выйти")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	certs := collector.Certs{Dir: firstNonEmpty(*tlsDir, filepath.Join(*dir, "tls"))}
+	if *issue != "" {
+		return opts.finish("collect", nil, issueNode(certs, *issue, *addr, splitList(*hosts)))
+	}
+
+	tlsConfig, err := collector.TLSConfig(firstNonEmpty(*certFile, certs.ServerCert()),
+		firstNonEmpty(*keyFile, certs.ServerKey()), firstNonEmpty(*clientCA, certs.CA()))
+	if err != nil {
+		return opts.finish("collect", nil, fmt.Errorf("%v (выпустите сертификаты: %s collect --issue <узел> --hosts <имя коллектора>)", err, PROGRAM_NAME))
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return opts.finish("collect", nil, err)
+	}
+	server := collector.New(*dir, slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	fmt.Printf("📥 Коллектор принимает архивы на https://%s%s, архивы в %s\n", *addr, collector.API_PATH, *dir)
+	if err := server.ListenAndServeTLS(*addr, tlsConfig); err != nil {
+		return opts.finish("collect", nil, fmt.Errorf("ошибка запуска коллектора: %v", err))
+	}
+	return EXIT_OK
+}
+
+// issueNode выпускает сертификат узла и печатает, что скопировать на узел и что добавить
+// в его файл настроек
+func issueNode(certs collector.Certs, node, addr string, hosts []string) error {
+	if len(hosts) == 0 {
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = []string{hostname}
+		}
+	}
+	created, err := certs.Init(hosts)
+	for _, file := range created {
+		fmt.Printf("🔐 Создан %s\n", file)
+	}
+	if err != nil {
+		return err
+	}
+	if err := certs.IssueNode(node); err != nil {
+		return err
+	}
+	fmt.Printf("🔐 Создан сертификат узла %s: %s\n\n", node, certs.NodeCert(node))
+
+	// На узле сертификаты лежат рядом с файлом настроек
+	remote := filepath.Join(filepath.Dir(config.DEFAULT_CONFIG_FILE), "collector")
+	port := addr[strings.LastIndex(addr, ":")+1:]
+	host := "<имя коллектора>"
+	if len(hosts) > 0 {
+		host = hosts[0]
+	}
+	snippet, _ := json.MarshalIndent(map[string]interface{}{
+		"upload": map[string]interface{}{
+			"collector": config.Collector{
+				URL:  "https://" + host + ":" + port,
+				CA:   filepath.Join(remote, "ca.pem"),
+				Cert: filepath.Join(remote, node+".pem"),
+				Key:  filepath.Join(remote, node+".key"),
+			},
+		},
+	}, "", "  ")
+	fmt.Printf("Скопируйте на узел %s в %s/:\n  %s\n  %s\n  %s\n\n", node, remote, certs.CA(), certs.NodeCert(node), certs.NodeKey(node))
+	fmt.Printf("и добавьте в его %s:\n%s\n", config.DEFAULT_CONFIG_FILE, snippet)
+	return nil
+}
+
+// splitList разбирает список через запятую без пустых элементов
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,694 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/config"
+	"xui_log_archiver/dashboard"
+	"xui_log_archiver/doctor"
+	"xui_log_archiver/installer"
+	"xui_log_archiver/merger"
+	"xui_log_archiver/metrics"
+	"xui_log_archiver/version"
+)
+
+func runArchive(args []string) int {
+	var opts options
+	flags := newFlagSet("archive", "Переносит новые строки access.log во временный накопитель.\n"+
+		"В начале нового периода (по умолчанию часа) накопитель сжимается в архив.", &opts)
+	opts.registerProfile(flags)
+	textfile := flags.String("metrics-textfile", "",
+		"файл метрик для textfile collector node_exporter (пишется, если директория существует;\n"+
+			"по умолчанию "+metrics.DEFAULT_TEXTFILE+", для профиля - "+metrics.TextfileFor("<профиль>")+")")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	arch := opts.newArchiver(cfg, profile)
+	defer arch.Close()
+
+	// У каждого профиля свой файл метрик, а в самих метриках - метка profile
+	if *textfile == "" {
+		*textfile = metrics.DEFAULT_TEXTFILE
+		if profile.Name != config.DEFAULT_PROFILE {
+			*textfile = metrics.TextfileFor(profile.Name)
+		}
+	}
+
+	// Метрики пишем, только если директория textfile collector существует
+	var registry *metrics.Registry
+	if _, err := os.Stat(filepath.Dir(*textfile)); err == nil {
+		registry = metrics.NewRegistry()
+		if profile.Name != config.DEFAULT_PROFILE {
+			registry.SetConstLabel("profile", profile.Name)
+		}
+		arch.SetMetrics(archiver.NewMetrics(registry))
+		// Восстанавливаем счетчики предыдущих запусков
+		registry.LoadTextfile(*textfile)
+	}
+
+	out := opts.output()
+	fmt.Fprintln(out, "Начинаем процесс архивирования...")
+	stats, err := arch.Run(context.Background())
+
+	if registry != nil {
+		if err := registry.WriteTextfile(*textfile); err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка записи метрик в %s: %v\n", *textfile, err)
+		}
+	}
+
+	// Запуск по расписанию, совпавший с еще идущим предыдущим, просто пропускается
+	if errors.Is(err, archiver.ErrLocked) {
+		fmt.Fprintf(out, "⏳ %v, запуск пропущен\n", err)
+		return opts.finish("archive", stats, nil)
+	}
+	if errors.Is(err, archiver.ErrLowDiskSpace) {
+		printEmergency(out, stats)
+		// С delete_local отправка еще и освобождает место
+		shipArchives(context.Background(), out, cfg, profile, sealedArchives(stats)...)
+	}
+	if err != nil {
+		return opts.finish("archive", stats, fmt.Errorf("ошибка архивирования: %v", err))
+	}
+	printRunStats(out, stats)
+
+	compacted, err := compactAfterRun(context.Background(), arch, profile.Schedule, stats)
+	if err != nil {
+		return opts.finish("archive", stats, fmt.Errorf("ошибка уплотнения архивов: %v", err))
+	}
+	if len(compacted.Archives) > 0 {
+		fmt.Fprintf(out, "🗜️  Архивы уплотнены: %d -> %d\n", compacted.Inputs, len(compacted.Archives))
+	}
+	shipArchives(context.Background(), out, cfg, profile, append(sealedArchives(stats), compactedArchives(compacted)...)...)
+	return opts.finish("archive", stats, nil)
+}
+
+// sealedArchives возвращает архивы и части архивов, созданные запуском
+func sealedArchives(stats archiver.RunStats) []string {
+	archives := append([]string{}, stats.PartFiles...)
+	if stats.ArchiveFile != "" {
+		archives = append(archives, stats.ArchiveFile)
+	}
+	return archives
+}
+
+// printRunStats выводит итоги запуска архивирования
+func printRunStats(w io.Writer, stats archiver.RunStats) {
+	if stats.RotationDetected {
+		fmt.Fprintln(w, "❗ access.log был очищен или заменен, он прочитан с начала")
+	}
+	for _, part := range stats.PartFiles {
+		fmt.Fprintf(w, "📦 Создана часть архива %s\n", part)
+	}
+	if stats.ArchiveFile != "" {
+		fmt.Fprintf(w, "📦 Создан архив %s\n", stats.ArchiveFile)
+	} else {
+		fmt.Fprintf(w, "Архив будет создан после %s\n", stats.NextRollover.Format("2006-01-02 15:04"))
+	}
+	if stats.TruncatedBytes > 0 {
+		fmt.Fprintf(w, "✂️  access.log усечен, освобождено %.1f МБ\n", float64(stats.TruncatedBytes)/(1<<20))
+	}
+	fmt.Fprintf(w, "Архивирование завершено успешно! Время выполнения: %v\n", stats.Duration)
+}
+
+// printEmergency выводит, что архиватор сделал в аварийном режиме
+func printEmergency(w io.Writer, stats archiver.RunStats) {
+	fmt.Fprintf(w, "🚨 Мало места на диске (свободно %d МБ): новые строки остаются в access.log до освобождения места\n", stats.FreeBytes>>20)
+	for _, part := range stats.PartFiles {
+		fmt.Fprintf(w, "📦 Накопитель сжат в %s\n", part)
+	}
+	if stats.ArchiveFile != "" {
+		fmt.Fprintf(w, "📦 Накопитель сжат в %s\n", stats.ArchiveFile)
+	}
+	for _, archive := range stats.DeletedArchives {
+		fmt.Fprintf(w, "🗑️  Удален архив %s\n", archive)
+	}
+}
+
+// installerFlags - флаги, общие для команд установщика
+type installerFlags struct {
+	binaryPath string
+	yes        bool
+}
+
+func (f *installerFlags) register(flags *flag.FlagSet, withYes bool) {
+	flags.StringVar(&f.binaryPath, "binary-path", installer.SCRIPT_PATH, "путь установленной программы, который вызывает cron")
+	if withYes {
+		flags.BoolVar(&f.yes, "yes", false, "не спрашивать подтверждение (для автоматизации)")
+	}
+}
+
+func (f *installerFlags) newInstaller(opts *options, profile config.Resolved) *installer.Installer {
+	inst := installer.New()
+	inst.SetOutput(opts.output())
+	inst.SetBinaryPath(f.binaryPath)
+	inst.SetProfile(profile.Name, profile.Paths)
+	return inst
+}
+
+func runInstall(args []string) int {
+	var opts options
+	var inst installerFlags
+	flags := newFlagSet("install", "Копирует программу, создает директории и добавляет задачу в cron или таймер systemd.\n"+
+		"Расписание строится по интервалу запусков. Заданные --dir, --interval, --rollover\n"+
+		"--max-accumulator-mb и --truncate-* сохраняются в файл настроек (для профиля - в его описание).\n"+
+		"У каждого профиля своя задача, поэтому на сервере может работать несколько x-ui.\n"+
+		"Команда идемпотентна: повторный запуск сообщает unchanged.", &opts)
+	inst.register(flags, true)
+	opts.registerProfile(flags)
+	dir := flags.String("dir", "", "директория экземпляра x-ui профиля, например /opt/x-ui-2 (создает или изменяет профиль)")
+	scheduler := flags.String("scheduler", installer.SCHEDULER_CRON, "планировщик запусков: cron или systemd")
+	interval := flags.String("interval", "", "интервал запусков: 5m, 10m, 15m, 1h... (по умолчанию из файла настроек или "+installer.DEFAULT_INTERVAL+")")
+	rollover := flags.String("rollover", "", "период архива: hourly, daily или длительность вроде 15m (по умолчанию из файла настроек или hourly)")
+	schedule := flags.String("schedule", "", "произвольное расписание cron вместо --interval")
+	maxAccumulator := flags.Int64("max-accumulator-mb", -1, "максимальный размер накопителя в МБ, при превышении создается часть архива (0 - без ограничения)")
+	truncateAbove := flags.Int64("truncate-above-mb", -1, "усекать access.log больше этого размера в МБ после архивирования (0 - не усекать)")
+	truncateKeep := flags.Int64("truncate-keep-kb", -1, "сколько последних КБ access.log оставлять при усечении")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	// Профиль, заданный через --dir, еще может отсутствовать в файле настроек
+	cfg := opts.loadConfig()
+	if *dir != "" {
+		if cfg.Profiles == nil {
+			cfg.Profiles = map[string]config.Profile{}
+		}
+		described := cfg.Profiles[opts.profile]
+		described.Dir = *dir
+		cfg.Profiles[opts.profile] = described
+	}
+	profile, err := cfg.Resolve(opts.profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка: %v (задайте директорию через --dir)\n", err)
+		return EXIT_USAGE
+	}
+
+	// Предупреждаем заранее: с отключенным access логом архиватору нечего архивировать
+	if profile.Xray != nil && !opts.json {
+		for _, warning := range profile.Xray.Warnings {
+			fmt.Fprintf(os.Stderr, "⚠️  %s: %s\n", profile.Xray.ConfigFile, warning)
+		}
+	}
+
+	setup := inst.newInstaller(&opts, profile)
+	if err := setup.SetScheduler(*scheduler); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	period, err := archiver.ParsePeriod(firstNonEmpty(*rollover, profile.Schedule.Rollover))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+
+	if *schedule != "" {
+		if *interval != "" {
+			fmt.Fprintln(os.Stderr, "--schedule и --interval нельзя указывать вместе")
+			return EXIT_USAGE
+		}
+		if *scheduler == installer.SCHEDULER_SYSTEMD {
+			fmt.Fprintln(os.Stderr, "--schedule поддерживается только для cron, для systemd используйте --interval")
+			return EXIT_USAGE
+		}
+		if err := setup.SetSchedule(*schedule); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_USAGE
+		}
+	} else {
+		every, err := time.ParseDuration(firstNonEmpty(*interval, profile.Schedule.PollInterval, installer.DEFAULT_INTERVAL))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Некорректный интервал запусков: %v\n", err)
+			return EXIT_USAGE
+		}
+		// Иначе архив создавался бы не на границе периода, а при следующем запуске после нее
+		if every <= 0 || period.Duration()%every != 0 {
+			fmt.Fprintf(os.Stderr, "Период архива %s должен быть кратен интервалу запусков %v\n", period, every)
+			return EXIT_USAGE
+		}
+		if err := setup.SetInterval(every); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_USAGE
+		}
+	}
+
+	question := fmt.Sprintf("Установить %s (%s, архив: %s)?", inst.binaryPath, setup.CronEntry(), period)
+	if *scheduler == installer.SCHEDULER_SYSTEMD {
+		question = fmt.Sprintf("Установить %s (таймер systemd профиля %s, архив: %s)?", inst.binaryPath, profile.Name, period)
+	}
+	if code, ok := opts.confirm("install", question, inst.yes); !ok {
+		return code
+	}
+
+	result, err := setup.Install()
+	if err != nil {
+		return opts.finishChange("install", result, fmt.Errorf("ошибка установки автозапуска: %v", err))
+	}
+
+	// Запуски по расписанию читают директорию профиля, интервал, период, размер накопителя
+	// и настройки усечения из файла настроек. Настройки профиля, кроме default, сохраняются в его описание
+	if *dir != "" || *interval != "" || *rollover != "" || *maxAccumulator >= 0 || *truncateAbove >= 0 || *truncateKeep >= 0 {
+		changed, err := config.Update(opts.configPath, func(c *config.Config) {
+			schedule := &c.Schedule
+			described := c.Profiles[profile.Name]
+			if profile.Name != config.DEFAULT_PROFILE {
+				schedule = &described.Schedule
+			}
+			if *dir != "" {
+				described.Dir = *dir
+			}
+			if *interval != "" {
+				schedule.PollInterval = *interval
+			}
+			if *rollover != "" {
+				schedule.Rollover = period.String()
+			}
+			if *maxAccumulator >= 0 {
+				schedule.MaxAccumulatorMB = *maxAccumulator
+			}
+			if *truncateAbove >= 0 {
+				schedule.TruncateAboveMB = *truncateAbove
+			}
+			if *truncateKeep >= 0 {
+				schedule.TruncateKeepKB = *truncateKeep
+			}
+			if *dir != "" || profile.Name != config.DEFAULT_PROFILE {
+				if c.Profiles == nil {
+					c.Profiles = map[string]config.Profile{}
+				}
+				c.Profiles[profile.Name] = described
+			}
+		})
+		if err != nil {
+			return opts.finishChange("install", result, err)
+		}
+		if changed {
+			if !opts.json {
+				fmt.Printf("✅ Настройки профиля %s сохранены в %s\n", profile.Name, opts.configPath)
+			}
+			result.Changed = true
+			result.Actions = append(result.Actions, fmt.Sprintf("настройки профиля %s сохранены в %s", profile.Name, opts.configPath))
+		}
+	}
+	return opts.finishChange("install", result, nil)
+}
+
+// firstNonEmpty возвращает первое непустое значение
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func runUninstall(args []string) int {
+	var opts options
+	var inst installerFlags
+	flags := newFlagSet("uninstall", "Удаляет задачу профиля из cron и его таймер systemd.\n"+
+		"С --purge сначала запечатывает накопитель в архив, затем удаляет задачу,\n"+
+		"файлы состояния профиля и программу, если ее не запускают другие профили.\n"+
+		"Архивы удаляются только с --purge-archives.\n"+
+		"Команда идемпотентна: если удалять нечего, сообщает unchanged.", &opts)
+	inst.register(flags, true)
+	opts.registerProfile(flags)
+	purge := flags.Bool("purge", false, "удалить также программу и файлы состояния")
+	purgeArchives := flags.Bool("purge-archives", false, "удалить также директорию архивов (включает --purge)")
+	dryRun := flags.Bool("dry-run", false, "только показать, какие файлы и строки crontab будут затронуты")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	setup := inst.newInstaller(&opts, profile)
+
+	if !*purge && !*purgeArchives {
+		if *dryRun {
+			fmt.Fprintln(os.Stderr, "--dry-run поддерживается только вместе с --purge")
+			return EXIT_USAGE
+		}
+		if code, ok := opts.confirm("uninstall", fmt.Sprintf("Удалить автозапуск архиватора профиля %s?", profile.Name), inst.yes); !ok {
+			return code
+		}
+		result, err := setup.Uninstall()
+		if err != nil {
+			err = fmt.Errorf("ошибка удаления автозапуска: %v", err)
+		}
+		return opts.finishChange("uninstall", result, err)
+	}
+
+	question := "Удалить автозапуск, программу и файлы состояния? Архивы будут сохранены"
+	if *purgeArchives {
+		question = "Удалить автозапуск, программу, файлы состояния и ВСЕ АРХИВЫ?"
+	}
+	if code, ok := opts.confirm("uninstall", question, inst.yes || *dryRun); !ok {
+		return code
+	}
+
+	arch := opts.newArchiver(cfg, profile)
+	defer arch.Close()
+	result, err := setup.Purge(installer.PurgeOptions{
+		PurgeArchives: *purgeArchives,
+		DryRun:        *dryRun,
+		Sealer:        archiveSealer{arch},
+	})
+	if err != nil {
+		err = fmt.Errorf("ошибка удаления архиватора: %v", err)
+	}
+	return opts.finishChange("uninstall", result, err)
+}
+
+// archiveSealer связывает архиватор с установщиком при полном удалении
+type archiveSealer struct {
+	arch *archiver.Archiver
+}
+
+func (s archiveSealer) SealPending() (string, error) {
+	stats, err := s.arch.SealPending()
+	return stats.ArchiveFile, err
+}
+
+func (s archiveSealer) PendingBytes() int64 {
+	// Без файла позиции архиватор не запускался или уже удален: access.log прочитался бы
+	// с начала и попал в архив повторно
+	if _, err := os.Stat(s.arch.Paths().PositionFile); err != nil {
+		return 0
+	}
+	return s.arch.PendingBytes() + s.arch.LagBytes()
+}
+
+func runStatus(args []string) int {
+	var opts options
+	var inst installerFlags
+	flags := newFlagSet("status", "Показывает автозапуск, итоги последнего запуска, необработанные байты access.log,\n"+
+		"накопитель, последний архив, время следующего архива и размер архивов.", &opts)
+	inst.register(flags, false)
+	opts.registerProfile(flags)
+	all := flags.Bool("all", false, "показать все профили из файла настроек")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	if !*all {
+		report, err := profileStatus(&opts, &inst, cfg, profile)
+		if err != nil {
+			return opts.finish("status", nil, err)
+		}
+		if !opts.json {
+			printStatus(os.Stdout, report, time.Now())
+		}
+		return opts.finish("status", report, nil)
+	}
+
+	var reports []statusReport
+	for n, name := range cfg.ProfileNames() {
+		profile, err := cfg.Resolve(name)
+		if err != nil {
+			return opts.finish("status", reports, err)
+		}
+		report, err := profileStatus(&opts, &inst, cfg, profile)
+		if err != nil {
+			return opts.finish("status", reports, err)
+		}
+		reports = append(reports, report)
+		if !opts.json {
+			if n > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("Профиль %s\n", name)
+			printStatus(os.Stdout, report, time.Now())
+		}
+	}
+	return opts.finish("status", reports, nil)
+}
+
+// profileStatus собирает состояние установки и архивирования профиля
+func profileStatus(opts *options, inst *installerFlags, cfg *config.Config, profile config.Resolved) (statusReport, error) {
+	// status только читает файлы профиля: архиватор без логирования не создает ни директорию
+	// архивов, ни журналы
+	var report statusReport
+	autostart, err := inst.newInstaller(opts, profile).Status()
+	if err != nil {
+		return report, err
+	}
+	report.Autostart = autostart
+	report.Logs = newLogsReport(profile)
+
+	report.Health = archiver.NewWithOptions(archiverOptions(cfg, profile)).Health(time.Now())
+	report.Upload = newUploadReport(newShipper(cfg, profile))
+	return report, nil
+}
+
+func runDoctor(args []string) int {
+	var opts options
+	var inst installerFlags
+	flags := newFlagSet("doctor", "Проверяет всю цепочку архивирования: автозапуск, планировщик, программу,\n"+
+		"access.log, конфигурацию Xray, директорию архивов, место на диске, несжатые архивы\n"+
+		"и позицию архиватора. Для каждой проблемы предлагает исправление.\n"+
+		"Завершается с кодом 1, если хотя бы одна проверка не пройдена.", &opts)
+	inst.register(flags, false)
+	opts.registerProfile(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	arch := opts.newArchiver(cfg, profile)
+	defer arch.Close()
+
+	check := doctor.New(profile, inst.newInstaller(&opts, profile), arch)
+	check.SetUpload(newShipper(cfg, profile))
+	report := check.Run()
+	if !opts.json {
+		printDoctor(os.Stdout, report)
+	}
+
+	var err error
+	if report.Failed > 0 {
+		err = fmt.Errorf("не пройдено проверок: %d", report.Failed)
+	}
+	return opts.finish("doctor", report, err)
+}
+
+func runMerge(args []string) int {
+	var opts options
+	flags := newFlagSet("merge", "Копирует и распаковывает архивы, объединяет их в один файл,\n"+
+		"сортирует строки и удаляет дубликаты.", &opts)
+	opts.registerProfile(flags)
+	source := flags.String("source", "", "директория с архивами (по умолчанию архивы профиля, "+merger.ARCHIVE_SOURCE_DIR+")")
+	dest := flags.String("dest", "", "директория результата (по умолчанию mergelog в директории профиля, "+merger.MERGE_DEST_DIR+")")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	_, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+
+	merge := merger.New()
+	merge.SetOutput(opts.output())
+	merge.SetSourceDir(firstNonEmpty(*source, profile.Paths.ArchiveDir))
+	merge.SetDestDir(firstNonEmpty(*dest, filepath.Join(filepath.Dir(profile.Paths.StateFile), "mergelog")))
+
+	result, err := merge.Run()
+	if err == nil && !opts.json {
+		fmt.Printf("%s: Логи успешно скопированы, объединены и сохранены в %s (архивов: %d, периодов: %d, из них по частям: %d, строк: %d, дубликатов: %d)\n",
+			time.Now().Format("2006-01-02 15:04:05"), result.MergedFile, result.Archives, result.Periods, result.MultiPartPeriods, result.Lines, result.Duplicates)
+	}
+	return opts.finish("merge", result, err)
+}
+
+func runDashboard(args []string) int {
+	var opts options
+	flags := newFlagSet("dashboard", "Запускает встроенный веб-дашборд по архивам DNS-активности.", &opts)
+	opts.registerProfile(flags)
+	addr := flags.String("addr", dashboard.DEFAULT_ADDR, "адрес HTTP-сервера")
+	window := flags.String("window", "90d", "за какой срок держать записи в памяти: 7d, 720h; 0 - за все время")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	var keep time.Duration
+	if *window != "0" {
+		var err error
+		if keep, err = parseSince(*window); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_USAGE
+		}
+	}
+
+	_, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	dash := dashboard.New(profile.Paths.ArchiveDir, profile.Paths.TempHourlyLog)
+	dash.SetWindow(keep)
+	fmt.Printf("🌐 Дашборд доступен по адресу http://%s/\n", *addr)
+	if err := dash.ListenAndServe(*addr); err != nil {
+		return opts.finish("dashboard", nil, fmt.Errorf("ошибка запуска дашборда: %v", err))
+	}
+	return EXIT_OK
+}
+
+func runDaemon(args []string) int {
+	var opts options
+	flags := newFlagSet("daemon", "Выполняет архивирование с интервалом из файла настроек (по умолчанию 10 минут)\n"+
+		"и отдает метрики на /metrics.", &opts)
+	opts.registerProfile(flags)
+	addr := flags.String("addr", metrics.DEFAULT_ADDR, "адрес HTTP-сервера метрик")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	interval, err := time.ParseDuration(firstNonEmpty(profile.Schedule.PollInterval, installer.DEFAULT_INTERVAL))
+	if err == nil {
+		_, err = installer.CronSchedule(interval)
+	}
+	if err != nil {
+		return opts.finish("daemon", nil, fmt.Errorf("некорректный интервал запусков: %v", err))
+	}
+
+	registry := metrics.NewRegistry()
+	if profile.Name != config.DEFAULT_PROFILE {
+		registry.SetConstLabel("profile", profile.Name)
+	}
+	arch := opts.newArchiver(cfg, profile)
+	defer arch.Close()
+	arch.SetMetrics(archiver.NewMetrics(registry))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+	server := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка HTTP-сервера метрик: %v\n", err)
+			os.Exit(EXIT_ERROR)
+		}
+	}()
+	fmt.Printf("📈 Метрики доступны по адресу http://%s/metrics\n", *addr)
+
+	// По SIGINT и SIGTERM текущий запуск останавливается на границе строки с сохранением позиции
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		stats, err := arch.Run(ctx)
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Ошибка архивирования: %v\n", err)
+		}
+		archives := sealedArchives(stats)
+		if err == nil {
+			compacted, err := compactAfterRun(ctx, arch, profile.Schedule, stats)
+			if err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "Ошибка уплотнения архивов: %v\n", err)
+			}
+			archives = append(archives, compactedArchives(compacted)...)
+		}
+		if ctx.Err() == nil {
+			shipArchives(ctx, os.Stdout, cfg, profile, archives...)
+		}
+
+		// Запуски выравниваем по границе интервала, как в cron, чтобы попадать на границы периодов
+		now := time.Now()
+		select {
+		case <-ctx.Done():
+			fmt.Println("Архиватор остановлен")
+			return EXIT_OK
+		case <-time.After(now.Truncate(interval).Add(interval).Sub(now)):
+		}
+	}
+}
+
+func runUpdate(args []string) int {
+	var opts options
+	var inst installerFlags
+	flags := newFlagSet("update", "Атомарно заменяет установленную программу новой версией.\n"+
+		"Предыдущая версия сохраняется рядом с суффиксом .prev. Если новая версия\n"+
+		"не проходит самопроверку (version), предыдущая восстанавливается автоматически.", &opts)
+	inst.register(flags, true)
+	source := flags.String("source", "", "новая программа: файл, директория с xui_log_archiver или file:///path")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	if *source == "" {
+		fmt.Fprintln(os.Stderr, "Укажите источник обновления: --source")
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	if code, ok := opts.confirm("update", fmt.Sprintf("Обновить %s из %s?", inst.binaryPath, *source), inst.yes); !ok {
+		return code
+	}
+
+	result, err := inst.newInstaller(&opts, config.Resolved{Name: config.DEFAULT_PROFILE, Paths: archiver.DefaultPaths()}).Update(*source)
+	if err != nil {
+		err = fmt.Errorf("ошибка обновления: %v", err)
+	}
+	return opts.finishChange("update", result, err)
+}
+
+func runRollback(args []string) int {
+	var opts options
+	var inst installerFlags
+	flags := newFlagSet("rollback", "Возвращает предыдущую версию программы, сохраненную при install или update.\n"+
+		"Текущая версия становится предыдущей, повторный rollback возвращает ее.", &opts)
+	inst.register(flags, true)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	if code, ok := opts.confirm("rollback", fmt.Sprintf("Вернуть предыдущую версию %s?", inst.binaryPath), inst.yes); !ok {
+		return code
+	}
+
+	result, err := inst.newInstaller(&opts, config.Resolved{Name: config.DEFAULT_PROFILE, Paths: archiver.DefaultPaths()}).Rollback()
+	if err != nil {
+		err = fmt.Errorf("ошибка отката: %v", err)
+	}
+	return opts.finishChange("rollback", result, err)
+}
+
+func runVersion(args []string) int {
+	var opts options
+	flags := newFlagSet("version", "Показывает версию, коммит и дату сборки программы.", &opts)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	info := version.Get()
+	if !opts.json {
+		fmt.Printf("%s %s\n", PROGRAM_NAME, info)
+	}
+	return opts.finish("version", info, nil)
+}
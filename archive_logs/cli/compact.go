@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/config"
+)
+
+func runCompact(args []string) int {
+	var opts options
+	flags := newFlagSet("compact", "Объединяет архивы каждого завершенного дня в один архив ARCHIVE_DIR/YYYY/MM/DD,\n"+
+		"с --monthly - архивы завершенного месяца в ARCHIVE_DIR/YYYY/MM. Исходные архивы удаляются\n"+
+		"после проверки числа строк. merge, дашборд и status понимают эту структуру.\n"+
+		"Автоматически после создания архивов: {\"schedule\": {\"compact\": \"daily\"}} или \"monthly\".", &opts)
+	opts.registerProfile(flags)
+	monthly := flags.Bool("monthly", false, "объединять и завершенные месяцы")
+	dryRun := flags.Bool("dry-run", false, "показать, какие архивы будут объединены, ничего не меняя")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	arch := opts.newArchiver(cfg, profile)
+	defer arch.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	monthlyConfigured := profile.Schedule.Compact == archiver.COMPACT_MONTHLY
+	stats, err := arch.Compact(ctx, archiver.CompactOptions{Monthly: *monthly || monthlyConfigured, DryRun: *dryRun})
+	if err == nil && !opts.json {
+		printCompact(os.Stdout, arch.Paths().ArchiveDir, stats, *dryRun)
+	}
+	if err == nil && !*dryRun {
+		shipArchives(ctx, opts.output(), cfg, profile, compactedArchives(stats)...)
+	}
+	return opts.finish("compact", stats, err)
+}
+
+// compactedArchives возвращает архивы, созданные уплотнением
+func compactedArchives(stats archiver.CompactStats) []string {
+	var archives []string
+	for _, archive := range stats.Archives {
+		archives = append(archives, archive.Archive)
+	}
+	return archives
+}
+
+// compactAfterRun уплотняет архивы после запуска, создавшего архив, если это включено в расписании
+func compactAfterRun(ctx context.Context, arch *archiver.Archiver, schedule config.Schedule, stats archiver.RunStats) (archiver.CompactStats, error) {
+	if schedule.Compact == "" || !stats.RolledOver {
+		return archiver.CompactStats{}, nil
+	}
+	if schedule.Compact != archiver.COMPACT_DAILY && schedule.Compact != archiver.COMPACT_MONTHLY {
+		return archiver.CompactStats{}, fmt.Errorf("некорректное значение schedule.compact %q: ожидается daily или monthly", schedule.Compact)
+	}
+	return arch.Compact(ctx, archiver.CompactOptions{Monthly: schedule.Compact == archiver.COMPACT_MONTHLY})
+}
+
+// printCompact выводит объединенные архивы таблицей
+func printCompact(w io.Writer, archiveDir string, stats archiver.CompactStats, dryRun bool) {
+	if len(stats.Archives) == 0 {
+		fmt.Fprintln(w, "Уплотнять нечего: архивы завершенных дней уже объединены")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	// Строки считаются при записи архива, в пробном запуске их число неизвестно
+	if dryRun {
+		fmt.Fprintln(tw, "Архив\tАрхивов")
+	} else {
+		fmt.Fprintln(tw, "Архив\tАрхивов\tСтрок")
+	}
+	for _, archive := range stats.Archives {
+		name, err := filepath.Rel(archiveDir, archive.Archive)
+		if err != nil {
+			name = archive.Archive
+		}
+		if dryRun {
+			fmt.Fprintf(tw, "%s\t%d\n", name, len(archive.Inputs))
+		} else {
+			fmt.Fprintf(tw, "%s\t%d\t%d\n", name, len(archive.Inputs), archive.Lines)
+		}
+	}
+	tw.Flush()
+
+	if dryRun {
+		fmt.Fprintln(w, "\nПробный запуск (--dry-run): архивы не изменены")
+		return
+	}
+	fmt.Fprintf(w, "\n🗜️  Создано архивов: %d из %d, строк: %d\n", len(stats.Archives), stats.Inputs, stats.Lines)
+}
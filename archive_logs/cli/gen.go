@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/generator"
+)
+
+func runGen(args []string) int {
+	var opts options
+	flags := newFlagSet("gen", "Создает правдоподобные строки access.log Xray (соединения и DNS) для нагрузочных\n"+
+		"тестов и демонстрации. Дописывает их в файл в реальном времени или создает архивы\n"+
+		"за прошедшие периоды (--backfill).", &opts)
+	out := flags.String("out", "", "файл, в который дописываются строки (по умолчанию stdout)")
+	rate := flags.Float64("rate", generator.DEFAULT_RATE, "средняя скорость, строк в секунду")
+	users := flags.Int("users", generator.DEFAULT_USERS, "число клиентов")
+	domainsFile := flags.String("domains", "", "файл со списком доменов от популярных к редким (по умолчанию встроенный)")
+	skew := flags.Float64("skew", generator.DEFAULT_SKEW, "показатель распределения Ципфа для доменов, больше 1")
+	dnsShare := flags.Float64("dns-share", generator.DEFAULT_DNS_SHARE, "доля строк DNS от 0 до 1")
+	seed := flags.Int64("seed", 0, "начальное значение случайных чисел для повторяемого результата")
+	limit := flags.Int("lines", 0, "остановиться после этого числа строк (0 - без ограничения)")
+	duration := flags.Duration("duration", 0, "остановиться через это время (0 - до Ctrl+C)")
+	backfill := flags.String("backfill", "", "создать архивы за этот срок до текущего момента: 24h, 7d")
+	archiveDir := flags.String("archive-dir", "", "директория для архивов --backfill")
+	by := flags.String("by", "hourly", "период архивов --backfill: hourly, daily или длительность вроде 15m")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg := generator.Config{Rate: *rate, Users: *users, Skew: *skew, DNSShare: *dnsShare, Seed: *seed}
+	if *domainsFile != "" {
+		domains, err := generator.LoadDomains(*domainsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return EXIT_USAGE
+		}
+		cfg.Domains = domains
+	}
+	gen, err := generator.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка: %v\n", err)
+		return EXIT_USAGE
+	}
+
+	if *backfill != "" {
+		return runGenArchives(&opts, gen, *backfill, *archiveDir, *by)
+	}
+
+	// В stdout может быть только один поток: строки лога или JSON с итогами
+	var w io.Writer = os.Stdout
+	if *out == "" && opts.json {
+		fmt.Fprintln(os.Stderr, "С --json укажите файл для строк: --out")
+		return EXIT_USAGE
+	}
+	if *out != "" {
+		file, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return opts.finish("gen", nil, fmt.Errorf("ошибка открытия %s: %v", *out, err))
+		}
+		defer file.Close()
+		w = file
+		if !opts.json {
+			fmt.Printf("✍️  Строки дописываются в %s со скоростью %g в секунду, остановка - Ctrl+C\n", *out, *rate)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	stats, err := gen.Live(ctx, w, *limit)
+	stats.File = *out
+	if err == nil && *out != "" && !opts.json {
+		fmt.Printf("✅ Записано строк: %d, из них DNS: %d\n", stats.Lines, stats.DNSLines)
+	}
+	return opts.finish("gen", stats, err)
+}
+
+// runGenArchives создает архивы за прошедшие периоды
+func runGenArchives(opts *options, gen *generator.Generator, since, dir, by string) int {
+	// Директорию указываем явно: тестовые архивы не должны попасть к настоящим
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "Укажите директорию для архивов: --archive-dir")
+		return EXIT_USAGE
+	}
+	window, err := parseSince(since)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	period, err := archiver.ParsePeriod(by)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+
+	// Имена как у архиватора с текущим файлом настроек
+	naming, err := opts.loadConfig().Naming.Parse()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+
+	now := time.Now()
+	stats, err := gen.Backfill(dir, naming, period, now.Add(-window), now)
+	if err == nil && !opts.json {
+		fmt.Printf("✅ Создано архивов: %d в %s, строк: %d, из них DNS: %d\n", len(stats.Archives), dir, stats.Lines, stats.DNSLines)
+	}
+	return opts.finish("gen", stats, err)
+}
@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"xui_log_archiver/archiver"
+)
+
+// CHART_WIDTH - ширина столбца графика в символах
+const CHART_WIDTH = 40
+
+// historyMetrics - величины, по которым строится график history
+var historyMetrics = map[string]func(b archiver.HistoryBucket) float64{
+	"bytes":    func(b archiver.HistoryBucket) float64 { return float64(b.Bytes) },
+	"lines":    func(b archiver.HistoryBucket) float64 { return float64(b.Lines) },
+	"runs":     func(b archiver.HistoryBucket) float64 { return float64(b.Runs) },
+	"errors":   func(b archiver.HistoryBucket) float64 { return float64(b.Errors) },
+	"duration": func(b archiver.HistoryBucket) float64 { return float64(b.MaxDurationMs) },
+}
+
+// historyReport - результат команды history
+type historyReport struct {
+	Since   time.Time                `json:"since"`
+	By      string                   `json:"by"`
+	Buckets []archiver.HistoryBucket `json:"buckets,omitempty"`
+	Runs    []archiver.HistoryRecord `json:"runs,omitempty"`
+}
+
+func runHistory(args []string) int {
+	var opts options
+	flags := newFlagSet("history", "Показывает журнал запусков архивирования: сколько строк и байт перенесено,\n"+
+		"сколько создано архивов и ошибок за каждый период, с графиком в терминале.", &opts)
+	opts.registerProfile(flags)
+	since := flags.String("since", "24h", "за какой срок показать историю: 90m, 24h, 7d")
+	by := flags.String("by", "hourly", "период группировки: hourly, daily или длительность вроде 15m")
+	metric := flags.String("metric", "bytes", "величина для графика: bytes, lines, runs, errors или duration")
+	raw := flags.Bool("runs", false, "показать отдельные запуски без группировки")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	window, err := parseSince(*since)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	period, err := archiver.ParsePeriod(*by)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	value, ok := historyMetrics[*metric]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Неизвестная величина графика %q\n", *metric)
+		return EXIT_USAGE
+	}
+
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	arch := opts.newArchiver(cfg, profile)
+	defer arch.Close()
+
+	now := time.Now()
+	report := historyReport{Since: now.Add(-window), By: period.String()}
+	records, err := arch.History(report.Since)
+	if err != nil {
+		return opts.finish("history", nil, err)
+	}
+
+	if *raw {
+		report.Runs = records
+		if !opts.json {
+			printRuns(os.Stdout, records)
+		}
+		return opts.finish("history", report, nil)
+	}
+
+	report.Buckets = archiver.AggregateHistory(records, period, report.Since, now)
+	if !opts.json {
+		printHistory(os.Stdout, report.Buckets, period, *metric, value)
+	}
+	return opts.finish("history", report, nil)
+}
+
+// parseSince разбирает срок истории. Кроме единиц time.ParseDuration понимает дни: 7d
+func parseSince(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("некорректный срок истории %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("некорректный срок истории %q", value)
+	}
+	return window, nil
+}
+
+// printHistory выводит итоги по периодам таблицей с графиком величины metric
+func printHistory(w io.Writer, buckets []archiver.HistoryBucket, period archiver.Period, metric string, value func(archiver.HistoryBucket) float64) {
+	layout := "2006-01-02 15:04"
+	if period.Duration() >= 24*time.Hour {
+		layout = "2006-01-02"
+	}
+
+	var max float64
+	var total archiver.HistoryBucket
+	for _, bucket := range buckets {
+		if v := value(bucket); v > max {
+			max = v
+		}
+		total.Runs += bucket.Runs
+		total.Errors += bucket.Errors
+		total.Lines += bucket.Lines
+		total.Bytes += bucket.Bytes
+		total.Archives += bucket.Archives
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Период\tЗапусков\tОшибок\tСтрок\tОбъем\tАрхивов\t%s\n", metric)
+	for _, bucket := range buckets {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%d\t%s\n", bucket.Start.Format(layout), bucket.Runs, bucket.Errors,
+			bucket.Lines, formatBytes(bucket.Bytes), bucket.Archives, bar(value(bucket), max, CHART_WIDTH))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nВсего: запусков %d, ошибок %d, строк %d, %s, архивов %d\n",
+		total.Runs, total.Errors, total.Lines, formatBytes(total.Bytes), total.Archives)
+}
+
+// printRuns выводит отдельные запуски
+func printRuns(w io.Writer, records []archiver.HistoryRecord) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Запуск\tДлительность\tСтрок\tОбъем\tАрхив\tОшибка")
+	for _, record := range records {
+		archive := record.Archive
+		if record.Parts > 0 {
+			archive = strings.TrimSpace(fmt.Sprintf("%s +%d ч.", archive, record.Parts))
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%d\t%s\t%s\t%s\n", record.Start.Local().Format("2006-01-02 15:04:05"),
+			time.Duration(record.DurationMs)*time.Millisecond, record.Lines, formatBytes(record.Bytes), archive, record.Error)
+	}
+	tw.Flush()
+}
+
+// barEighths - символы для дробной части столбца с шагом 1/8
+var barEighths = []string{"", "▏", "▎", "▍", "▌", "▋", "▊", "▉"}
+
+// bar рисует горизонтальный столбец длиной value/max от width символов
+func bar(value, max float64, width int) string {
+	if max <= 0 || value <= 0 {
+		return ""
+	}
+	eighths := int(value / max * float64(width*8))
+	if eighths == 0 {
+		eighths = 1
+	}
+	return strings.Repeat("█", eighths/8) + barEighths[eighths%8]
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+
+	"xui_log_archiver/archiver"
+)
+
+func runRename(args []string) int {
+	var opts options
+	flags := newFlagSet("rename", "Переименовывает существующие архивы по шаблону из файла настроек:\n"+
+		"{\"naming\": {\"template\": \"access_{node}_{time}.log\", \"zone\": \"utc\"}}. По умолчанию\n"+
+		"старыми считаются имена прежних версий (access_20261016_00.log в местном времени).\n"+
+		"Прерванное переименование можно повторить.", &opts)
+	opts.registerProfile(flags)
+	fromTemplate := flags.String("from-template", archiver.DEFAULT_NAMING, "шаблон, по которому названы существующие архивы")
+	fromZone := flags.String("from-zone", archiver.ZONE_LOCAL, "время в существующих именах: local, utc или offset")
+	fromNode := flags.String("from-node", "", "узел для {node} в --from-template, если он задан")
+	dryRun := flags.Bool("dry-run", false, "показать новые имена, ничего не меняя")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	from, err := archiver.ParseNaming(*fromTemplate, *fromNode, *fromZone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка: %v\n", err)
+		return EXIT_USAGE
+	}
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	// С ошибкой в шаблоне архиватор вернулся бы к именам по умолчанию: переименовывать по нему нельзя
+	if _, err := profile.Naming.Parse(); err != nil {
+		return opts.finish("rename", nil, err)
+	}
+	arch := opts.newArchiver(cfg, profile)
+	defer arch.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stats, err := arch.RenameArchives(ctx, archiver.RenameOptions{From: from, DryRun: *dryRun})
+	if err == nil && !opts.json {
+		printRename(os.Stdout, arch.Paths().ArchiveDir, stats, *dryRun)
+	}
+	return opts.finish("rename", stats, err)
+}
+
+// printRename выводит старые и новые имена архивов таблицей
+func printRename(w io.Writer, archiveDir string, stats archiver.RenameStats, dryRun bool) {
+	relative := func(path string) string {
+		if name, err := filepath.Rel(archiveDir, path); err == nil {
+			return name
+		}
+		return path
+	}
+
+	if len(stats.Renamed) == 0 {
+		fmt.Fprintf(w, "Переименовывать нечего: архивов по шаблону уже %d\n", stats.Current)
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Было\tСтало")
+		for _, archive := range stats.Renamed {
+			fmt.Fprintf(tw, "%s\t%s\n", relative(archive.From), relative(archive.To))
+		}
+		tw.Flush()
+	}
+	for _, path := range stats.Unknown {
+		fmt.Fprintf(w, "⚠️  Имя не подходит ни под один шаблон, архив не тронут: %s\n", relative(path))
+	}
+
+	if len(stats.Renamed) == 0 {
+		return
+	}
+	if dryRun {
+		fmt.Fprintln(w, "\nПробный запуск (--dry-run): архивы не изменены")
+		return
+	}
+	fmt.Fprintf(w, "\n✏️  Переименовано архивов: %d, уже по шаблону: %d\n", len(stats.Renamed), stats.Current)
+}
@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/config"
+	"xui_log_archiver/doctor"
+	"xui_log_archiver/installer"
+	"xui_log_archiver/shipper"
+	"xui_log_archiver/xrayconf"
+)
+
+// statusReport - результат команды status
+type statusReport struct {
+	Autostart installer.Status `json:"autostart"`
+	Logs      logsReport       `json:"logs"`
+	Health    archiver.Health  `json:"health"`
+	Upload    *uploadReport    `json:"upload,omitempty"`
+}
+
+// uploadReport - очередь отправки архивов во внешнее хранилище
+type uploadReport struct {
+	Target string `json:"target,omitempty"`
+	Queued int    `json:"queued"`
+	Bytes  int64  `json:"bytes"`
+	// Failing - архивы очереди, которые не удалось отправить
+	Failing   int    `json:"failing"`
+	LastError string `json:"last_error,omitempty"`
+	// Error - ошибка настроек хранилища или чтения очереди
+	Error string `json:"error,omitempty"`
+}
+
+// newUploadReport возвращает состояние очереди отправки или nil, если хранилище не задано
+func newUploadReport(ship *shipper.Shipper, err error) *uploadReport {
+	if err != nil {
+		return &uploadReport{Error: err.Error()}
+	}
+	if ship == nil {
+		return nil
+	}
+	report := &uploadReport{Target: ship.Target().String()}
+	queue, err := ship.Queue()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	for _, item := range queue {
+		report.Queued++
+		report.Bytes += item.Size
+		if item.Attempts > 0 {
+			report.Failing++
+			report.LastError = item.LastError
+		}
+	}
+	return report
+}
+
+// logsReport - куда Xray пишет логи по его конфигурации и какой access.log читает архиватор
+type logsReport struct {
+	AccessLog       string         `json:"access_log"`
+	AccessLogSource string         `json:"access_log_source"`
+	Xray            *xrayconf.Logs `json:"xray,omitempty"`
+	XrayError       string         `json:"xray_error,omitempty"`
+}
+
+func newLogsReport(profile config.Resolved) logsReport {
+	return logsReport{
+		AccessLog:       profile.Paths.LogFile,
+		AccessLogSource: profile.AccessLogSource,
+		Xray:            profile.Xray,
+		XrayError:       profile.XrayError,
+	}
+}
+
+// accessLogSources - пояснения к источнику пути access.log
+var accessLogSources = map[string]string{
+	config.SOURCE_CONFIG:  "из файла настроек",
+	config.SOURCE_XRAY:    "из конфигурации Xray",
+	config.SOURCE_DEFAULT: "по умолчанию",
+}
+
+// printLogs выводит строки таблицы о конфигурации Xray и ее предупреждения
+func printLogs(row func(name, value string), logs logsReport) {
+	switch {
+	case logs.Xray != nil:
+		row("🧾 Конфигурация Xray", logs.Xray.ConfigFile)
+		row("📝 Access лог Xray", logs.Xray.Access)
+		row("📝 Error лог Xray", fmt.Sprintf("%s (уровень %s)", logs.Xray.Error, logs.Xray.LogLevel))
+	case logs.XrayError != "":
+		row("❗ Конфигурация Xray", logs.XrayError)
+	default:
+		row("🧾 Конфигурация Xray", "не найдена")
+	}
+	row("📄 Читается access.log", fmt.Sprintf("%s (%s)", logs.AccessLog, accessLogSources[logs.AccessLogSource]))
+	if logs.Xray != nil {
+		for _, warning := range logs.Xray.Warnings {
+			row("❗ Предупреждение", warning)
+		}
+	}
+}
+
+// printStatus выводит состояние установки и архивирования таблицей
+func printStatus(w io.Writer, report statusReport, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	autostart, health := report.Autostart, report.Health
+	row := func(name, value string) { fmt.Fprintf(tw, "%s\t%s\n", name, value) }
+
+	if autostart.Installed {
+		row("✅ Автозапуск", autostart.CronEntry)
+	} else {
+		row("❌ Автозапуск", "не настроен")
+	}
+	row("📦 Программа", presence(autostart.BinaryPath, autostart.BinaryExists))
+	row("📄 Файл позиции", presence(autostart.PositionFile, autostart.PositionFileExists))
+	fmt.Fprintln(tw, "\t")
+
+	printLogs(row, report.Logs)
+	fmt.Fprintln(tw, "\t")
+
+	row("🕐 Последний запуск", formatMoment(health.LastRun, now))
+	row("✅ Последний успех", formatMoment(health.LastSuccess, now))
+	if health.LastError != "" && (health.LastSuccess == nil || !health.LastErrorTime.Before(*health.LastSuccess)) {
+		row("❌ Последняя ошибка", fmt.Sprintf("%s: %s", formatMoment(health.LastErrorTime, now), health.LastError))
+	} else {
+		row("❌ Последняя ошибка", "нет")
+	}
+	if health.DiskEmergency != nil {
+		row("🚨 Аварийный режим", fmt.Sprintf("мало места с %s, access.log не читается", formatMoment(health.DiskEmergency, now)))
+	}
+	row("📥 Не обработано в access.log", formatBytes(health.LagBytes))
+
+	pending := formatBytes(health.PendingBytes)
+	if health.PendingAge != "" {
+		pending += fmt.Sprintf(", первая запись %s назад", health.PendingAge)
+	}
+	row("🧺 Накопитель", pending)
+
+	if health.NewestArchive != "" {
+		row("📚 Последний архив", fmt.Sprintf("%s (%s)", health.NewestArchive, formatMoment(health.NewestArchiveAt, now)))
+	} else {
+		row("📚 Последний архив", "нет")
+	}
+	row("⏰ Следующий архив", fmt.Sprintf("%s (через %s): %s", health.NextRollover.Local().Format("2006-01-02 15:04"),
+		health.NextRollover.Sub(now).Truncate(time.Minute), health.NextArchive))
+	row("💾 Архивы", fmt.Sprintf("%d шт., %s", health.ArchiveCount, formatBytes(health.ArchiveDirBytes)))
+
+	switch upload := report.Upload; {
+	case upload == nil:
+	case upload.Error != "":
+		row("❌ Отправка архивов", upload.Error)
+	default:
+		row("📤 Отправка архивов", fmt.Sprintf("%s, в очереди %d шт., %s", upload.Target, upload.Queued, formatBytes(upload.Bytes)))
+		if upload.Failing > 0 {
+			row("❌ Ошибка отправки", fmt.Sprintf("не отправлено %d шт.: %s", upload.Failing, upload.LastError))
+		}
+	}
+}
+
+// doctorMarks - значки результатов проверок doctor
+var doctorMarks = map[string]string{
+	doctor.PASS: "✅",
+	doctor.WARN: "❗",
+	doctor.FAIL: "❌",
+}
+
+// printDoctor выводит результаты проверок doctor с исправлениями
+func printDoctor(w io.Writer, report doctor.Report) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, check := range report.Checks {
+		fmt.Fprintf(tw, "%s %s\t%s\n", doctorMarks[check.Status], check.Name, check.Message)
+		if check.Fix != "" {
+			fmt.Fprintf(tw, "\t💡 %s\n", check.Fix)
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nПрофиль %s: проверок %d, предупреждений %d, ошибок %d\n",
+		report.Profile, len(report.Checks), report.Warned, report.Failed)
+}
+
+func presence(path string, exists bool) string {
+	if exists {
+		return path
+	}
+	return path + " (не существует)"
+}
+
+// formatMoment выводит время и сколько прошло с него
+func formatMoment(t *time.Time, now time.Time) string {
+	if t == nil {
+		return "никогда"
+	}
+	return fmt.Sprintf("%s, %s назад", t.Format("2006-01-02 15:04:05"), now.Sub(*t).Truncate(time.Second))
+}
+
+// formatBytes выводит размер в байтах, КБ, МБ или ГБ
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d Б", n)
+	}
+	value, suffix := float64(n)/unit, "КБ"
+	for _, next := range []string{"МБ", "ГБ", "ТБ"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package cli
+
+import "os"
+
+// isTerminal проверяет, что файл похож на терминал
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
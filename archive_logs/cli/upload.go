@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+
+	"xui_log_archiver/config"
+	"xui_log_archiver/shipper"
+)
+
+func runUpload(args []string) int {
+	var opts options
+	flags := newFlagSet("upload", "Отправляет архивы из очереди во внешнее хранилище из файла настроек:\n"+
+		"{\"upload\": {\"s3\": {\"endpoint\": \"http://127.0.0.1:9000\", \"bucket\": \"logs\"}}}\n"+
+		"или коллектор (команда collect): {\"upload\": {\"collector\": {\"url\": \"https://logs:8443\", ...}}}.\n"+
+		"Новые архивы попадают в очередь сами после archive и compact, с --all - все архивы профиля.\n"+
+		"Архивы, которые не удалось отправить раньше, отправляются сразу, без ожидания следующей попытки.\n"+
+		"Завершается с кодом 1, если хотя бы один архив не отправлен.", &opts)
+	opts.registerProfile(flags)
+	all := flags.Bool("all", false, "поставить в очередь все архивы профиля, например после backfill или rename")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg, profile, ok := opts.loadProfile()
+	if !ok {
+		return EXIT_USAGE
+	}
+	ship, err := newShipper(cfg, profile)
+	if err == nil && ship == nil {
+		err = fmt.Errorf("хранилище для архивов не задано в файле настроек (upload.s3 или upload.collector)")
+	}
+	if err != nil {
+		return opts.finish("upload", nil, err)
+	}
+	if *all {
+		count, err := ship.EnqueueAll()
+		if err != nil {
+			return opts.finish("upload", nil, err)
+		}
+		fmt.Fprintf(opts.output(), "Архивов профиля: %d, новые поставлены в очередь\n", count)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stats, err := ship.Ship(ctx, shipper.ShipOptions{Force: true})
+	if err == nil && !opts.json {
+		printUpload(os.Stdout, profile.Paths.ArchiveDir, stats)
+	}
+	if err == nil && stats.Failed() > 0 {
+		err = fmt.Errorf("не отправлено архивов: %d", stats.Failed())
+	}
+	return opts.finish("upload", stats, err)
+}
+
+// newShipper создает отправитель архивов профиля. Если хранилище не задано, возвращает nil
+func newShipper(cfg *config.Config, profile config.Resolved) (*shipper.Shipper, error) {
+	target, err := cfg.Upload.Target()
+	if err != nil || target == nil {
+		return nil, err
+	}
+	// Архивы профилей в одном хранилище не должны пересекаться
+	var prefix string
+	if profile.Name != config.DEFAULT_PROFILE {
+		prefix = profile.Name + "/"
+	}
+	return shipper.New(shipper.Options{
+		Target:      target,
+		OutboxDir:   profile.Paths.OutboxDir,
+		ArchiveDir:  profile.Paths.ArchiveDir,
+		KeyPrefix:   prefix,
+		DeleteLocal: cfg.Upload.DeleteLocal,
+	}), nil
+}
+
+// shipArchives ставит новые архивы в очередь и отправляет ее, если хранилище задано в настройках.
+// Неотправленные архивы остаются в очереди до следующего запуска, поэтому ошибки отправки
+// только выводятся и не делают неудачным сам запуск
+func shipArchives(ctx context.Context, out io.Writer, cfg *config.Config, profile config.Resolved, archives ...string) {
+	ship, err := newShipper(cfg, profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Предупреждение: %v, архивы не отправляются\n", err)
+		return
+	}
+	if ship == nil {
+		return
+	}
+	if err := ship.Enqueue(archives...); err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка очереди отправки: %v\n", err)
+	}
+
+	stats, err := ship.Ship(ctx, shipper.ShipOptions{})
+	if errors.Is(err, shipper.ErrLocked) {
+		fmt.Fprintf(out, "⏳ %v, архивы отправит он\n", err)
+		return
+	}
+	for _, result := range stats.Results {
+		switch result.Status {
+		case shipper.STATUS_UPLOADED, shipper.STATUS_EXISTS:
+			fmt.Fprintf(out, "☁️  Архив отправлен в %s%s%s\n", stats.Target, result.Key, deletedNote(result))
+		case shipper.STATUS_FAILED:
+			fmt.Fprintf(os.Stderr, "Ошибка отправки архива %s (попытка %d, следующая после %s): %s\n",
+				result.Path, result.Attempts, result.NextAttempt.Format("15:04"), result.Error)
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "Ошибка отправки архивов: %v\n", err)
+	}
+}
+
+func deletedNote(result shipper.Result) string {
+	if result.Deleted {
+		return ", локальная копия удалена"
+	}
+	return ""
+}
+
+// uploadStatuses - итоги отправки архива для таблицы
+var uploadStatuses = map[string]string{
+	shipper.STATUS_UPLOADED: "отправлен",
+	shipper.STATUS_EXISTS:   "уже в хранилище",
+	shipper.STATUS_FAILED:   "не отправлен",
+	shipper.STATUS_MISSING:  "нет на диске, убран из очереди",
+}
+
+// printUpload выводит итоги отправки очереди таблицей
+func printUpload(w io.Writer, archiveDir string, stats shipper.Stats) {
+	if len(stats.Results) == 0 {
+		fmt.Fprintf(w, "Очередь отправки в %s пуста\n", stats.Target)
+		return
+	}
+	sent := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Архив\tРазмер\tИтог")
+	for _, result := range stats.Results {
+		if result.Status == shipper.STATUS_UPLOADED || result.Status == shipper.STATUS_EXISTS {
+			sent++
+		}
+		name, err := filepath.Rel(archiveDir, result.Path)
+		if err != nil {
+			name = result.Path
+		}
+		status := uploadStatuses[result.Status] + deletedNote(result)
+		if result.Error != "" {
+			status += ": " + result.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, formatBytes(result.Size), status)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n☁️  Отправлено в %s: %d из %d, %s\n", stats.Target, sent, len(stats.Results), formatBytes(stats.Bytes))
+}
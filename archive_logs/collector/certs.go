@@ -0,0 +1,158 @@
+package collector
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// CA_VALIDITY - срок действия сертификата CA коллектора
+	CA_VALIDITY = 10 * 365 * 24 * time.Hour
+	// CERT_VALIDITY - срок действия сертификатов сервера и узлов
+	CERT_VALIDITY = 5 * 365 * 24 * time.Hour
+)
+
+// Certs - сертификаты коллектора в одной директории: ca.pem и ca.key, server.pem и server.key,
+// а сертификаты узлов - в nodes/<узел>.pem и nodes/<узел>.key
+type Certs struct {
+	Dir string
+}
+
+// CA возвращает сертификат CA, которым подписаны сертификаты сервера и узлов
+func (c Certs) CA() string { return filepath.Join(c.Dir, "ca.pem") }
+
+// ServerCert и ServerKey возвращают сертификат и ключ сервера
+func (c Certs) ServerCert() string { return filepath.Join(c.Dir, "server.pem") }
+func (c Certs) ServerKey() string  { return filepath.Join(c.Dir, "server.key") }
+
+// NodeCert и NodeKey возвращают сертификат и ключ узла
+func (c Certs) NodeCert(node string) string { return filepath.Join(c.Dir, "nodes", node+".pem") }
+func (c Certs) NodeKey(node string) string  { return filepath.Join(c.Dir, "nodes", node+".key") }
+
+// Init создает CA и сертификат сервера для имен hosts (DNS-имена или IP-адреса), если их еще нет.
+// Возвращает созданные файлы
+func (c Certs) Init(hosts []string) ([]string, error) {
+	var created []string
+	if !exists(c.CA()) {
+		template := &x509.Certificate{
+			Subject:               pkix.Name{CommonName: "xui_log_archiver collector CA"},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		}
+		if err := c.issue(template, CA_VALIDITY, c.CA(), filepath.Join(c.Dir, "ca.key"), nil); err != nil {
+			return created, err
+		}
+		created = append(created, c.CA())
+	}
+	if !exists(c.ServerCert()) {
+		if len(hosts) == 0 {
+			return created, fmt.Errorf("для сертификата сервера нужно имя хоста или IP-адрес коллектора")
+		}
+		template := &x509.Certificate{
+			Subject:     pkix.Name{CommonName: hosts[0]},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		for _, host := range hosts {
+			if ip := net.ParseIP(host); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, host)
+			}
+		}
+		if err := c.issueSigned(template, c.ServerCert(), c.ServerKey()); err != nil {
+			return created, err
+		}
+		created = append(created, c.ServerCert())
+	}
+	return created, nil
+}
+
+// IssueNode выпускает сертификат узла: имя узла записывается в CN, по нему коллектор
+// раскладывает архивы. Существующий сертификат узла не перезаписывается
+func (c Certs) IssueNode(node string) error {
+	if !validSegment.MatchString(node) {
+		return fmt.Errorf("имя узла %q: допустимы латинские буквы, цифры, _, -, . и +", node)
+	}
+	if exists(c.NodeCert(node)) {
+		return fmt.Errorf("сертификат узла %s уже есть: %s", node, c.NodeCert(node))
+	}
+	if err := os.MkdirAll(filepath.Join(c.Dir, "nodes"), 0700); err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: node},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return c.issueSigned(template, c.NodeCert(node), c.NodeKey(node))
+}
+
+// issueSigned выпускает сертификат, подписанный CA коллектора
+func (c Certs) issueSigned(template *x509.Certificate, certFile, keyFile string) error {
+	ca, err := tls.LoadX509KeyPair(c.CA(), filepath.Join(c.Dir, "ca.key"))
+	if err != nil {
+		return fmt.Errorf("ошибка чтения CA коллектора: %v", err)
+	}
+	if ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+		return fmt.Errorf("ошибка чтения CA коллектора: %v", err)
+	}
+	return c.issue(template, CERT_VALIDITY, certFile, keyFile, &ca)
+}
+
+// issue создает ключ и сертификат по шаблону. Без parent сертификат самоподписанный
+func (c Certs) issue(template *x509.Certificate, validity time.Duration, certFile, keyFile string, parent *tls.Certificate) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(validity)
+
+	issuer, signer := template, interface{}(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		return fmt.Errorf("ошибка создания сертификата %s: %v", template.Subject.CommonName, err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0755); err != nil {
+		return err
+	}
+	// Ключ пишется первым: сертификат без ключа считался бы выпущенным
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("ошибка записи ключа %s: %v", keyFile, err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("ошибка записи сертификата %s: %v", certFile, err)
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,378 @@
+// Package collector принимает архивы от многих узлов по HTTPS с взаимной проверкой сертификатов.
+// Узел определяется по CN сертификата клиента, архивы хранятся по узлам, а одинаковые архивы
+// хранятся один раз: файлы узлов - жесткие ссылки на файл с именем по SHA-256
+package collector
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DEFAULT_ADDR - адрес, на котором коллектор слушает по умолчанию
+	DEFAULT_ADDR = ":8443"
+	// DEFAULT_DIR - директория архивов коллектора по умолчанию
+	DEFAULT_DIR = "/var/lib/xui-collector"
+	// API_PATH - путь к архивам: /v1/archives/<ключ>
+	API_PATH = "/v1/archives/"
+	// MAX_CHUNK - самый большой кусок архива в одном запросе
+	MAX_CHUNK = 64 << 20
+)
+
+// Заголовки протокола отправки
+const (
+	// HEADER_SHA256 - SHA-256 всего архива: в запросах узла и в ответах коллектора
+	HEADER_SHA256 = "X-Archive-Sha256"
+	// HEADER_OFFSET - сколько байт архива коллектор уже принял
+	HEADER_OFFSET = "X-Upload-Offset"
+	// HEADER_REPLACES - SHA-256 прежних версий архива, которые узел разрешает перезаписать
+	HEADER_REPLACES = "X-Replaces-Sha256"
+	// HEADER_DEDUPLICATED - такой архив уже был у коллектора, данные не передавались
+	HEADER_DEDUPLICATED = "X-Deduplicated"
+)
+
+// Служебные директории внутри директории коллектора. Имена узлов не начинаются с точки
+const (
+	OBJECTS_DIR  = ".objects"
+	INCOMING_DIR = ".incoming"
+)
+
+var (
+	validSegment = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._+-]*$`)
+	validSHA256  = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	contentRange = regexp.MustCompile(`^bytes (?:(\d+)-(\d+)|\*)/(\d+)$`)
+)
+
+// Server принимает архивы узлов.
+//
+// Протокол: PUT /v1/archives/<ключ> с заголовками X-Archive-Sha256 и Content-Range.
+// Пустой запрос с Content-Range: bytes */<размер> узнает, сколько байт уже принято:
+// 202 и X-Upload-Offset - нужно отправить остальное, 200 - архив уже есть, 201 - архив
+// с той же SHA-256 уже был у коллектора и сохранен без передачи данных. Куски отправляются
+// по порядку с Content-Range: bytes <начало>-<конец>/<размер>, на последний коллектор отвечает
+// 201 после проверки SHA-256. Кусок не с того места - 416 с X-Upload-Offset. Архив с тем же ключом
+// и другой SHA-256 не перезаписывается (409), если его сумма не передана в X-Replaces-Sha256.
+// HEAD /v1/archives/<ключ> возвращает размер и SHA-256 сохраненного архива
+type Server struct {
+	dir   string
+	log   *slog.Logger
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// New создает коллектор, который хранит архивы в dir
+func New(dir string, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Server{dir: dir, log: logger, locks: map[string]*sync.Mutex{}}
+}
+
+// TLSConfig возвращает настройки TLS сервера: сертификат сервера и обязательный сертификат
+// клиента, подписанный clientCA
+func TLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения сертификата сервера: %v", err)
+	}
+	pool, err := loadPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func loadPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения сертификата CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("в %s нет сертификатов PEM", caFile)
+	}
+	return pool, nil
+}
+
+// ListenAndServeTLS запускает HTTPS-сервер коллектора
+func (s *Server) ListenAndServeTLS(addr string, tlsConfig *tls.Config) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// ServeHTTP реализует http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	node, ok := clientNode(r)
+	if !ok {
+		http.Error(w, "нужен сертификат клиента с именем узла в CN", http.StatusUnauthorized)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, API_PATH)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !validKey(key) {
+		http.Error(w, "некорректный ключ архива", http.StatusBadRequest)
+		return
+	}
+	path := filepath.Join(s.dir, node, filepath.FromSlash(key))
+
+	switch r.Method {
+	case http.MethodHead:
+		s.handleHead(w, path)
+	case http.MethodPut:
+		s.handlePut(w, r, node, key, path)
+	default:
+		w.Header().Set("Allow", "HEAD, PUT")
+		http.Error(w, "метод не поддерживается", http.StatusMethodNotAllowed)
+	}
+}
+
+// clientNode возвращает имя узла из CN проверенного сертификата клиента
+func clientNode(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+	node := r.TLS.PeerCertificates[0].Subject.CommonName
+	return node, validSegment.MatchString(node)
+}
+
+// validKey проверяет, что ключ - относительный путь без . и .. , который не выходит
+// из директории узла
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if !validSegment.MatchString(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) handleHead(w http.ResponseWriter, path string) {
+	size, sum, err := checksum(path)
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log.Error("Ошибка чтения архива", "file", path, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(HEADER_SHA256, sum)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request, node, key, path string) {
+	sum := r.Header.Get(HEADER_SHA256)
+	if !validSHA256.MatchString(sum) {
+		http.Error(w, "нужен заголовок "+HEADER_SHA256, http.StatusBadRequest)
+		return
+	}
+	match := contentRange.FindStringSubmatch(r.Header.Get("Content-Range"))
+	if match == nil {
+		http.Error(w, "нужен заголовок Content-Range: bytes <начало>-<конец>/<размер>", http.StatusBadRequest)
+		return
+	}
+	total, _ := strconv.ParseInt(match[3], 10, 64)
+
+	// Запросы одного узла выполняются по очереди: узел и так отправляет архивы по одному
+	unlock := s.lock(node)
+	defer unlock()
+	incoming := filepath.Join(s.dir, INCOMING_DIR, node, sum+".part")
+
+	if _, existing, err := checksum(path); err == nil && !slices.Contains(r.Header.Values(HEADER_REPLACES), existing) {
+		if existing != sum {
+			http.Error(w, fmt.Sprintf("у узла уже есть другой архив %s (sha256 %s)", key, existing), http.StatusConflict)
+			return
+		}
+		w.Header().Set(HEADER_SHA256, existing)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Такой же архив уже есть у коллектора: от этого или другого узла
+	object := s.objectPath(sum)
+	if _, err := os.Stat(object); err == nil {
+		if err := s.link(object, path); err != nil {
+			s.fail(w, "Ошибка сохранения архива", path, err)
+			return
+		}
+		os.Remove(incoming)
+		s.log.Info("Архив сохранен без передачи: такой уже есть", "node", node, "key", key, "sha256", sum)
+		w.Header().Set(HEADER_SHA256, sum)
+		w.Header().Set(HEADER_DEDUPLICATED, "true")
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	// Пустой архив принимается сразу по первому запросу: передавать нечего
+	if total == 0 {
+		if err := os.MkdirAll(filepath.Dir(incoming), 0755); err != nil {
+			s.fail(w, "Ошибка записи архива", incoming, err)
+			return
+		}
+		if err := os.WriteFile(incoming, nil, 0644); err != nil {
+			s.fail(w, "Ошибка записи архива", incoming, err)
+			return
+		}
+		s.complete(w, node, key, path, incoming, sum, total)
+		return
+	}
+
+	var offset int64
+	if info, err := os.Stat(incoming); err == nil {
+		offset = info.Size()
+	}
+	w.Header().Set(HEADER_OFFSET, strconv.FormatInt(offset, 10))
+	if match[1] == "" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	start, _ := strconv.ParseInt(match[1], 10, 64)
+	end, _ := strconv.ParseInt(match[2], 10, 64)
+	if start != offset || end < start || end >= total || end-start+1 > MAX_CHUNK || r.ContentLength != end-start+1 {
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(incoming), 0755); err != nil {
+		s.fail(w, "Ошибка записи архива", incoming, err)
+		return
+	}
+	file, err := os.OpenFile(incoming, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.fail(w, "Ошибка записи архива", incoming, err)
+		return
+	}
+	written, err := io.Copy(file, io.LimitReader(r.Body, end-start+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || written != end-start+1 {
+		// Недописанный кусок отрезается, чтобы узел повторил его с того же места
+		os.Truncate(incoming, offset)
+		w.Header().Set(HEADER_OFFSET, strconv.FormatInt(offset, 10))
+		http.Error(w, fmt.Sprintf("кусок принят не полностью: %v", err), http.StatusBadRequest)
+		return
+	}
+	offset += written
+	w.Header().Set(HEADER_OFFSET, strconv.FormatInt(offset, 10))
+	if offset < total {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	s.complete(w, node, key, path, incoming, sum, total)
+}
+
+// complete проверяет SHA-256 принятого архива и сохраняет его: переносит в .objects и создает
+// ссылку в директории узла
+func (s *Server) complete(w http.ResponseWriter, node, key, path, incoming, sum string, total int64) {
+	object := s.objectPath(sum)
+	if _, received, err := checksum(incoming); err != nil || received != sum {
+		os.Remove(incoming)
+		s.log.Warn("Контрольная сумма архива не совпала", "node", node, "key", key, "sha256", sum, "received", received)
+		http.Error(w, fmt.Sprintf("sha256 принятого архива %s, а не %s: архив нужно отправить заново", received, sum),
+			http.StatusUnprocessableEntity)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+		s.fail(w, "Ошибка сохранения архива", object, err)
+		return
+	}
+	if err := os.Rename(incoming, object); err != nil {
+		s.fail(w, "Ошибка сохранения архива", object, err)
+		return
+	}
+	if err := s.link(object, path); err != nil {
+		s.fail(w, "Ошибка сохранения архива", path, err)
+		return
+	}
+	s.log.Info("Архив принят", "node", node, "key", key, "size", total, "sha256", sum)
+	w.Header().Set(HEADER_SHA256, sum)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// objectPath возвращает файл архива с SHA-256 sum: .objects/ab/abcdef...
+func (s *Server) objectPath(sum string) string {
+	return filepath.Join(s.dir, OBJECTS_DIR, sum[:2], sum)
+}
+
+// link сохраняет архив узла жесткой ссылкой на object. Ссылка создается под временным
+// именем и переименовывается, чтобы архив узла появлялся только целиком
+func (s *Server) link(object, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := os.Link(object, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// lock захватывает блокировку узла
+func (s *Server) lock(node string) func() {
+	s.mu.Lock()
+	lock, ok := s.locks[node]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[node] = lock
+	}
+	s.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (s *Server) fail(w http.ResponseWriter, message, path string, err error) {
+	s.log.Error(message, "file", path, "error", err)
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+func checksum(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,178 @@
+package collector_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"xui_log_archiver/collector"
+	"xui_log_archiver/shipper"
+)
+
+// testCollector - коллектор на httptest-сервере с сертификатами из временной директории
+type testCollector struct {
+	*httptest.Server
+	certs collector.Certs
+	dir   string
+
+	mu       sync.Mutex
+	received int64
+	failAt   func(contentRange string) bool
+}
+
+func newTestCollector(t *testing.T) *testCollector {
+	c := &testCollector{certs: collector.Certs{Dir: filepath.Join(t.TempDir(), "tls")}, dir: t.TempDir()}
+	if _, err := c.certs.Init([]string{"127.0.0.1", "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := collector.TLSConfig(c.certs.ServerCert(), c.certs.ServerKey(), c.certs.CA())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := collector.New(c.dir, nil)
+	c.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		if c.failAt != nil && c.failAt(r.Header.Get("Content-Range")) {
+			c.mu.Unlock()
+			http.Error(w, "сбой сети", http.StatusBadGateway)
+			return
+		}
+		if r.Method == http.MethodPut {
+			c.received += r.ContentLength
+		}
+		c.mu.Unlock()
+		server.ServeHTTP(w, r)
+	}))
+	c.TLS = tlsConfig
+	c.StartTLS()
+	t.Cleanup(c.Close)
+	return c
+}
+
+// newShipper создает отправитель узла node с архивами в archiveDir
+func (c *testCollector) newShipper(t *testing.T, node, archiveDir string, chunkSize int64) *shipper.Shipper {
+	if _, err := os.Stat(c.certs.NodeCert(node)); err != nil {
+		if err := c.certs.IssueNode(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target, err := shipper.NewCollector(shipper.CollectorConfig{URL: c.URL, CA: c.certs.CA(),
+		Cert: c.certs.NodeCert(node), Key: c.certs.NodeKey(node), ChunkSize: chunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return shipper.New(shipper.Options{Target: target, OutboxDir: filepath.Join(archiveDir, ".outbox"),
+		ArchiveDir: archiveDir, Retries: 1, Backoff: time.Millisecond})
+}
+
+func writeArchive(t *testing.T, archiveDir, name string, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := filepath.Join(archiveDir, filepath.FromSlash(name))
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUploadResumesAfterFailedChunk(t *testing.T) {
+	c := newTestCollector(t)
+	archiveDir := t.TempDir()
+	data := writeArchive(t, archiveDir, "2026/10/24/access_20261024.log.gz", 3<<20+1000)
+	ship := c.newShipper(t, "node1", archiveDir, 1<<20)
+
+	// Третий кусок теряется: на коллекторе остаются первые два
+	c.failAt = func(contentRange string) bool { return strings.HasPrefix(contentRange, "bytes 2097152-") }
+	if _, err := ship.EnqueueAll(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ship.Ship(context.Background(), shipper.ShipOptions{})
+	if err != nil || stats.Failed() != 1 {
+		t.Fatalf("итоги %+v, %v", stats, err)
+	}
+	items, _ := ship.Queue()
+	if len(items) != 1 || items[0].Offset != 2<<20 {
+		t.Fatalf("очередь %+v", items)
+	}
+
+	c.failAt, c.received = nil, 0
+	stats, err = ship.Ship(context.Background(), shipper.ShipOptions{Force: true})
+	if err != nil || len(stats.Results) != 1 || stats.Results[0].Status != shipper.STATUS_UPLOADED {
+		t.Fatalf("итоги %+v, %v", stats, err)
+	}
+	if c.received != int64(len(data))-2<<20 {
+		t.Errorf("передано %d байт, ожидалось %d: принятые куски не должны отправляться повторно", c.received, len(data)-2<<20)
+	}
+	stored, err := os.ReadFile(filepath.Join(c.dir, "node1", "2026", "10", "24", "access_20261024.log.gz"))
+	if err != nil || !bytes.Equal(stored, data) {
+		t.Errorf("архив на коллекторе не совпадает с отправленным: %v", err)
+	}
+}
+
+func TestSameArchiveFromTwoNodesStoredOnce(t *testing.T) {
+	c := newTestCollector(t)
+	const name = "access_20261025_00.log.gz"
+	for _, node := range []string{"node1", "node2"} {
+		archiveDir := t.TempDir()
+		writeArchive(t, archiveDir, name, 100<<10)
+		ship := c.newShipper(t, node, archiveDir, 0)
+		ship.EnqueueAll()
+
+		c.received = 0
+		stats, err := ship.Ship(context.Background(), shipper.ShipOptions{})
+		if err != nil || len(stats.Results) != 1 || stats.Results[0].Status != shipper.STATUS_UPLOADED {
+			t.Fatalf("%s: итоги %+v, %v", node, stats, err)
+		}
+		if node == "node2" && c.received != 0 {
+			t.Errorf("второй узел передал %d байт вместо ссылки на уже принятый архив", c.received)
+		}
+	}
+
+	first, err1 := os.Stat(filepath.Join(c.dir, "node1", name))
+	second, err2 := os.Stat(filepath.Join(c.dir, "node2", name))
+	if err1 != nil || err2 != nil || !os.SameFile(first, second) {
+		t.Errorf("архивы узлов не один файл: %v, %v", err1, err2)
+	}
+}
+
+func TestEmptyArchiveUploaded(t *testing.T) {
+	c := newTestCollector(t)
+	archiveDir := t.TempDir()
+	writeArchive(t, archiveDir, "access_20261025_00.log.gz", 0)
+	ship := c.newShipper(t, "node1", archiveDir, 0)
+	ship.EnqueueAll()
+
+	stats, err := ship.Ship(context.Background(), shipper.ShipOptions{})
+	if err != nil || len(stats.Results) != 1 || stats.Results[0].Status != shipper.STATUS_UPLOADED {
+		t.Fatalf("итоги %+v, %v", stats, err)
+	}
+	info, err := os.Stat(filepath.Join(c.dir, "node1", "access_20261025_00.log.gz"))
+	if err != nil || info.Size() != 0 {
+		t.Errorf("пустой архив не сохранен: %v", err)
+	}
+}
+
+func TestClientWithoutCertificateRejected(t *testing.T) {
+	c := newTestCollector(t)
+	data, _ := os.ReadFile(c.certs.CA())
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(data)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	resp, err := client.Head(c.URL + collector.API_PATH + "access_20261025_00.log.gz")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("запрос без сертификата узла принят: %s", resp.Status)
+	}
+}
@@ -0,0 +1,409 @@
+// Package config загружает настройки архиватора из JSON-файла
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/logging"
+	"xui_log_archiver/shipper"
+	"xui_log_archiver/xrayconf"
+)
+
+const (
+	// DEFAULT_CONFIG_FILE - файл настроек по умолчанию
+	DEFAULT_CONFIG_FILE = "/usr/local/x-ui/xui_log_archiver.json"
+	// CONFIG_ENV - переменная окружения с альтернативным путем к файлу настроек
+	CONFIG_ENV = "XUI_ARCHIVER_CONFIG"
+	// DEFAULT_PROFILE - профиль экземпляра x-ui в /usr/local/x-ui, он не требует описания в файле
+	DEFAULT_PROFILE = "default"
+)
+
+// Config - настройки архиватора. Все поля необязательные
+type Config struct {
+	Logging  logging.Config `json:"logging"`
+	Schedule Schedule       `json:"schedule"`
+	Disk     Disk           `json:"disk"`
+	Naming   Naming         `json:"naming"`
+	Upload   Upload         `json:"upload"`
+	// Profiles - экземпляры x-ui на одном сервере, у каждого свои лог, архивы и состояние
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+}
+
+// Profile описывает экземпляр x-ui. Пути по умолчанию строятся от Dir, как для /usr/local/x-ui
+type Profile struct {
+	// Dir - директория экземпляра x-ui, например /opt/x-ui-2 или том Docker
+	Dir string `json:"dir,omitempty"`
+	// AccessLog - лог Xray. По умолчанию берется из конфигурации Xray, иначе Dir/access.log
+	AccessLog string `json:"access_log,omitempty"`
+	// XrayConfig - конфигурация Xray, если она лежит не в Dir/bin/config.json
+	XrayConfig string `json:"xray_config,omitempty"`
+	// ArchiveDir - директория архивов, если не Dir/archives
+	ArchiveDir string `json:"archive_dir,omitempty"`
+	// Schedule переопределяет общие настройки расписания для профиля
+	Schedule Schedule `json:"schedule"`
+	// Naming переопределяет общий шаблон имени архивов, например узел для {node}
+	Naming Naming `json:"naming"`
+}
+
+// Источники пути access.log в Resolved.AccessLogSource
+const (
+	SOURCE_CONFIG  = "config"
+	SOURCE_XRAY    = "xray"
+	SOURCE_DEFAULT = "default"
+)
+
+// Resolved - профиль с итоговыми путями и расписанием
+type Resolved struct {
+	Name     string         `json:"name"`
+	Dir      string         `json:"dir"`
+	Paths    archiver.Paths `json:"paths"`
+	Schedule Schedule       `json:"schedule"`
+	Naming   Naming         `json:"naming"`
+	// AccessLogSource - откуда взят путь access.log: config, xray или default
+	AccessLogSource string `json:"access_log_source"`
+	// Xray - настройки логов из конфигурации Xray, nil если она не найдена
+	Xray *xrayconf.Logs `json:"xray,omitempty"`
+	// XrayError - ошибка чтения найденной конфигурации Xray
+	XrayError string `json:"xray_error,omitempty"`
+}
+
+// Schedule - как часто запускается архивирование и за какой период создается архив
+type Schedule struct {
+	// PollInterval - интервал запусков archive из cron или демона, например 10m или 1h
+	PollInterval string `json:"poll_interval,omitempty"`
+	// Rollover - период архива: hourly, daily или длительность вроде 15m
+	Rollover string `json:"rollover,omitempty"`
+	// MaxAccumulatorMB - максимальный размер накопителя в МБ. При превышении накопитель
+	// запечатывается в часть архива до конца периода. 0 - без ограничения
+	MaxAccumulatorMB int64 `json:"max_accumulator_mb,omitempty"`
+	// TruncateAboveMB - размер access.log в МБ, после которого архиватор усекает его сам,
+	// оставляя хвост TruncateKeepKB. 0 - не усекать
+	TruncateAboveMB int64 `json:"truncate_above_mb,omitempty"`
+	// TruncateKeepKB - сколько последних КБ access.log остается после усечения, по умолчанию 64
+	TruncateKeepKB int64 `json:"truncate_keep_kb,omitempty"`
+	// Compact - уплотнение после создания архива: daily объединяет архивы завершенных дней
+	// в YYYY/MM/DD, monthly - еще и месяцев в YYYY/MM. Пусто - не уплотнять
+	Compact string `json:"compact,omitempty"`
+}
+
+// Naming - шаблон имени архивов. Нужен, когда архивы нескольких серверов собираются в одном месте
+// или когда время в именах должно быть однозначным при переходе на зимнее время
+type Naming struct {
+	// Template - шаблон имени: {time} - начало периода, {node} - узел. По умолчанию access_{time}.log
+	Template string `json:"template,omitempty"`
+	// Node - имя узла для {node}, по умолчанию имя хоста до первой точки
+	Node string `json:"node,omitempty"`
+	// Zone - время в имени: local (по умолчанию, без пояса), utc или offset (со смещением от UTC)
+	Zone string `json:"zone,omitempty"`
+}
+
+// Parse проверяет шаблон и возвращает его в виде, который понимает архиватор
+func (n Naming) Parse() (archiver.Naming, error) {
+	naming, err := archiver.ParseNaming(n.Template, n.Node, n.Zone)
+	if err != nil {
+		return naming, fmt.Errorf("naming: %v", err)
+	}
+	return naming, nil
+}
+
+// merge возвращает шаблон, в котором заданные поля override заменяют поля n
+func (n Naming) merge(override Naming) Naming {
+	if override.Template != "" {
+		n.Template = override.Template
+	}
+	if override.Node != "" {
+		n.Node = override.Node
+	}
+	if override.Zone != "" {
+		n.Zone = override.Zone
+	}
+	return n
+}
+
+// Disk - защита от переполнения диска. Общая для всех профилей: обычно они на одном диске
+type Disk struct {
+	// MinFreeMB - меньше этого свободного места в МБ архиватор переходит в аварийный режим.
+	// 0 - значение по умолчанию (100), -1 отключает проверку
+	MinFreeMB int64 `json:"min_free_mb,omitempty"`
+	// ResumeFreeMB - свободное место, при котором аварийный режим снимается, по умолчанию вдвое больше MinFreeMB
+	ResumeFreeMB int64 `json:"resume_free_mb,omitempty"`
+	// EmergencyKeep - в аварийном режиме удаляются архивы старше этого срока, например 72h или 30d.
+	// Пусто - архивы не удаляются
+	EmergencyKeep string `json:"emergency_keep,omitempty"`
+	// AlertCommand - команда sh, которая запускается при входе в аварийный режим и выходе из него.
+	// Сообщение передается в XUI_ALERT_MESSAGE, состояние - в XUI_ALERT_EMERGENCY (1 или 0)
+	AlertCommand string `json:"alert_command,omitempty"`
+}
+
+// Upload - отправка запечатанных архивов во внешнее хранилище. Общая для всех профилей:
+// архивы профиля, кроме default, лежат в хранилище под префиксом с его именем
+type Upload struct {
+	// S3 и Collector - хранилище архивов, задается одно из них
+	S3        *S3        `json:"s3,omitempty"`
+	Collector *Collector `json:"collector,omitempty"`
+	// DeleteLocal - удалять локальный архив, когда хранилище подтвердило его размер и SHA-256
+	DeleteLocal bool `json:"delete_local,omitempty"`
+}
+
+// Target возвращает хранилище для отправки архивов или nil, если оно не задано
+func (u Upload) Target() (shipper.Target, error) {
+	switch {
+	case u.S3 != nil && u.Collector != nil:
+		return nil, fmt.Errorf("upload: заданы и s3, и collector, задайте одно хранилище")
+	case u.S3 != nil:
+		return u.S3.Target()
+	case u.Collector != nil:
+		return u.Collector.Target()
+	}
+	return nil, nil
+}
+
+// S3 - S3-совместимое хранилище: AWS, MinIO, Ceph и т.п.
+type S3 struct {
+	// Endpoint - адрес API, например https://s3.eu-central-1.amazonaws.com или http://127.0.0.1:9000
+	Endpoint string `json:"endpoint"`
+	// Region - регион для подписи запросов, по умолчанию us-east-1
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket"`
+	// Prefix - префикс ключей, например имя узла: node1/2026/10/24/access_20261024.log.gz
+	Prefix string `json:"prefix,omitempty"`
+	// AccessKey и SecretKey - ключи доступа. Если не заданы, берутся из AWS_ACCESS_KEY_ID
+	// и AWS_SECRET_ACCESS_KEY
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+	// VirtualHost - адресовать бакет поддоменом (bucket.host), а не путем (host/bucket), как MinIO
+	VirtualHost bool `json:"virtual_host,omitempty"`
+	// PartSizeMB - размер части многочастной загрузки в МБ, не меньше 5. По умолчанию 16
+	PartSizeMB int64 `json:"part_size_mb,omitempty"`
+}
+
+// Target проверяет настройки хранилища и возвращает его для отправки архивов
+func (s S3) Target() (*shipper.S3, error) {
+	if s.PartSizeMB != 0 && s.PartSizeMB<<20 < shipper.MIN_PART_SIZE {
+		return nil, fmt.Errorf("upload.s3: размер части %d МБ меньше минимального %d МБ", s.PartSizeMB, shipper.MIN_PART_SIZE>>20)
+	}
+	target, err := shipper.NewS3(shipper.S3Config{
+		Endpoint:     s.Endpoint,
+		Region:       s.Region,
+		Bucket:       s.Bucket,
+		Prefix:       s.Prefix,
+		AccessKey:    firstNonEmpty(s.AccessKey, os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretKey:    firstNonEmpty(s.SecretKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		VirtualHost:  s.VirtualHost,
+		PartSize:     s.PartSizeMB << 20,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("upload.s3: %v", err)
+	}
+	return target, nil
+}
+
+// Collector - коллектор архивов на сервере логов (команда collect). Сертификаты узла выпускает
+// collect --issue <узел>, имя узла из сертификата становится директорией его архивов
+type Collector struct {
+	// URL - адрес коллектора, например https://logs.example.com:8443
+	URL string `json:"url"`
+	// CA - сертификат CA коллектора, Cert и Key - сертификат и ключ узла
+	CA   string `json:"ca"`
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	// ChunkSizeMB - размер куска архива в одном запросе в МБ. По умолчанию 8
+	ChunkSizeMB int64 `json:"chunk_size_mb,omitempty"`
+}
+
+// Target проверяет настройки коллектора и возвращает его для отправки архивов
+func (c Collector) Target() (*shipper.Collector, error) {
+	if c.ChunkSizeMB < 0 {
+		return nil, fmt.Errorf("upload.collector: отрицательный размер куска %d МБ", c.ChunkSizeMB)
+	}
+	target, err := shipper.NewCollector(shipper.CollectorConfig{
+		URL:       c.URL,
+		CA:        c.CA,
+		Cert:      c.Cert,
+		Key:       c.Key,
+		ChunkSize: c.ChunkSizeMB << 20,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("upload.collector: %v", err)
+	}
+	return target, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Path возвращает путь к файлу настроек с учетом переменной окружения
+func Path() string {
+	if path := os.Getenv(CONFIG_ENV); path != "" {
+		return path
+	}
+	return DEFAULT_CONFIG_FILE
+}
+
+// Load читает настройки из файла. Отсутствующий файл не является ошибкой
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			cfg.applyEnv()
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("ошибка чтения файла настроек %s: %v", path, err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return &Config{}, fmt.Errorf("ошибка разбора файла настроек %s: %v", path, err)
+	}
+
+	cfg.applyEnv()
+	return cfg, nil
+}
+
+// Resolve возвращает пути и расписание профиля name. Профиль default без описания
+// в файле соответствует /usr/local/x-ui, остальные профили должны быть описаны
+func (c *Config) Resolve(name string) (Resolved, error) {
+	if name == "" {
+		name = DEFAULT_PROFILE
+	}
+	// Имя профиля попадает в crontab, имена юнитов systemd и метки метрик
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return Resolved{}, fmt.Errorf("имя профиля %q может содержать только строчные латинские буквы, цифры, - и _", name)
+		}
+	}
+	profile, ok := c.Profiles[name]
+	if !ok && name != DEFAULT_PROFILE {
+		return Resolved{}, fmt.Errorf("профиль %q не описан в файле настроек (profiles.%s.dir)", name, name)
+	}
+	if profile.Dir == "" && name != DEFAULT_PROFILE {
+		return Resolved{}, fmt.Errorf("у профиля %q не задана директория dir", name)
+	}
+
+	resolved := Resolved{Name: name, Dir: archiver.BASE_DIR, Schedule: c.Schedule, AccessLogSource: SOURCE_DEFAULT}
+	if profile.Dir != "" {
+		resolved.Dir = profile.Dir
+	}
+	resolved.Paths = archiver.PathsFor(resolved.Dir)
+
+	// Путь access.log по умолчанию - тот, что задан в конфигурации Xray
+	candidates := xrayconf.Candidates(resolved.Dir, name == DEFAULT_PROFILE)
+	if profile.XrayConfig != "" {
+		candidates = []string{profile.XrayConfig}
+	}
+	logs, err := xrayconf.Discover(resolved.Dir, candidates...)
+	switch {
+	case err == nil:
+		resolved.Xray = &logs
+		if path, ok := logs.AccessFile(); ok {
+			resolved.Paths.LogFile = path
+			resolved.AccessLogSource = SOURCE_XRAY
+		}
+	case err != xrayconf.ErrNotFound:
+		resolved.XrayError = err.Error()
+	}
+	if profile.AccessLog != "" {
+		resolved.Paths.LogFile = profile.AccessLog
+		resolved.AccessLogSource = SOURCE_CONFIG
+	}
+	if profile.ArchiveDir != "" {
+		resolved.Paths.ArchiveDir = profile.ArchiveDir
+	}
+	resolved.Schedule = resolved.Schedule.merge(profile.Schedule)
+	resolved.Naming = c.Naming.merge(profile.Naming)
+	return resolved, nil
+}
+
+// ProfileNames возвращает имена всех профилей, включая default
+func (c *Config) ProfileNames() []string {
+	names := []string{DEFAULT_PROFILE}
+	for name := range c.Profiles {
+		if name != DEFAULT_PROFILE {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// merge возвращает расписание, в котором заданные поля override заменяют поля s
+func (s Schedule) merge(override Schedule) Schedule {
+	if override.PollInterval != "" {
+		s.PollInterval = override.PollInterval
+	}
+	if override.Rollover != "" {
+		s.Rollover = override.Rollover
+	}
+	if override.MaxAccumulatorMB != 0 {
+		s.MaxAccumulatorMB = override.MaxAccumulatorMB
+	}
+	if override.TruncateAboveMB != 0 {
+		s.TruncateAboveMB = override.TruncateAboveMB
+	}
+	if override.TruncateKeepKB != 0 {
+		s.TruncateKeepKB = override.TruncateKeepKB
+	}
+	if override.Compact != "" {
+		s.Compact = override.Compact
+	}
+	return s
+}
+
+// Update изменяет файл настроек функцией change и сохраняет его, если что-то изменилось.
+// Переменные окружения не учитываются, чтобы разовые переопределения не попали в файл
+func Update(path string, change func(cfg *Config)) (bool, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("ошибка чтения файла настроек %s: %v", path, err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return false, fmt.Errorf("ошибка разбора файла настроек %s: %v", path, err)
+		}
+	}
+
+	before, _ := json.Marshal(cfg)
+	change(cfg)
+	after, _ := json.Marshal(cfg)
+	if string(before) == string(after) {
+		return false, nil
+	}
+
+	data, err = json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return false, fmt.Errorf("ошибка записи файла настроек %s: %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("ошибка записи файла настроек %s: %v", path, err)
+	}
+	return true, nil
+}
+
+// applyEnv позволяет переопределить уровень и формат лога без правки файла,
+// например XUI_LOG_LEVEL=debug для разового запуска
+func (c *Config) applyEnv() {
+	if level := os.Getenv("XUI_LOG_LEVEL"); level != "" {
+		c.Logging.Level = level
+	}
+	if format := os.Getenv("XUI_LOG_FORMAT"); format != "" {
+		c.Logging.Format = format
+	}
+}
@@ -0,0 +1,234 @@
+// Package dashboard предоставляет встроенный веб-дашборд по архивам DNS-активности
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DEFAULT_ADDR - адрес, на котором дашборд слушает по умолчанию
+const DEFAULT_ADDR = "127.0.0.1:8090"
+
+// DEFAULT_WINDOW - за какой срок дашборд держит записи в памяти по умолчанию
+const DEFAULT_WINDOW = 90 * 24 * time.Hour
+
+// topLimit - сколько позиций показывать в топах
+const topLimit = 20
+
+//go:embed web
+var webFiles embed.FS
+
+// Dashboard обслуживает страницу дашборда и JSON API для нее
+type Dashboard struct {
+	store *store
+	mux   *http.ServeMux
+}
+
+// New создает дашборд поверх директории архивов и временного накопителя
+func New(archiveDir, tempLog string) *Dashboard {
+	d := &Dashboard{
+		store: newStore(archiveDir, tempLog),
+		mux:   http.NewServeMux(),
+	}
+
+	static, _ := fs.Sub(webFiles, "web")
+	d.mux.Handle("/", http.FileServer(http.FS(static)))
+	d.mux.HandleFunc("/api/summary", d.handleSummary)
+	d.mux.HandleFunc("/api/client", d.handleClient)
+	return d
+}
+
+// SetWindow задает, за какой срок держать записи в памяти: старые архивы не читаются, диапазон
+// "all" ограничен этим сроком. 0 - без ограничения
+func (d *Dashboard) SetWindow(window time.Duration) {
+	d.store.mu.Lock()
+	defer d.store.mu.Unlock()
+	d.store.window = window
+	d.store.lastRefresh = time.Time{}
+}
+
+// ServeHTTP реализует http.Handler
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe запускает HTTP-сервер дашборда
+func (d *Dashboard) ListenAndServe(addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           d,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+// countItem - элемент топа
+type countItem struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// timelinePoint - точка графика объема запросов
+type timelinePoint struct {
+	Time  int64 `json:"t"`
+	Count int   `json:"count"`
+}
+
+type summaryResponse struct {
+	From       int64           `json:"from"`
+	To         int64           `json:"to"`
+	Bucket     int64           `json:"bucket"`
+	Total      int             `json:"total"`
+	Timeline   []timelinePoint `json:"timeline"`
+	TopDomains []countItem     `json:"topDomains"`
+	TopClients []countItem     `json:"topClients"`
+}
+
+type clientResponse struct {
+	Name    string      `json:"name"`
+	From    int64       `json:"from"`
+	To      int64       `json:"to"`
+	Total   int         `json:"total"`
+	Domains []countItem `json:"domains"`
+	// Heatmap[день недели][час], неделя начинается с понедельника
+	Heatmap [7][24]int `json:"heatmap"`
+}
+
+// parseRange определяет запрошенный интервал времени по параметру range
+func (d *Dashboard) parseRange(r *http.Request) (int64, int64) {
+	now := time.Now().Unix()
+	switch r.URL.Query().Get("range") {
+	case "1h":
+		return now - 3600, now + 1
+	case "7d":
+		return now - 7*86400, now + 1
+	case "30d":
+		return now - 30*86400, now + 1
+	case "all":
+		minTs, maxTs := d.store.bounds()
+		if minTs == 0 {
+			return now - 86400, now + 1
+		}
+		return minTs, maxTs + 1
+	default:
+		return now - 86400, now + 1
+	}
+}
+
+// bucketSize подбирает шаг графика так, чтобы точек было не больше ~200
+func bucketSize(from, to int64) int64 {
+	for _, size := range []int64{60, 300, 900, 3600, 6 * 3600, 86400} {
+		if (to-from)/size <= 200 {
+			return size
+		}
+	}
+	return 7 * 86400
+}
+
+func (d *Dashboard) handleSummary(w http.ResponseWriter, r *http.Request) {
+	d.store.mu.Lock()
+	defer d.store.mu.Unlock()
+	d.store.refresh(r.URL.Query().Get("refresh") == "1")
+
+	from, to := d.parseRange(r)
+	bucket := bucketSize(from, to)
+	start := from - from%bucket
+
+	timeline := make([]int, (to-start)/bucket+1)
+	domains := make(map[uint32]int)
+	clients := make(map[uint32]int)
+	total := 0
+
+	d.store.each(from, to, func(rec record) {
+		timeline[(rec.ts-start)/bucket]++
+		domains[rec.domain]++
+		clients[rec.client]++
+		total++
+	})
+
+	resp := summaryResponse{
+		From:       from,
+		To:         to,
+		Bucket:     bucket,
+		Total:      total,
+		TopDomains: d.top(domains, topLimit),
+		TopClients: d.top(clients, topLimit),
+	}
+	for i, count := range timeline {
+		resp.Timeline = append(resp.Timeline, timelinePoint{Time: start + int64(i)*bucket, Count: count})
+	}
+	writeJSON(w, resp)
+}
+
+func (d *Dashboard) handleClient(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "параметр name обязателен", http.StatusBadRequest)
+		return
+	}
+
+	d.store.mu.Lock()
+	defer d.store.mu.Unlock()
+	d.store.refresh(false)
+
+	idx, ok := d.store.clientIndex[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("клиент %s не найден", name), http.StatusNotFound)
+		return
+	}
+
+	limit := 200
+	if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 {
+		limit = value
+	}
+
+	from, to := d.parseRange(r)
+	resp := clientResponse{Name: name, From: from, To: to}
+	domains := make(map[uint32]int)
+
+	d.store.each(from, to, func(rec record) {
+		if rec.client != idx {
+			return
+		}
+		t := time.Unix(rec.ts, 0)
+		weekday := (int(t.Weekday()) + 6) % 7
+		resp.Heatmap[weekday][t.Hour()]++
+		domains[rec.domain]++
+		resp.Total++
+	})
+
+	resp.Domains = d.top(domains, limit)
+	writeJSON(w, resp)
+}
+
+// top сортирует счетчики по убыванию и возвращает первые limit элементов
+func (d *Dashboard) top(counts map[uint32]int, limit int) []countItem {
+	items := make([]countItem, 0, len(counts))
+	for idx, count := range counts {
+		items = append(items, countItem{Name: d.store.strings[idx], Count: count})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Name < items[j].Name
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
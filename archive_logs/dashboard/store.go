@@ -0,0 +1,250 @@
+package dashboard
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"xui_log_archiver/fsys"
+	"xui_log_archiver/xraylog"
+)
+
+// refreshInterval - как часто перечитывать список архивов
+const refreshInterval = 30 * time.Second
+
+// record - компактное представление одной строки лога
+type record struct {
+	ts     int64
+	client uint32
+	domain uint32
+}
+
+// fileData - кэш разобранных записей одного файла
+type fileData struct {
+	modTime time.Time
+	size    int64
+	records []record
+}
+
+// store читает архивы и накопитель и держит разобранные записи в памяти
+type store struct {
+	archiveDir string
+	tempLog    string
+	// window - за какой срок держать записи в памяти, 0 - без ограничения
+	window time.Duration
+
+	mu          sync.Mutex
+	files       map[string]*fileData
+	strings     []string
+	stringIndex map[string]uint32
+	// clientIndex - индексы строк, которые встречались как клиенты: в общей таблице есть и домены
+	clientIndex map[string]uint32
+	lastRefresh time.Time
+}
+
+func newStore(archiveDir, tempLog string) *store {
+	return &store{
+		archiveDir:  archiveDir,
+		tempLog:     tempLog,
+		window:      DEFAULT_WINDOW,
+		files:       make(map[string]*fileData),
+		stringIndex: make(map[string]uint32),
+		clientIndex: make(map[string]uint32),
+	}
+}
+
+// intern возвращает индекс строки в общей таблице, чтобы не хранить дубликаты
+func (s *store) intern(value string) uint32 {
+	if idx, ok := s.stringIndex[value]; ok {
+		return idx
+	}
+	idx := uint32(len(s.strings))
+	s.strings = append(s.strings, value)
+	s.stringIndex[value] = idx
+	return idx
+}
+
+// internClient возвращает индекс клиента в общей таблице строк и запоминает, что это клиент
+func (s *store) internClient(client string) uint32 {
+	idx := s.intern(client)
+	s.clientIndex[client] = idx
+	return idx
+}
+
+// sources возвращает список файлов с данными: архивы, в том числе уплотненные в YYYY/MM/DD,
+// и временный накопитель
+func (s *store) sources() []string {
+	var paths []string
+	fsys.Walk(fsys.OS, s.archiveDir, func(path string, info fs.FileInfo) error {
+		name := info.Name()
+		if !strings.HasPrefix(name, "access_") {
+			return nil
+		}
+		if strings.HasSuffix(name, ".log.gz") || strings.HasSuffix(name, ".log") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if s.tempLog != "" {
+		paths = append(paths, s.tempLog)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// cutoff возвращает самую раннюю метку времени, которую дашборд держит в памяти
+func (s *store) cutoff() int64 {
+	if s.window <= 0 {
+		return 0
+	}
+	return time.Now().Add(-s.window).Unix()
+}
+
+// refresh перечитывает изменившиеся файлы. Вызывается под мьютексом
+func (s *store) refresh(force bool) {
+	if !force && time.Since(s.lastRefresh) < refreshInterval {
+		return
+	}
+	s.lastRefresh = time.Now()
+
+	cutoff := s.cutoff()
+	seen := make(map[string]bool)
+	for _, path := range s.sources() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		// Архив создается в конце своего периода: более старый целиком за пределами окна
+		if path != s.tempLog && info.ModTime().Unix() < cutoff {
+			continue
+		}
+		seen[path] = true
+
+		cached, ok := s.files[path]
+		if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			continue
+		}
+
+		records, err := s.load(path, cutoff)
+		if err != nil {
+			continue
+		}
+		s.files[path] = &fileData{modTime: info.ModTime(), size: info.Size(), records: records}
+	}
+
+	// Убираем из кэша удаленные и вышедшие за окно файлы
+	removed := false
+	for path := range s.files {
+		if !seen[path] {
+			delete(s.files, path)
+			removed = true
+		}
+	}
+	if removed {
+		s.compactStrings()
+	}
+}
+
+// compactStrings перестраивает таблицу строк по записям, оставшимся в кэше, чтобы домены
+// и клиенты из вышедших за окно архивов не занимали память
+func (s *store) compactStrings() {
+	old := s.strings
+	s.strings = nil
+	s.stringIndex = make(map[string]uint32)
+	s.clientIndex = make(map[string]uint32)
+	remap := make(map[uint32]uint32)
+	move := func(idx uint32, client bool) uint32 {
+		moved, ok := remap[idx]
+		if !ok {
+			moved = s.intern(old[idx])
+			remap[idx] = moved
+		}
+		if client {
+			s.clientIndex[old[idx]] = moved
+		}
+		return moved
+	}
+	for _, data := range s.files {
+		for i := range data.records {
+			data.records[i].client = move(data.records[i].client, true)
+			data.records[i].domain = move(data.records[i].domain, false)
+		}
+	}
+}
+
+// load читает и разбирает один файл (.log или .log.gz), пропуская записи раньше cutoff
+func (s *store) load(path string, cutoff int64) ([]record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	var records []record
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, ok := xraylog.Parse(scanner.Text())
+		if !ok || entry.Domain == "" || entry.Time.Unix() < cutoff {
+			continue
+		}
+		client := entry.Client()
+		if entry.Kind == xraylog.KindDNS {
+			client = "(dns)"
+		}
+		records = append(records, record{
+			ts:     entry.Time.Unix(),
+			client: s.internClient(client),
+			domain: s.intern(entry.Domain),
+		})
+	}
+	return records, scanner.Err()
+}
+
+// each вызывает fn для каждой записи в интервале [from, to) внутри окна
+func (s *store) each(from, to int64, fn func(r record)) {
+	from = max(from, s.cutoff())
+	for _, data := range s.files {
+		for _, r := range data.records {
+			if r.ts >= from && r.ts < to {
+				fn(r)
+			}
+		}
+	}
+}
+
+// bounds возвращает минимальную и максимальную метку времени среди записей внутри окна
+func (s *store) bounds() (int64, int64) {
+	cutoff := s.cutoff()
+	var minTs, maxTs int64
+	for _, data := range s.files {
+		for _, r := range data.records {
+			if r.ts < cutoff {
+				continue
+			}
+			if minTs == 0 || r.ts < minTs {
+				minTs = r.ts
+			}
+			if r.ts > maxTs {
+				maxTs = r.ts
+			}
+		}
+	}
+	return minTs, maxTs
+}
@@ -0,0 +1,130 @@
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+const DAYS = ["Пн", "Вт", "Ср", "Чт", "Пт", "Сб", "Вс"];
+
+let clientDomains = [];
+
+async function fetchJSON(url) {
+  const resp = await fetch(url);
+  if (!resp.ok) {
+    throw new Error(await resp.text());
+  }
+  return resp.json();
+}
+
+function escapeHTML(value) {
+  return String(value).replace(/[&<>"']/g, (c) => ({
+    "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;",
+  }[c]));
+}
+
+function formatTime(ts, bucket) {
+  const d = new Date(ts * 1000);
+  const pad = (n) => String(n).padStart(2, "0");
+  const date = pad(d.getDate()) + "." + pad(d.getMonth() + 1);
+  if (bucket >= 86400) {
+    return date;
+  }
+  return date + " " + pad(d.getHours()) + ":" + pad(d.getMinutes());
+}
+
+function renderTimeline(data) {
+  const width = 1000;
+  const height = 220;
+  const bottom = 20;
+  const points = data.timeline || [];
+  const max = Math.max(1, ...points.map((p) => p.count));
+  const step = width / Math.max(1, points.length);
+
+  let svg = `<svg viewBox="0 0 ${width} ${height}" preserveAspectRatio="none">`;
+  points.forEach((p, i) => {
+    const h = (p.count / max) * (height - bottom - 10);
+    const x = i * step;
+    svg += `<rect x="${x + 0.5}" y="${height - bottom - h}" width="${Math.max(1, step - 1)}" height="${h}">` +
+      `<title>${formatTime(p.t, data.bucket)}: ${p.count}</title></rect>`;
+  });
+
+  const labels = Math.min(8, points.length);
+  for (let i = 0; i < labels; i++) {
+    const idx = Math.floor((i * points.length) / labels);
+    svg += `<text x="${idx * step}" y="${height - 5}">${formatTime(points[idx].t, data.bucket)}</text>`;
+  }
+  svg += `<text x="${width - 4}" y="12" text-anchor="end">max ${max}</text></svg>`;
+  $("timeline").innerHTML = svg;
+}
+
+function renderTop(table, items, onClick) {
+  const max = Math.max(1, ...items.map((i) => i.count));
+  table.innerHTML = items.map((item) =>
+    `<tr data-name="${escapeHTML(item.name)}">` +
+    `<td class="name" title="${escapeHTML(item.name)}">${escapeHTML(item.name)}</td>` +
+    `<td class="bar"><div style="width:${(item.count / max) * 100}%"></div></td>` +
+    `<td class="count">${item.count}</td></tr>`
+  ).join("") || `<tr><td class="muted">Нет данных</td></tr>`;
+
+  if (onClick) {
+    table.querySelectorAll("tr[data-name]").forEach((row) => {
+      row.addEventListener("click", () => onClick(row.dataset.name));
+    });
+  }
+}
+
+function renderHeatmap(heatmap) {
+  const max = Math.max(1, ...heatmap.flat());
+  let html = `<div></div>`;
+  for (let h = 0; h < 24; h++) {
+    html += `<div class="label">${h}</div>`;
+  }
+  heatmap.forEach((row, day) => {
+    html += `<div class="label">${DAYS[day]}</div>`;
+    row.forEach((count, hour) => {
+      const alpha = count === 0 ? 0 : 0.15 + 0.85 * (count / max);
+      html += `<div class="cell" title="${DAYS[day]} ${hour}:00 — ${count}"` +
+        ` style="background: rgba(63, 167, 255, ${alpha})"></div>`;
+    });
+  });
+  $("heatmap").innerHTML = html;
+}
+
+function renderClientDomains() {
+  const filter = $("domain-filter").value.trim().toLowerCase();
+  const items = filter ? clientDomains.filter((d) => d.name.includes(filter)) : clientDomains;
+  renderTop($("client-domains"), items);
+}
+
+async function openClient(name) {
+  const range = $("range").value;
+  try {
+    const data = await fetchJSON(`api/client?name=${encodeURIComponent(name)}&range=${range}&limit=1000`);
+    $("client-name").textContent = data.name;
+    $("client-total").textContent = `(${data.total} запросов)`;
+    clientDomains = data.domains || [];
+    renderHeatmap(data.heatmap);
+    renderClientDomains();
+    $("client-card").classList.remove("hidden");
+    $("client-card").scrollIntoView({ behavior: "smooth" });
+  } catch (err) {
+    alert("Ошибка загрузки клиента: " + err.message);
+  }
+}
+
+async function load(force) {
+  const range = $("range").value;
+  try {
+    const data = await fetchJSON(`api/summary?range=${range}${force ? "&refresh=1" : ""}`);
+    $("total").textContent = `Всего запросов: ${data.total}`;
+    renderTimeline(data);
+    renderTop($("top-domains"), data.topDomains || []);
+    renderTop($("top-clients"), data.topClients || [], openClient);
+  } catch (err) {
+    $("total").textContent = "Ошибка загрузки: " + err.message;
+  }
+}
+
+$("range").addEventListener("change", () => load(false));
+$("refresh").addEventListener("click", () => load(true));
+$("client-close").addEventListener("click", () => $("client-card").classList.add("hidden"));
+$("domain-filter").addEventListener("input", renderClientDomains);
+
+load(false);
@@ -0,0 +1,348 @@
+// Package doctor проверяет всю цепочку архивирования: планировщик, программу, access.log,
+// конфигурацию Xray, место на диске и состояние архиватора
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/config"
+	"xui_log_archiver/fsys"
+	"xui_log_archiver/installer"
+	"xui_log_archiver/shipper"
+	"xui_log_archiver/xrayconf"
+)
+
+// Результаты проверки
+const (
+	PASS = "pass"
+	WARN = "warn"
+	FAIL = "fail"
+)
+
+const (
+	// MIN_FREE_BYTES - меньше этого свободного места архивы и накопитель перестают помещаться
+	MIN_FREE_BYTES = 100 << 20
+	// LOW_FREE_BYTES - свободного места мало, стоит освободить заранее
+	LOW_FREE_BYTES = 1 << 30
+	// STALE_LOG_AGE - access.log, который не менялся дольше, считается остановившимся
+	STALE_LOG_AGE = time.Hour
+	// UPLOAD_PROBE_TIMEOUT - сколько ждать ответа хранилища архивов
+	UPLOAD_PROBE_TIMEOUT = 10 * time.Second
+)
+
+// Check - результат одной проверки
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Fix - что сделать, чтобы исправить проблему. Пусто для пройденных проверок
+	Fix string `json:"fix,omitempty"`
+}
+
+// Report - результаты всех проверок профиля
+type Report struct {
+	Profile string  `json:"profile"`
+	Checks  []Check `json:"checks"`
+	Failed  int     `json:"failed"`
+	Warned  int     `json:"warned"`
+}
+
+// Doctor проверяет профиль по состоянию установщика и архиватора
+type Doctor struct {
+	profile config.Resolved
+	setup   *installer.Installer
+	arch    *archiver.Archiver
+	ship    *shipper.Shipper
+	shipErr error
+	now     time.Time
+	report  Report
+}
+
+// New создает проверку профиля. Установщик и архиватор должны быть настроены на этот профиль
+func New(profile config.Resolved, setup *installer.Installer, arch *archiver.Archiver) *Doctor {
+	return &Doctor{
+		profile: profile,
+		setup:   setup,
+		arch:    arch,
+		now:     time.Now(),
+		report:  Report{Profile: profile.Name, Checks: []Check{}},
+	}
+}
+
+// SetUpload задает отправку архивов для проверки: ship - nil, если хранилище не задано,
+// err - ошибка настроек хранилища
+func (d *Doctor) SetUpload(ship *shipper.Shipper, err error) {
+	d.ship, d.shipErr = ship, err
+}
+
+// command возвращает команду архиватора для профиля
+func (d *Doctor) command(args string) string {
+	if d.profile.Name == config.DEFAULT_PROFILE {
+		return "xui_log_archiver " + args
+	}
+	return fmt.Sprintf("xui_log_archiver %s --profile %s", args, d.profile.Name)
+}
+
+func (d *Doctor) add(name, status, message, fix string) {
+	if status == PASS {
+		fix = ""
+	}
+	d.report.Checks = append(d.report.Checks, Check{Name: name, Status: status, Message: message, Fix: fix})
+	switch status {
+	case FAIL:
+		d.report.Failed++
+	case WARN:
+		d.report.Warned++
+	}
+}
+
+// Run выполняет все проверки. Проверки не останавливаются на первой ошибке
+func (d *Doctor) Run() Report {
+	d.checkAutostart()
+	d.checkAccessLog()
+	d.checkXray()
+	d.checkArchiveDir()
+	d.checkDiskSpace()
+	d.checkLeftovers()
+	d.checkNaming()
+	d.checkPosition()
+	d.checkLastRun()
+	d.checkUpload()
+	return d.report
+}
+
+// checkAutostart проверяет задачу в cron или таймер systemd, планировщик и программу
+func (d *Doctor) checkAutostart() {
+	status, err := d.setup.Status()
+	if err != nil {
+		d.add("Автозапуск", FAIL, err.Error(), "проверьте, что установлен cron и доступна команда crontab")
+		return
+	}
+
+	install := d.command("install --yes")
+	if !status.Installed {
+		d.add("Автозапуск", FAIL, "нет задачи в crontab и таймера systemd", install)
+	} else {
+		d.add("Автозапуск", PASS, fmt.Sprintf("%s: %s", status.Scheduler, status.CronEntry), "")
+		if d.setup.SchedulerActive(status.Scheduler) {
+			d.add("Планировщик", PASS, fmt.Sprintf("%s работает", status.Scheduler), "")
+		} else if status.Scheduler == installer.SCHEDULER_SYSTEMD {
+			d.add("Планировщик", FAIL, "таймер systemd не активен", fmt.Sprintf("systemctl enable --now %s", status.CronEntry))
+		} else {
+			d.add("Планировщик", FAIL, "демон cron не запущен: задача не выполняется",
+				"systemctl enable --now cron (или crond в RHEL-подобных системах)")
+		}
+	}
+
+	info, err := os.Stat(status.BinaryPath)
+	switch {
+	case err != nil:
+		d.add("Программа", FAIL, fmt.Sprintf("%s не найдена", status.BinaryPath), install)
+	case info.Mode().Perm()&0111 == 0:
+		d.add("Программа", FAIL, fmt.Sprintf("%s не исполняемая (%v)", status.BinaryPath, info.Mode().Perm()),
+			fmt.Sprintf("chmod 755 %s", status.BinaryPath))
+	default:
+		d.add("Программа", PASS, status.BinaryPath, "")
+	}
+}
+
+// checkAccessLog проверяет, что access.log читается и растет
+func (d *Doctor) checkAccessLog() {
+	path := d.profile.Paths.LogFile
+	file, err := os.Open(path)
+	if err != nil {
+		d.add("access.log", FAIL, fmt.Sprintf("не читается: %v", err),
+			"включите access лог в настройках Xray панели x-ui или задайте путь profiles.<имя>.access_log")
+		return
+	}
+	info, err := file.Stat()
+	file.Close()
+	if err != nil {
+		d.add("access.log", FAIL, fmt.Sprintf("ошибка чтения %s: %v", path, err), "")
+		return
+	}
+
+	age := d.now.Sub(info.ModTime()).Truncate(time.Second)
+	if age > STALE_LOG_AGE {
+		d.add("access.log", WARN, fmt.Sprintf("%s не менялся %v", path, age),
+			"проверьте, что Xray запущен и пишет access лог в этот файл (xui_log_archiver status)")
+		return
+	}
+	d.add("access.log", PASS, fmt.Sprintf("%s, изменен %v назад", path, age), "")
+}
+
+// checkXray проверяет настройки логов в конфигурации Xray
+func (d *Doctor) checkXray() {
+	switch {
+	case d.profile.XrayError != "":
+		d.add("Конфигурация Xray", WARN, d.profile.XrayError, "перезапустите Xray в панели x-ui, чтобы она пересоздала конфигурацию")
+		return
+	case d.profile.Xray == nil:
+		d.add("Конфигурация Xray", WARN, "не найдена, путь access.log не проверить",
+			"укажите путь к конфигурации в profiles.<имя>.xray_config")
+		return
+	}
+
+	logs := d.profile.Xray
+	switch logs.Access {
+	case xrayconf.DISABLED:
+		d.add("Access лог Xray", FAIL, "отключен (log.access = \"none\")",
+			"в панели x-ui: Настройки Xray -> Журнал -> Access log, например ./access.log")
+	case xrayconf.STDOUT:
+		d.add("Access лог Xray", FAIL, "не задан, Xray пишет его в stdout",
+			"в панели x-ui: Настройки Xray -> Журнал -> Access log, например ./access.log")
+	default:
+		if logs.Access != d.profile.Paths.LogFile {
+			d.add("Access лог Xray", WARN, fmt.Sprintf("Xray пишет в %s, а архиватор читает %s", logs.Access, d.profile.Paths.LogFile),
+				"уберите profiles.<имя>.access_log из файла настроек")
+		} else {
+			d.add("Access лог Xray", PASS, logs.Access, "")
+		}
+	}
+	if !logs.DNSLog {
+		d.add("DNS лог Xray", WARN, "log.dnsLog = false: запросы DNS не попадают в access лог",
+			"в панели x-ui: Настройки Xray -> Журнал -> DNS log")
+	}
+}
+
+// checkArchiveDir проверяет, что в директорию архивов можно писать
+func (d *Doctor) checkArchiveDir() {
+	dir := d.profile.Paths.ArchiveDir
+	probe, err := os.CreateTemp(dir, ".doctor_*")
+	if err != nil {
+		d.add("Директория архивов", FAIL, fmt.Sprintf("запись невозможна: %v", err),
+			fmt.Sprintf("mkdir -p %s и проверьте права пользователя, от которого запускается архиватор", dir))
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	d.add("Директория архивов", PASS, dir, "")
+}
+
+// checkDiskSpace проверяет свободное место в директории архивов
+func (d *Doctor) checkDiskSpace() {
+	free, err := fsys.OS.Free(d.profile.Paths.ArchiveDir)
+	if err != nil {
+		d.add("Место на диске", WARN, fmt.Sprintf("не удалось проверить: %v", err), "")
+		return
+	}
+	message := fmt.Sprintf("свободно %d МБ", free>>20)
+	fix := fmt.Sprintf("удалите старые архивы из %s или перенесите их на другой диск", d.profile.Paths.ArchiveDir)
+	if since := d.arch.Health(d.now).DiskEmergency; since != nil {
+		d.add("Место на диске", FAIL, fmt.Sprintf("%s, аварийный режим с %s: access.log не читается", message, since.Format("2006-01-02 15:04")),
+			fix+"; архивирование продолжится само, когда места станет больше disk.resume_free_mb")
+		return
+	}
+	switch {
+	case free < MIN_FREE_BYTES:
+		d.add("Место на диске", FAIL, message, fix)
+	case free < LOW_FREE_BYTES:
+		d.add("Место на диске", WARN, message, fix)
+	default:
+		d.add("Место на диске", PASS, message, "")
+	}
+}
+
+// checkLeftovers ищет архивы периодов, которые не были сжаты из-за сбоя
+func (d *Doctor) checkLeftovers() {
+	leftovers := d.arch.UncompressedArchives()
+	if len(leftovers) == 0 {
+		d.add("Несжатые архивы", PASS, "нет", "")
+		return
+	}
+	d.add("Несжатые архивы", WARN, fmt.Sprintf("%d шт., например %s", len(leftovers), filepath.Base(leftovers[0])),
+		fmt.Sprintf("gzip %s/access_*.log", d.profile.Paths.ArchiveDir))
+}
+
+// checkNaming проверяет шаблон имени архивов и что существующие архивы названы по нему
+func (d *Doctor) checkNaming() {
+	if _, err := d.profile.Naming.Parse(); err != nil {
+		d.add("Имена архивов", FAIL, fmt.Sprintf("%v: архивы называются по шаблону %s", err, archiver.DEFAULT_NAMING),
+			"исправьте naming в "+config.Path())
+		return
+	}
+	naming := d.arch.Naming()
+	message := fmt.Sprintf("%s, время %s", naming.Template(), naming.Zone())
+	misnamed := d.arch.MisnamedArchives()
+	if len(misnamed) > 0 {
+		d.add("Имена архивов", WARN, fmt.Sprintf("не по шаблону %s: %d шт., например %s",
+			naming.Template(), len(misnamed), filepath.Base(misnamed[0])), d.command("rename"))
+		return
+	}
+	d.add("Имена архивов", PASS, message, "")
+}
+
+// checkPosition проверяет, что позиция архиватора не дальше конца access.log
+func (d *Doctor) checkPosition() {
+	info, err := os.Stat(d.profile.Paths.LogFile)
+	if err != nil {
+		return
+	}
+	position := d.arch.Position()
+	if position > info.Size() {
+		d.add("Позиция", WARN, fmt.Sprintf("позиция %d больше размера access.log %d: файл был очищен или заменен", position, info.Size()),
+			"запустите "+d.command("archive")+": он прочитает access.log с начала")
+		return
+	}
+	d.add("Позиция", PASS, fmt.Sprintf("%d из %d байт", position, info.Size()), "")
+}
+
+// checkLastRun проверяет итоги последнего запуска архиватора
+func (d *Doctor) checkLastRun() {
+	health := d.arch.Health(d.now)
+	switch {
+	case health.LastRun == nil:
+		d.add("Последний запуск", WARN, "архиватор еще не запускался",
+			d.command("archive"))
+	case health.LastError != "" && (health.LastSuccess == nil || !health.LastErrorTime.Before(*health.LastSuccess)):
+		d.add("Последний запуск", FAIL, health.LastError, "исправьте причину ошибки и проверьте журнал "+
+			filepath.Join(d.profile.Paths.ArchiveDir, "archive.log"))
+	default:
+		d.add("Последний запуск", PASS, fmt.Sprintf("успешно %v назад", d.now.Sub(*health.LastSuccess).Truncate(time.Second)), "")
+	}
+}
+
+// checkUpload проверяет, что хранилище архивов отвечает и архивы из очереди отправляются
+func (d *Doctor) checkUpload() {
+	if d.shipErr != nil {
+		d.add("Отправка архивов", FAIL, d.shipErr.Error(), "исправьте upload в "+config.Path())
+		return
+	}
+	if d.ship == nil {
+		return
+	}
+	target := d.ship.Target()
+
+	// Несуществующий объект: хранилище должно ответить, что его нет
+	ctx, cancel := context.WithTimeout(context.Background(), UPLOAD_PROBE_TIMEOUT)
+	defer cancel()
+	if _, err := target.Stat(ctx, "doctor_probe"); err != nil && !errors.Is(err, shipper.ErrNotFound) {
+		d.add("Отправка архивов", FAIL, fmt.Sprintf("%s недоступно: %v", target, err),
+			"проверьте адрес хранилища и доступ к нему (ключи или сертификаты) в upload "+config.Path())
+		return
+	}
+
+	queue, err := d.ship.Queue()
+	if err != nil {
+		d.add("Отправка архивов", FAIL, err.Error(), "")
+		return
+	}
+	var failing []shipper.Item
+	for _, item := range queue {
+		if item.Attempts > 0 {
+			failing = append(failing, item)
+		}
+	}
+	if len(failing) > 0 {
+		d.add("Отправка архивов", WARN, fmt.Sprintf("в %s не отправлено %d из %d архивов очереди, %d попыток: %s",
+			target, len(failing), len(queue), failing[0].Attempts, failing[0].LastError), d.command("upload"))
+		return
+	}
+	d.add("Отправка архивов", PASS, fmt.Sprintf("%s, в очереди %d", target, len(queue)), "")
+}
@@ -0,0 +1,107 @@
+// Package fsys - файловая система, через которую работают архиватор и merge. В тестах ее
+// подменяют, чтобы имитировать сбой на любом шаге: при записи позиции, переименовании
+// накопителя или сжатии архива
+package fsys
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File - открытый файл
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+}
+
+// FS - операции с файлами, которые нужны архиватору и merge. Пути те же, что и у пакета os
+type FS interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Create(name string) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Glob(pattern string) ([]string, error)
+	MkdirAll(path string, perm fs.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Truncate(name string, size int64) error
+	Chtimes(name string, atime, mtime time.Time) error
+	// Free возвращает место, доступное непривилегированным процессам в файловой системе path
+	Free(path string) (int64, error)
+}
+
+// OS - файловая система операционной системы
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) { return os.Open(name) }
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Create(name string) (File, error) { return os.Create(name) }
+
+func (osFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+func (osFS) ReadFile(name string) ([]byte, error) { return os.ReadFile(name) }
+
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+
+func (osFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+
+func (osFS) MkdirAll(path string, perm fs.FileMode) error { return os.MkdirAll(path, perm) }
+
+func (osFS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+func (osFS) Remove(name string) error { return os.Remove(name) }
+
+func (osFS) Truncate(name string, size int64) error { return os.Truncate(name, size) }
+
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// Walk обходит файлы в root и его поддиректориях в лексическом порядке. Ошибки чтения
+// директорий пропускаются: обход нужен для подсчетов, а не для точного списка.
+// Скрытые директории вроде .backfill - рабочие, их содержимое не обходится
+func Walk(fsys FS, root string, fn func(path string, info fs.FileInfo) error) error {
+	entries, err := fsys.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		if entry.IsDir() {
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if err := Walk(fsys, path, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if err := fn(path, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package fsys
+
+import "syscall"
+
+func (osFS) Free(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
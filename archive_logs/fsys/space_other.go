@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fsys
+
+import "fmt"
+
+// Free не реализован вне Linux
+func (osFS) Free(path string) (int64, error) {
+	return 0, fmt.Errorf("проверка места поддерживается только в Linux")
+}
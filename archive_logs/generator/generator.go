@@ -0,0 +1,268 @@
+// Package generator создает правдоподобные строки access.log Xray - соединения клиентов
+// и ответы DNS - для нагрузочных тестов и демонстрации дашборда
+package generator
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"xui_log_archiver/archiver"
+)
+
+const (
+	DEFAULT_RATE      = 20
+	DEFAULT_USERS     = 10
+	DEFAULT_SKEW      = 1.2
+	DEFAULT_DNS_SHARE = 0.3
+
+	// TIME_LAYOUT - формат времени в строках access.log Xray
+	TIME_LAYOUT = "2006/01/02 15:04:05.000000"
+)
+
+// DEFAULT_DOMAINS - домены по умолчанию, от самых популярных к редким
+var DEFAULT_DOMAINS = []string{
+	"www.google.com", "www.youtube.com", "i.ytimg.com", "rr3---sn-4g5ednsl.googlevideo.com",
+	"api.telegram.org", "web.telegram.org", "www.instagram.com", "scontent.cdninstagram.com",
+	"graph.facebook.com", "www.whatsapp.com", "mmg.whatsapp.net", "github.com",
+	"api.github.com", "objects.githubusercontent.com", "www.wikipedia.org", "ru.wikipedia.org",
+	"open.spotify.com", "audio-ak-spotify-com.akamaized.net", "www.netflix.com", "ipv4-c001.1.oca.nflxvideo.net",
+	"twitter.com", "pbs.twimg.com", "www.reddit.com", "i.redd.it",
+	"chat.openai.com", "discord.com", "cdn.discordapp.com", "www.linkedin.com",
+	"store.steampowered.com", "news.ycombinator.com",
+}
+
+// Config - параметры генератора. Нулевые значения заменяются значениями по умолчанию
+type Config struct {
+	// Rate - средняя скорость, строк в секунду. Интервалы между строками случайные, как у настоящих соединений
+	Rate float64
+	// Users - число клиентов: user1, user2, ... со своими IP
+	Users int
+	// Domains - домены от самых популярных к редким
+	Domains []string
+	// Skew - показатель распределения Ципфа для доменов (больше 1): чем больше, тем сильнее
+	// трафик сосредоточен на первых доменах списка
+	Skew float64
+	// DNSShare - доля строк DNS от 0 до 1
+	DNSShare float64
+	// Seed - начальное значение генератора случайных чисел: одинаковый Seed дает одинаковые строки
+	Seed int64
+}
+
+// Stats - итоги генерации
+type Stats struct {
+	Lines    int      `json:"lines"`
+	DNSLines int      `json:"dns_lines"`
+	Archives []string `json:"archives,omitempty"`
+	File     string   `json:"file,omitempty"`
+}
+
+// Generator создает строки access.log
+type Generator struct {
+	cfg     Config
+	rnd     *rand.Rand
+	domains *rand.Zipf
+}
+
+// New создает генератор
+func New(cfg Config) (*Generator, error) {
+	if cfg.Rate == 0 {
+		cfg.Rate = DEFAULT_RATE
+	}
+	if cfg.Users == 0 {
+		cfg.Users = DEFAULT_USERS
+	}
+	if len(cfg.Domains) == 0 {
+		cfg.Domains = DEFAULT_DOMAINS
+	}
+	if cfg.Skew == 0 {
+		cfg.Skew = DEFAULT_SKEW
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	switch {
+	case cfg.Rate < 0:
+		return nil, fmt.Errorf("скорость должна быть больше 0")
+	case cfg.Users < 0:
+		return nil, fmt.Errorf("число клиентов должно быть больше 0")
+	case cfg.Skew <= 1:
+		return nil, fmt.Errorf("показатель распределения доменов должен быть больше 1")
+	case cfg.DNSShare < 0 || cfg.DNSShare > 1:
+		return nil, fmt.Errorf("доля строк DNS должна быть от 0 до 1")
+	}
+
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+	return &Generator{
+		cfg:     cfg,
+		rnd:     rnd,
+		domains: rand.NewZipf(rnd, cfg.Skew, 1, uint64(len(cfg.Domains)-1)),
+	}, nil
+}
+
+// LoadDomains читает список доменов из файла: по одному на строку, от популярных к редким.
+// Пустые строки и комментарии # пропускаются
+func LoadDomains(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения списка доменов %s: %v", path, err)
+	}
+	var domains []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, strings.ToLower(strings.Fields(line)[0]))
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("в %s нет доменов", path)
+	}
+	return domains, nil
+}
+
+// Line возвращает строку лога со временем t и признак строки DNS
+func (g *Generator) Line(t time.Time) (string, bool) {
+	domain := g.cfg.Domains[g.domains.Uint64()]
+	stamp := t.Format(TIME_LAYOUT)
+
+	if g.rnd.Float64() < g.cfg.DNSShare {
+		if g.rnd.Intn(3) == 0 {
+			return fmt.Sprintf("%s app/dns: cache HIT: %s -> [%s]", stamp, domain, addressOf(domain)), true
+		}
+		return fmt.Sprintf("%s app/dns: UDP:1.1.1.1:53 got answer: %s. TypeA -> [%s] %.3fms",
+			stamp, domain, addressOf(domain), 5+g.rnd.ExpFloat64()*20), true
+	}
+
+	user := g.rnd.Intn(g.cfg.Users) + 1
+	network, port, outbound := "tcp", 443, "direct"
+	switch n := g.rnd.Intn(100); {
+	case n < 10:
+		// QUIC
+		network = "udp"
+	case n < 15:
+		port = 80
+	case n < 17:
+		outbound = "block"
+	}
+	return fmt.Sprintf("%s from tcp:%s:%d accepted %s:%s:%d [inbound-443 >> %s] email: user%d",
+		stamp, clientAddress(user), 1024+g.rnd.Intn(64000), network, domain, port, outbound, user), false
+}
+
+// next возвращает время следующей строки после t
+func (g *Generator) next(t time.Time) time.Time {
+	return t.Add(time.Duration(g.rnd.ExpFloat64() / g.cfg.Rate * float64(time.Second)))
+}
+
+// Live дописывает строки в w в реальном времени, пока не отменен ctx или не записано
+// limit строк (0 - без ограничения)
+func (g *Generator) Live(ctx context.Context, w io.Writer, limit int) (Stats, error) {
+	var stats Stats
+	writer := bufio.NewWriter(w)
+	at := time.Now()
+	for limit == 0 || stats.Lines < limit {
+		at = g.next(at)
+		// Перед ожиданием сбрасываем буфер, чтобы строки появлялись в файле вовремя
+		if wait := time.Until(at); wait > 0 {
+			if err := writer.Flush(); err != nil {
+				return stats, err
+			}
+			select {
+			case <-ctx.Done():
+				return stats, nil
+			case <-time.After(wait):
+			}
+		}
+		line, dns := g.Line(at)
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			return stats, err
+		}
+		stats.count(dns)
+	}
+	return stats, writer.Flush()
+}
+
+// Backfill создает в dir сжатые архивы за завершенные периоды p с from до to с теми же именами,
+// что и архиватор с шаблоном naming. Существующие архивы не перезаписываются
+func (g *Generator) Backfill(dir string, naming archiver.Naming, p archiver.Period, from, to time.Time) (Stats, error) {
+	var stats Stats
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return stats, fmt.Errorf("ошибка создания директории %s: %v", dir, err)
+	}
+	for start := p.Start(naming.In(from)); !p.Next(start).After(to); start = p.Next(start) {
+		path := filepath.Join(dir, naming.ArchiveName(p, start)+".gz")
+		if _, err := os.Stat(path); err == nil {
+			return stats, fmt.Errorf("архив %s уже существует", path)
+		}
+		if err := g.writeArchive(path, start, p.Next(start), &stats); err != nil {
+			return stats, fmt.Errorf("ошибка создания архива %s: %v", path, err)
+		}
+		stats.Archives = append(stats.Archives, path)
+	}
+	return stats, nil
+}
+
+// writeArchive записывает строки периода [start, end) в сжатый архив path
+func (g *Generator) writeArchive(path string, start, end time.Time, stats *Stats) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	gz := gzip.NewWriter(file)
+	writer := bufio.NewWriter(gz)
+	for at := g.next(start); at.Before(end); at = g.next(at) {
+		line, dns := g.Line(at)
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			file.Close()
+			return err
+		}
+		stats.count(dns)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// Архиватор создает архив в начале следующего периода
+	return os.Chtimes(path, end, end)
+}
+
+func (s *Stats) count(dns bool) {
+	s.Lines++
+	if dns {
+		s.DNSLines++
+	}
+}
+
+// clientAddress возвращает постоянный IP клиента
+func clientAddress(user int) string {
+	return fmt.Sprintf("10.%d.%d.%d", user/65536%256, user/256%256, user%256)
+}
+
+// addressOf возвращает постоянный адрес домена из диапазона 198.18.0.0/15 для тестов
+func addressOf(domain string) string {
+	h := fnv.New32a()
+	h.Write([]byte(domain))
+	sum := h.Sum32()
+	return fmt.Sprintf("198.%d.%d.%d", 18+sum>>16%2, sum>>8%256, sum%256)
+}
@@ -0,0 +1,59 @@
+package generator
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/xraylog"
+)
+
+func TestLinesParse(t *testing.T) {
+	gen, err := New(Config{Seed: 1, DNSShare: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 5, 4, 10, 0, 0, 0, time.Local)
+	kinds := map[xraylog.Kind]int{}
+	for i := 0; i < 1000; i++ {
+		line, dns := gen.Line(at)
+		entry, ok := xraylog.Parse(line)
+		if !ok {
+			t.Fatalf("строка не разбирается: %s", line)
+		}
+		if dns != (entry.Kind == xraylog.KindDNS) || entry.Domain == "" || !entry.Time.Equal(at) {
+			t.Fatalf("строка разобрана неверно: %s -> %+v", line, entry)
+		}
+		kinds[entry.Kind]++
+	}
+	if kinds[xraylog.KindDNS] == 0 || kinds[xraylog.KindAccess] == 0 {
+		t.Errorf("нет строк одного из типов: %v", kinds)
+	}
+}
+
+func TestBackfillCreatesCompletedPeriods(t *testing.T) {
+	gen, err := New(Config{Seed: 1, Rate: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	to := time.Date(2026, 5, 4, 13, 20, 0, 0, time.Local)
+	stats, err := gen.Backfill(dir, archiver.Naming{}, archiver.HOURLY, to.Add(-3*time.Hour), to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Период 13:00 еще не завершен
+	want := []string{"access_20260504_10.log.gz", "access_20260504_11.log.gz", "access_20260504_12.log.gz"}
+	if len(stats.Archives) != len(want) {
+		t.Fatalf("архивы %v, ожидалось %v", stats.Archives, want)
+	}
+	for i, path := range stats.Archives {
+		if filepath.Base(path) != want[i] {
+			t.Errorf("архив %s, ожидался %s", path, want[i])
+		}
+	}
+	if _, err := gen.Backfill(dir, archiver.Naming{}, archiver.HOURLY, to.Add(-time.Hour), to); err == nil {
+		t.Error("существующий архив перезаписан")
+	}
+}
@@ -0,0 +1,160 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"xui_log_archiver/config"
+)
+
+// readCrontab возвращает текущий crontab пользователя. Отсутствие crontab не является ошибкой
+func readCrontab() (string, error) {
+	cmd := exec.Command("crontab", "-l")
+	output, err := cmd.Output()
+	if err != nil {
+		// Сообщение "no crontab for user" crontab пишет в stderr
+		if exitErr, ok := err.(*exec.ExitError); ok && strings.Contains(string(exitErr.Stderr), "no crontab") {
+			return "", nil
+		}
+		return "", fmt.Errorf("ошибка получения crontab: %v", err)
+	}
+	return string(output), nil
+}
+
+// writeCrontab устанавливает новый crontab пользователя
+func writeCrontab(content string) error {
+	// Создаем временный файл с новым crontab
+	tempFile, err := os.CreateTemp("", "crontab_*")
+	if err != nil {
+		return fmt.Errorf("ошибка создания временного файла: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.WriteString(content); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("ошибка записи в временный файл: %v", err)
+	}
+	tempFile.Close()
+
+	// Устанавливаем новый crontab
+	cmd := exec.Command("crontab", tempFile.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ошибка установки нового crontab: %v %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CRON_DAEMONS - имена процессов демона cron в разных дистрибутивах
+var CRON_DAEMONS = []string{"cron", "crond", "cronie", "busybox-crond"}
+
+// cronDaemonRunning ищет процесс демона cron в /proc
+func cronDaemonRunning() bool {
+	comms, _ := filepath.Glob("/proc/[0-9]*/comm")
+	for _, comm := range comms {
+		name, err := os.ReadFile(comm)
+		if err != nil {
+			continue
+		}
+		for _, daemon := range CRON_DAEMONS {
+			if strings.TrimSpace(string(name)) == daemon {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitCrontab делит crontab на строки, содержащие match, и все остальные
+func splitCrontab(crontab, match string) (matched, rest []string) {
+	return splitCrontabFunc(crontab, func(line string) bool {
+		return strings.Contains(line, match)
+	})
+}
+
+// splitCrontabFunc делит crontab на строки, для которых match возвращает true, и все остальные
+func splitCrontabFunc(crontab string, match func(line string) bool) (matched, rest []string) {
+	crontab = strings.TrimRight(crontab, "\n")
+	if crontab == "" {
+		return nil, nil
+	}
+	for _, line := range strings.Split(crontab, "\n") {
+		if match(line) {
+			matched = append(matched, line)
+		} else {
+			rest = append(rest, line)
+		}
+	}
+	return matched, rest
+}
+
+// joinCrontab собирает crontab из строк, гарантируя перевод строки в конце
+func joinCrontab(lines []string) string {
+	content := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if content == "" {
+		return ""
+	}
+	return content + "\n"
+}
+
+// ValidateSchedule проверяет, что расписание похоже на выражение cron из пяти полей
+func ValidateSchedule(schedule string) error {
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return fmt.Errorf("расписание %q должно состоять из 5 полей cron", schedule)
+	}
+	for _, field := range fields {
+		for _, r := range field {
+			if !strings.ContainsRune("0123456789*/,-", r) {
+				return fmt.Errorf("недопустимый символ %q в расписании %q", r, schedule)
+			}
+		}
+	}
+	return nil
+}
+
+// CronSchedule строит расписание cron для интервала запусков. Интервал должен делить
+// час (1m, 5m, 15m...) или сутки (1h, 2h, 6h, 24h), иначе cron не может его выразить
+func CronSchedule(interval time.Duration) (string, error) {
+	switch {
+	case interval < time.Minute || interval%time.Minute != 0:
+		return "", fmt.Errorf("интервал запусков %v должен быть целым числом минут", interval)
+	case interval == time.Minute:
+		return "* * * * *", nil
+	case interval < time.Hour && time.Hour%interval == 0:
+		return fmt.Sprintf("*/%d * * * *", int(interval/time.Minute)), nil
+	case interval == time.Hour:
+		return "0 * * * *", nil
+	case interval < 24*time.Hour && interval%time.Hour == 0 && (24*time.Hour)%interval == 0:
+		return fmt.Sprintf("0 */%d * * *", int(interval/time.Hour)), nil
+	case interval == 24*time.Hour:
+		return "0 0 * * *", nil
+	}
+	return "", fmt.Errorf("интервал запусков %v должен делить час или сутки без остатка", interval)
+}
+
+// profileOf возвращает профиль из строки запуска архиватора: значение --profile или default
+func profileOf(line string) string {
+	fields := strings.Fields(line)
+	for n, field := range fields {
+		if strings.HasPrefix(field, "--profile=") {
+			return strings.TrimPrefix(field, "--profile=")
+		}
+		if field == "--profile" && n+1 < len(fields) {
+			return fields[n+1]
+		}
+	}
+	return config.DEFAULT_PROFILE
+}
+
+// scheduleOf возвращает расписание (первые 5 полей) из строки crontab
+func scheduleOf(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return ""
+	}
+	return strings.Join(fields[:5], " ")
+}
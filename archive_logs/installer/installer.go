@@ -1,255 +1,375 @@
 package installer
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"strings"
+	"time"
+
+	"xui_log_archiver/archiver"
+	"xui_log_archiver/config"
 )
 
 const (
-	SCRIPT_PATH     = "/usr/local/bin/xui_log_archiver"
-	ARCHIVE_DIR     = "/usr/local/x-ui/archives"
-	STATE_FILE      = "/usr/local/x-ui/last_archived_line.txt"
-	TEMP_HOURLY_LOG = "/usr/local/x-ui/temp_hourly_archive.log"
+	SCRIPT_PATH = "/usr/local/bin/xui_log_archiver"
+
+	// SCHEDULER_CRON и SCHEDULER_SYSTEMD - способы запуска archive по расписанию
+	SCHEDULER_CRON    = "cron"
+	SCHEDULER_SYSTEMD = "systemd"
+
+	// DEFAULT_SCHEDULE - расписание cron по умолчанию: каждые 10 минут
+	DEFAULT_SCHEDULE = "*/10 * * * *"
+	// DEFAULT_INTERVAL - интервал запусков, которому соответствует DEFAULT_SCHEDULE
+	DEFAULT_INTERVAL = "10m"
 )
 
 // Installer управляет установкой и удалением автозапуска
 type Installer struct {
 	scriptPath string
+	schedule   string
+	interval   time.Duration
+	scheduler  string
+	profile    string
+	paths      archiver.Paths
+	out        io.Writer
+}
+
+// Result описывает, что изменила операция установщика. Changed=false означает,
+// что система уже была в нужном состоянии - это нужно системам управления конфигурацией
+type Result struct {
+	Changed bool     `json:"changed"`
+	DryRun  bool     `json:"dry_run,omitempty"`
+	Actions []string `json:"actions"`
+}
+
+func (r *Result) add(action string) {
+	r.Changed = true
+	r.Actions = append(r.Actions, action)
 }
 
 // New создает новый экземпляр установщика
 func New() *Installer {
 	return &Installer{
 		scriptPath: SCRIPT_PATH,
+		schedule:   DEFAULT_SCHEDULE,
+		interval:   10 * time.Minute,
+		scheduler:  SCHEDULER_CRON,
+		profile:    config.DEFAULT_PROFILE,
+		paths:      archiver.DefaultPaths(),
+		out:        os.Stdout,
 	}
 }
 
-// InstallAutostart устанавливает автозапуск
-func (i *Installer) InstallAutostart() error {
-	fmt.Println("Установка автозапуска...")
+// SetOutput задает, куда выводятся сообщения для пользователя (по умолчанию stdout)
+func (i *Installer) SetOutput(w io.Writer) {
+	i.out = w
+}
 
-	// Копируем текущую программу в /usr/local/bin/
-	if err := i.copySelfToBin(); err != nil {
-		return fmt.Errorf("ошибка копирования программы: %v", err)
-	}
+// SetBinaryPath задает путь, куда устанавливается программа и который вызывает cron
+func (i *Installer) SetBinaryPath(path string) {
+	i.scriptPath = path
+}
 
-	// Создаем необходимые директории и файлы
-	if err := i.createDirectoriesAndFiles(); err != nil {
-		return fmt.Errorf("ошибка создания директорий и файлов: %v", err)
+// SetProfile задает профиль экземпляра x-ui: его файлы и отдельную задачу в cron или systemd
+func (i *Installer) SetProfile(name string, paths archiver.Paths) {
+	i.profile = name
+	i.paths = paths
+}
+
+// SetScheduler выбирает способ запуска по расписанию: cron или systemd
+func (i *Installer) SetScheduler(scheduler string) error {
+	if scheduler != SCHEDULER_CRON && scheduler != SCHEDULER_SYSTEMD {
+		return fmt.Errorf("неизвестный планировщик %q: ожидается %s или %s", scheduler, SCHEDULER_CRON, SCHEDULER_SYSTEMD)
 	}
+	i.scheduler = scheduler
+	return nil
+}
 
-	// Добавляем задачу в cron
-	if err := i.addCronJob(); err != nil {
-		return fmt.Errorf("ошибка добавления задачи в cron: %v", err)
+// SetSchedule задает произвольное расписание cron. Для systemd нужен SetInterval
+func (i *Installer) SetSchedule(schedule string) error {
+	if err := ValidateSchedule(schedule); err != nil {
+		return err
 	}
+	i.schedule = strings.Join(strings.Fields(schedule), " ")
+	i.interval = 0
+	return nil
+}
 
-	fmt.Println("✅ Автозапуск установлен успешно!")
-	fmt.Println("📅 Программа будет выполняться каждые 10 минут")
-	fmt.Println("📦 Часовые архивы будут создаваться в 00 минут каждого часа")
-	fmt.Printf("📁 Архивы сохраняются в: %s\n", ARCHIVE_DIR)
-	fmt.Printf("📋 Лог работы: %s/archive.log\n", ARCHIVE_DIR)
+// SetInterval задает расписание cron по интервалу запусков, например 15m или 1h
+func (i *Installer) SetInterval(interval time.Duration) error {
+	schedule, err := CronSchedule(interval)
+	if err != nil {
+		return err
+	}
+	i.schedule = schedule
+	i.interval = interval
 	return nil
 }
 
-// RemoveAutostart удаляет автозапуск
-func (i *Installer) RemoveAutostart() error {
-	fmt.Println("Удаление автозапуска...")
+// archiveCommand возвращает команду запуска архивирования для профиля
+func (i *Installer) archiveCommand() string {
+	if i.profile == config.DEFAULT_PROFILE {
+		return fmt.Sprintf("%s archive", i.scriptPath)
+	}
+	return fmt.Sprintf("%s archive --profile %s", i.scriptPath, i.profile)
+}
 
-	// Получаем текущий crontab
-	cmd := exec.Command("crontab", "-l")
-	output, err := cmd.Output()
-	if err != nil && !strings.Contains(err.Error(), "no crontab") {
-		return fmt.Errorf("ошибка получения crontab: %v", err)
+// CronEntry возвращает строку crontab, которую создает установщик
+func (i *Installer) CronEntry() string {
+	return fmt.Sprintf("%s %s", i.schedule, i.archiveCommand())
+}
+
+// ownsCronLine проверяет, что строка crontab запускает программу для профиля установщика
+func (i *Installer) ownsCronLine(line string) bool {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return false
+	}
+	for _, field := range strings.Fields(line) {
+		if field == i.scriptPath {
+			return profileOf(line) == i.profile
+		}
 	}
+	return false
+}
 
-	currentCrontab := string(output)
+// Install приводит систему к установленному состоянию. Повторный вызов ничего не меняет
+func (i *Installer) Install() (Result, error) {
+	result := Result{Actions: []string{}}
 
-	// Проверяем, есть ли наша задача
-	if !strings.Contains(currentCrontab, i.scriptPath) {
-		fmt.Println("❌ Автозапуск не найден в crontab")
-		return nil
+	// Переводим установку bash-архиватора, если она есть, до появления новой задачи cron.
+	// Bash-архиватор работал только с /usr/local/x-ui, то есть с профилем по умолчанию
+	if i.profile == config.DEFAULT_PROFILE {
+		if err := i.migrateLegacy(&result); err != nil {
+			return result, fmt.Errorf("ошибка миграции bash-архиватора: %v", err)
+		}
 	}
 
-	// Удаляем нашу задачу
-	lines := strings.Split(currentCrontab, "\n")
-	var newLines []string
-	for _, line := range lines {
-		if !strings.Contains(line, i.scriptPath) {
-			newLines = append(newLines, line)
+	// Копируем текущую программу в /usr/local/bin/
+	if err := i.copySelfToBin(&result); err != nil {
+		return result, fmt.Errorf("ошибка копирования программы: %v", err)
+	}
+
+	// Создаем необходимые директории и файлы
+	if err := i.createDirectoriesAndFiles(&result); err != nil {
+		return result, fmt.Errorf("ошибка создания директорий и файлов: %v", err)
+	}
+
+	// Добавляем задачу в выбранный планировщик и убираем из другого, если профиль переносится
+	if i.scheduler == SCHEDULER_SYSTEMD {
+		if err := i.installSystemd(&result); err != nil {
+			return result, fmt.Errorf("ошибка установки таймера systemd: %v", err)
 		}
+		if err := i.removeCronJob(&result); err != nil {
+			return result, fmt.Errorf("ошибка удаления задачи cron: %v", err)
+		}
+		return result, nil
 	}
 
-	newCrontab := strings.Join(newLines, "\n")
+	if err := i.addCronJob(&result); err != nil {
+		return result, fmt.Errorf("ошибка добавления задачи в cron: %v", err)
+	}
+	if err := i.removeSystemd(&result); err != nil {
+		return result, fmt.Errorf("ошибка удаления таймера systemd: %v", err)
+	}
+	return result, nil
+}
 
-	// Создаем временный файл с новым crontab
-	tempFile, err := os.CreateTemp("", "crontab_*")
-	if err != nil {
-		return fmt.Errorf("ошибка создания временного файла: %v", err)
+// Uninstall удаляет задачу профиля из crontab и таймер systemd. Если их нет, ничего не меняет
+func (i *Installer) Uninstall() (Result, error) {
+	result := Result{Actions: []string{}}
+	if err := i.removeCronJob(&result); err != nil {
+		return result, err
 	}
-	defer os.Remove(tempFile.Name())
+	if err := i.removeSystemd(&result); err != nil {
+		return result, err
+	}
+	return result, nil
+}
 
-	if _, err := tempFile.WriteString(newCrontab); err != nil {
-		tempFile.Close()
-		return fmt.Errorf("ошибка записи в временный файл: %v", err)
+// removeCronJob удаляет из crontab задачи профиля
+func (i *Installer) removeCronJob(result *Result) error {
+	// Получаем текущий crontab
+	currentCrontab, err := readCrontab()
+	if err != nil {
+		return err
 	}
-	tempFile.Close()
 
-	// Устанавливаем новый crontab
-	cmd = exec.Command("crontab", tempFile.Name())
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ошибка установки нового crontab: %v", err)
+	// Проверяем, есть ли наша задача
+	matched, rest := splitCrontabFunc(currentCrontab, i.ownsCronLine)
+	if len(matched) == 0 {
+		return nil
 	}
 
-	fmt.Println("✅ Автозапуск удален успешно!")
+	// Удаляем нашу задачу
+	if err := writeCrontab(joinCrontab(rest)); err != nil {
+		return err
+	}
+	for _, line := range matched {
+		result.add(fmt.Sprintf("удалена задача cron: %s", line))
+	}
 	return nil
 }
 
-// ShowAutostartStatus показывает статус автозапуска
-func (i *Installer) ShowAutostartStatus() {
+// Status описывает состояние установки
+type Status struct {
+	Profile            string `json:"profile"`
+	Installed          bool   `json:"installed"`
+	Scheduler          string `json:"scheduler,omitempty"`
+	Schedule           string `json:"schedule,omitempty"`
+	CronEntry          string `json:"cron_entry,omitempty"`
+	BinaryPath         string `json:"binary_path"`
+	BinaryExists       bool   `json:"binary_exists"`
+	ArchiveDir         string `json:"archive_dir"`
+	ArchiveDirExists   bool   `json:"archive_dir_exists"`
+	PositionFile       string `json:"position_file"`
+	PositionFileExists bool   `json:"position_file_exists"`
+}
+
+// Status возвращает состояние автозапуска и файлов архиватора
+func (i *Installer) Status() (Status, error) {
+	status := Status{
+		Profile:      i.profile,
+		BinaryPath:   i.scriptPath,
+		ArchiveDir:   i.paths.ArchiveDir,
+		PositionFile: i.paths.PositionFile,
+	}
+
 	// Получаем текущий crontab
-	cmd := exec.Command("crontab", "-l")
-	output, err := cmd.Output()
-	if err != nil && !strings.Contains(err.Error(), "no crontab") {
-		fmt.Printf("Ошибка получения crontab: %v\n", err)
-		return
-	}
-
-	currentCrontab := string(output)
-
-	if strings.Contains(currentCrontab, i.scriptPath) {
-		fmt.Println("✅ Автозапуск активен")
-		// Показываем строку из crontab
-		lines := strings.Split(currentCrontab, "\n")
-		for _, line := range lines {
-			if strings.Contains(line, i.scriptPath) {
-				fmt.Printf("📅 Расписание: %s\n", line)
-				break
-			}
-		}
-	} else {
-		fmt.Println("❌ Автозапуск не настроен")
+	currentCrontab, err := readCrontab()
+	if err != nil {
+		return status, err
 	}
 
-	// Показываем информацию о файлах
-	fmt.Printf("📁 Директория архивов: %s\n", ARCHIVE_DIR)
-	if _, err := os.Stat(ARCHIVE_DIR); err == nil {
-		fmt.Println("✅ Директория архивов существует")
-	} else {
-		fmt.Println("❌ Директория архивов не существует")
+	if matched, _ := splitCrontabFunc(currentCrontab, i.ownsCronLine); len(matched) > 0 {
+		status.Installed = true
+		status.Scheduler = SCHEDULER_CRON
+		status.CronEntry = matched[0]
+		status.Schedule = scheduleOf(matched[0])
+	} else if onCalendar, ok := i.systemdSchedule(); ok {
+		status.Installed = true
+		status.Scheduler = SCHEDULER_SYSTEMD
+		status.CronEntry = i.unitName() + ".timer"
+		status.Schedule = onCalendar
 	}
 
-	fmt.Printf("📄 Файл состояния: %s\n", STATE_FILE)
-	if _, err := os.Stat(STATE_FILE); err == nil {
-		fmt.Println("✅ Файл состояния существует")
-	} else {
-		fmt.Println("❌ Файл состояния не существует")
+	status.BinaryExists = fileExists(i.scriptPath)
+	status.ArchiveDirExists = fileExists(i.paths.ArchiveDir)
+	status.PositionFileExists = fileExists(i.paths.PositionFile)
+	return status, nil
+}
+
+// SchedulerActive проверяет, что планировщик работает: для cron - запущен демон cron,
+// для systemd - активен таймер профиля
+func (i *Installer) SchedulerActive(scheduler string) bool {
+	if scheduler == SCHEDULER_SYSTEMD {
+		return systemdActive(i.unitName() + ".timer")
 	}
+	return cronDaemonRunning()
 }
 
-func (i *Installer) copySelfToBin() error {
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (i *Installer) copySelfToBin(result *Result) error {
 	// Получаем путь к текущему исполняемому файлу
 	execPath, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("ошибка получения пути к программе: %v", err)
 	}
 
-	// Копируем файл
-	if err := i.copyFile(execPath, i.scriptPath); err != nil {
-		return fmt.Errorf("ошибка копирования файла: %v", err)
-	}
-
-	// Устанавливаем права на выполнение
-	if err := os.Chmod(i.scriptPath, 0755); err != nil {
-		return fmt.Errorf("ошибка установки прав на выполнение: %v", err)
-	}
-
-	fmt.Printf("✅ Программа скопирована в %s\n", i.scriptPath)
-	return nil
+	return i.installBinary(execPath, result)
 }
 
-func (i *Installer) createDirectoriesAndFiles() error {
+func (i *Installer) createDirectoriesAndFiles(result *Result) error {
 	// Создаем директорию для архивов
-	if err := os.MkdirAll(ARCHIVE_DIR, 0755); err != nil {
-		return fmt.Errorf("ошибка создания директории %s: %v", ARCHIVE_DIR, err)
+	if !fileExists(i.paths.ArchiveDir) {
+		if err := os.MkdirAll(i.paths.ArchiveDir, 0755); err != nil {
+			return fmt.Errorf("ошибка создания директории %s: %v", i.paths.ArchiveDir, err)
+		}
+		result.add(fmt.Sprintf("создана директория %s", i.paths.ArchiveDir))
 	}
 
 	// Создаем файл состояния, если его нет
-	if _, err := os.Stat(STATE_FILE); os.IsNotExist(err) {
-		if err := os.WriteFile(STATE_FILE, []byte("0"), 0644); err != nil {
+	if _, err := os.Stat(i.paths.StateFile); os.IsNotExist(err) {
+		if err := os.WriteFile(i.paths.StateFile, []byte("0"), 0644); err != nil {
 			return fmt.Errorf("ошибка создания файла состояния: %v", err)
 		}
-		fmt.Printf("✅ Создан файл состояния: %s\n", STATE_FILE)
+		fmt.Fprintf(i.out, "✅ Создан файл состояния: %s\n", i.paths.StateFile)
+		result.add(fmt.Sprintf("создан файл %s", i.paths.StateFile))
 	}
 
 	// Создаем временный файл-накопитель, если его нет
-	if _, err := os.Stat(TEMP_HOURLY_LOG); os.IsNotExist(err) {
-		if err := os.WriteFile(TEMP_HOURLY_LOG, []byte(""), 0644); err != nil {
+	if _, err := os.Stat(i.paths.TempHourlyLog); os.IsNotExist(err) {
+		if err := os.WriteFile(i.paths.TempHourlyLog, []byte(""), 0644); err != nil {
 			return fmt.Errorf("ошибка создания временного файла: %v", err)
 		}
-		fmt.Printf("✅ Создан временный файл-накопитель: %s\n", TEMP_HOURLY_LOG)
+		fmt.Fprintf(i.out, "✅ Создан временный файл-накопитель: %s\n", i.paths.TempHourlyLog)
+		result.add(fmt.Sprintf("создан файл %s", i.paths.TempHourlyLog))
 	}
 
 	return nil
 }
 
-func (i *Installer) addCronJob() error {
+func (i *Installer) addCronJob(result *Result) error {
 	// Получаем текущий crontab
-	cmd := exec.Command("crontab", "-l")
-	output, err := cmd.Output()
-	if err != nil && !strings.Contains(err.Error(), "no crontab") {
-		return fmt.Errorf("ошибка получения текущего crontab: %v", err)
+	currentCrontab, err := readCrontab()
+	if err != nil {
+		return err
 	}
 
-	currentCrontab := string(output)
+	entry := i.CronEntry()
+	matched, rest := splitCrontabFunc(currentCrontab, i.ownsCronLine)
 
-	// Проверяем, есть ли уже наша задача
-	if strings.Contains(currentCrontab, i.scriptPath) {
-		fmt.Println("⚠️  Задача уже существует в crontab")
+	// Задача уже есть и совпадает с нужной - ничего не меняем
+	if len(matched) == 1 && strings.TrimSpace(matched[0]) == entry {
+		fmt.Fprintln(i.out, "⚠️  Задача уже существует в crontab")
 		return nil
 	}
 
-	// Добавляем новую задачу
-	newCronEntry := fmt.Sprintf("*/10 * * * * %s --cron\n", i.scriptPath)
-	newCrontab := currentCrontab + newCronEntry
-
-	// Создаем временный файл с новым crontab
-	tempFile, err := os.CreateTemp("", "crontab_*")
-	if err != nil {
-		return fmt.Errorf("ошибка создания временного файла: %v", err)
-	}
-	defer os.Remove(tempFile.Name())
-
-	if _, err := tempFile.WriteString(newCrontab); err != nil {
-		tempFile.Close()
-		return fmt.Errorf("ошибка записи в временный файл: %v", err)
+	// Старые или отличающиеся задачи заменяем одной новой
+	newCrontab := joinCrontab(append(rest, entry))
+	if err := writeCrontab(newCrontab); err != nil {
+		return err
 	}
-	tempFile.Close()
 
-	// Устанавливаем новый crontab
-	cmd = exec.Command("crontab", tempFile.Name())
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ошибка установки нового crontab: %v", err)
+	if len(matched) > 0 {
+		fmt.Fprintf(i.out, "✅ Задача в crontab обновлена: %s\n", entry)
+		result.add(fmt.Sprintf("задача cron обновлена: %s", entry))
+	} else {
+		fmt.Fprintf(i.out, "✅ Задача добавлена в crontab: %s\n", entry)
+		result.add(fmt.Sprintf("задача cron добавлена: %s", entry))
 	}
-
-	fmt.Println("✅ Задача добавлена в crontab: каждые 10 минут")
 	return nil
 }
 
-func (i *Installer) copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+// sameContent сравнивает содержимое двух файлов по SHA-256
+func sameContent(a, b string) (bool, error) {
+	hashA, err := fileHash(a)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer sourceFile.Close()
+	hashB, err := fileHash(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(hashA, hashB), nil
+}
 
-	destFile, err := os.Create(dst)
+func fileHash(path string) ([]byte, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer destFile.Close()
+	defer file.Close()
 
-	_, err = destFile.ReadFrom(sourceFile)
-	return err
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
 }
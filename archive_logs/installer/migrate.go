@@ -0,0 +1,148 @@
+package installer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LEGACY_SCRIPT_PATH - куда sh/install_archiver_hourly.sh устанавливал bash-архиватор
+	LEGACY_SCRIPT_PATH = "/usr/local/bin/archive_3xui_logs.sh"
+)
+
+// LegacyInstall описывает найденную установку bash-архиватора
+type LegacyInstall struct {
+	CronEntries  []string `json:"cron_entries,omitempty"`
+	ScriptExists bool     `json:"script_exists"`
+	// StateLine - номер последней обработанной строки из last_archived_line.txt
+	StateLine int64 `json:"state_line"`
+}
+
+// Found сообщает, есть ли следы bash-архиватора
+func (l LegacyInstall) Found() bool {
+	return len(l.CronEntries) > 0 || l.ScriptExists
+}
+
+// DetectLegacy ищет задачу cron и скрипт bash-архиватора и номер строки в файле состояния профиля
+func (i *Installer) DetectLegacy() (LegacyInstall, error) {
+	var legacy LegacyInstall
+
+	currentCrontab, err := readCrontab()
+	if err != nil {
+		return legacy, err
+	}
+	legacy.CronEntries, _ = splitCrontab(currentCrontab, LEGACY_SCRIPT_PATH)
+	legacy.ScriptExists = fileExists(LEGACY_SCRIPT_PATH)
+	legacy.StateLine = readLegacyStateLine(i.paths.StateFile)
+	return legacy, nil
+}
+
+// migrateLegacy переводит установку bash-архиватора на Go-архиватор: убирает старую задачу cron,
+// пересчитывает номер строки в смещение в байтах и оставляет накопитель как есть,
+// чтобы строки не потерялись и не попали в архив дважды
+func (i *Installer) migrateLegacy(result *Result) error {
+	legacy, err := i.DetectLegacy()
+	if err != nil {
+		return err
+	}
+	if !legacy.Found() {
+		return nil
+	}
+	fmt.Fprintln(i.out, "🔄 Найдена установка bash-архиватора, выполняется миграция")
+
+	// Сначала убираем старую задачу, чтобы bash-скрипт больше не сдвигал номер строки
+	if len(legacy.CronEntries) > 0 {
+		currentCrontab, err := readCrontab()
+		if err != nil {
+			return err
+		}
+		_, rest := splitCrontab(currentCrontab, LEGACY_SCRIPT_PATH)
+		if err := writeCrontab(joinCrontab(rest)); err != nil {
+			return err
+		}
+		for _, line := range legacy.CronEntries {
+			fmt.Fprintf(i.out, "✅ Удалена задача bash-архиватора: %s\n", line)
+			result.add(fmt.Sprintf("удалена задача cron bash-архиватора: %s", line))
+		}
+		// Номер строки перечитываем: скрипт мог успеть отработать еще раз
+		legacy.StateLine = readLegacyStateLine(i.paths.StateFile)
+	}
+
+	// Если Go-архиватор уже запускался, его позиция точнее номера строки
+	if !fileExists(i.paths.PositionFile) {
+		offset, err := lineOffset(i.paths.LogFile, legacy.StateLine)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("ошибка пересчета позиции в %s: %v", i.paths.LogFile, err)
+		}
+		if err := os.WriteFile(i.paths.PositionFile, []byte(strconv.FormatInt(offset, 10)), 0644); err != nil {
+			return fmt.Errorf("ошибка записи позиции: %v", err)
+		}
+		fmt.Fprintf(i.out, "✅ Строка %d пересчитана в позицию %d байт\n", legacy.StateLine, offset)
+		result.add(fmt.Sprintf("строка %d из %s пересчитана в позицию %d байт в %s", legacy.StateLine, i.paths.StateFile, offset, i.paths.PositionFile))
+	}
+
+	// Накопитель у обоих архиваторов общий, Go-архиватор продолжит его заполнять
+	if info, err := os.Stat(i.paths.TempHourlyLog); err == nil && info.Size() > 0 {
+		fmt.Fprintf(i.out, "✅ Накопитель %s сохранен (%d байт)\n", i.paths.TempHourlyLog, info.Size())
+		result.add(fmt.Sprintf("накопитель %s сохранен (%d байт)", i.paths.TempHourlyLog, info.Size()))
+	}
+
+	if legacy.ScriptExists {
+		if err := os.Remove(LEGACY_SCRIPT_PATH); err != nil {
+			return fmt.Errorf("ошибка удаления %s: %v", LEGACY_SCRIPT_PATH, err)
+		}
+		fmt.Fprintf(i.out, "✅ Удален скрипт bash-архиватора: %s\n", LEGACY_SCRIPT_PATH)
+		result.add(fmt.Sprintf("удален скрипт %s", LEGACY_SCRIPT_PATH))
+	}
+	return nil
+}
+
+// readLegacyStateLine читает номер строки так же, как bash-скрипт: не число означает 0
+func readLegacyStateLine(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	line, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || line < 0 {
+		return 0
+	}
+	return line
+}
+
+// lineOffset возвращает смещение в байтах сразу после строки с номером line.
+// Bash-скрипт считал строки через wc -l, то есть по символам перевода строки.
+// Если строк в файле меньше, файл был очищен и скрипт начал бы с начала - возвращается 0
+func lineOffset(path string, line int64) (int64, error) {
+	if line == 0 {
+		return 0, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	var offset, lines int64
+	for lines < line {
+		chunk, err := reader.ReadSlice('\n')
+		offset += int64(len(chunk))
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		lines++
+	}
+	return offset, nil
+}
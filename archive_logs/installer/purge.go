@@ -0,0 +1,129 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"xui_log_archiver/archiver"
+)
+
+// Sealer запечатывает незаархивированные данные перед удалением файлов состояния
+type Sealer interface {
+	// SealPending сохраняет накопитель в архив и возвращает путь к архиву (пустой, если данных нет)
+	SealPending() (string, error)
+	// PendingBytes возвращает размер данных, которые еще не попали в архив
+	PendingBytes() int64
+}
+
+// PurgeOptions задает, что удаляет полное удаление архиватора
+type PurgeOptions struct {
+	// PurgeArchives удаляет также директорию архивов. По умолчанию архивы сохраняются
+	PurgeArchives bool
+	// DryRun только перечисляет действия, ничего не меняя
+	DryRun bool
+	// Sealer запечатывает накопитель перед удалением состояния. Может быть nil
+	Sealer Sealer
+}
+
+// stateFiles - файлы состояния профиля, которые удаляет полное удаление
+func (i *Installer) stateFiles() []string {
+	files := []string{i.paths.StateFile, i.paths.PositionFile, i.paths.TempHourlyLog, i.paths.RunStateFile, i.paths.HistoryFile, i.paths.LockFile}
+	for n := 1; n <= archiver.HISTORY_BACKUPS; n++ {
+		files = append(files, fmt.Sprintf("%s.%d", i.paths.HistoryFile, n))
+	}
+	return files
+}
+
+// Purge полностью удаляет архиватор профиля: задачу cron или таймер systemd, накопленные
+// данные запечатывает в архив, затем удаляет файлы состояния и программу, если ее не
+// запускают другие профили. Архивы удаляются только с PurgeArchives
+func (i *Installer) Purge(opts PurgeOptions) (Result, error) {
+	result := Result{Actions: []string{}, DryRun: opts.DryRun}
+
+	// Сначала убираем задачу cron, чтобы запуск по расписанию не начался во время удаления
+	currentCrontab, err := readCrontab()
+	if err != nil {
+		return result, err
+	}
+	matched, rest := splitCrontabFunc(currentCrontab, i.ownsCronLine)
+	for _, line := range matched {
+		result.add(fmt.Sprintf("удаление задачи cron: %s", line))
+	}
+	if len(matched) > 0 && !opts.DryRun {
+		if err := writeCrontab(joinCrontab(rest)); err != nil {
+			return result, err
+		}
+	}
+	service, timer := i.unitPaths()
+	if fileExists(service) || fileExists(timer) {
+		if opts.DryRun {
+			result.add(fmt.Sprintf("отключение таймера %s.timer и удаление его юнитов", i.unitName()))
+		} else if err := i.removeSystemd(&result); err != nil {
+			return result, err
+		}
+	}
+
+	// Запечатываем накопитель: без этого последние минуты логов пропадут вместе с состоянием
+	if opts.Sealer != nil && !opts.PurgeArchives {
+		if pending := opts.Sealer.PendingBytes(); pending > 0 {
+			if opts.DryRun {
+				result.add(fmt.Sprintf("запечатывание в архив %d байт накопителя %s и необработанных строк access.log", pending, i.paths.TempHourlyLog))
+			} else {
+				archive, err := opts.Sealer.SealPending()
+				if err != nil {
+					return result, fmt.Errorf("ошибка запечатывания накопителя, файлы состояния сохранены: %v", err)
+				}
+				if archive != "" {
+					result.add(fmt.Sprintf("накопитель запечатан в %s", archive))
+				}
+			}
+		}
+	}
+
+	// Удаляем файлы состояния, а программу и ее предыдущую версию - только если ее
+	// не запускают другие профили
+	files := i.stateFiles()
+	shared := false
+	for _, line := range rest {
+		if strings.Contains(line, i.scriptPath) {
+			shared = true
+		}
+	}
+	if shared || i.systemdUnitsUsing() {
+		result.add(fmt.Sprintf("программа %s сохранена: ее используют другие профили", i.scriptPath))
+	} else {
+		files = append([]string{i.scriptPath, i.backupPath()}, files...)
+	}
+	for _, path := range files {
+		if !fileExists(path) {
+			continue
+		}
+		result.add(fmt.Sprintf("удаление файла %s", path))
+		if opts.DryRun {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return result, fmt.Errorf("ошибка удаления %s: %v", path, err)
+		}
+	}
+
+	// Архивы удаляем только по явному запросу
+	if opts.PurgeArchives && fileExists(i.paths.ArchiveDir) {
+		result.add(fmt.Sprintf("удаление директории архивов %s со всем содержимым", i.paths.ArchiveDir))
+		if !opts.DryRun {
+			if err := os.RemoveAll(i.paths.ArchiveDir); err != nil {
+				return result, fmt.Errorf("ошибка удаления %s: %v", i.paths.ArchiveDir, err)
+			}
+		}
+	}
+
+	for _, action := range result.Actions {
+		if opts.DryRun {
+			fmt.Fprintf(i.out, "🔍 %s\n", action)
+		} else {
+			fmt.Fprintf(i.out, "✅ %s\n", action)
+		}
+	}
+	return result, nil
+}
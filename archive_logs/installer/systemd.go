@@ -0,0 +1,197 @@
+package installer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"xui_log_archiver/config"
+)
+
+const (
+	// SYSTEMD_DIR - директория, в которую устанавливаются юниты таймера
+	SYSTEMD_DIR = "/etc/systemd/system"
+
+	// UNIT_NAME - имя юнитов архиватора для профиля по умолчанию
+	UNIT_NAME = "xui-log-archiver"
+)
+
+// unitName возвращает имя юнитов systemd профиля без расширения
+func (i *Installer) unitName() string {
+	if i.profile == config.DEFAULT_PROFILE {
+		return UNIT_NAME
+	}
+	return UNIT_NAME + "-" + i.profile
+}
+
+// unitPaths возвращает пути файлов service и timer профиля
+func (i *Installer) unitPaths() (service, timer string) {
+	base := filepath.Join(SYSTEMD_DIR, i.unitName())
+	return base + ".service", base + ".timer"
+}
+
+// OnCalendar строит выражение OnCalendar для интервала запусков. Ограничения те же, что у CronSchedule
+func OnCalendar(interval time.Duration) (string, error) {
+	if _, err := CronSchedule(interval); err != nil {
+		return "", err
+	}
+	switch {
+	case interval == time.Minute:
+		return "*-*-* *:*:00", nil
+	case interval < time.Hour:
+		return fmt.Sprintf("*-*-* *:00/%d:00", int(interval/time.Minute)), nil
+	case interval == time.Hour:
+		return "*-*-* *:00:00", nil
+	case interval < 24*time.Hour:
+		return fmt.Sprintf("*-*-* 00/%d:00:00", int(interval/time.Hour)), nil
+	}
+	return "*-*-* 00:00:00", nil
+}
+
+// serviceUnit возвращает содержимое юнита, выполняющего один запуск архивирования
+func (i *Installer) serviceUnit() string {
+	return fmt.Sprintf(`[Unit]
+Description=3x-ui access.log archiver (%s)
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, i.profile, i.archiveCommand())
+}
+
+// timerUnit возвращает содержимое таймера, запускающего архивирование по расписанию
+func (i *Installer) timerUnit(onCalendar string) string {
+	return fmt.Sprintf(`[Unit]
+Description=3x-ui access.log archiver timer (%s)
+
+[Timer]
+OnCalendar=%s
+AccuracySec=1s
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, i.profile, onCalendar)
+}
+
+// installSystemd создает или обновляет юниты профиля и включает таймер
+func (i *Installer) installSystemd(result *Result) error {
+	if i.interval == 0 {
+		return fmt.Errorf("для systemd расписание задается интервалом, произвольное расписание cron не поддерживается")
+	}
+	onCalendar, err := OnCalendar(i.interval)
+	if err != nil {
+		return err
+	}
+
+	service, timer := i.unitPaths()
+	changed := false
+	for path, content := range map[string]string{service: i.serviceUnit(), timer: i.timerUnit(onCalendar)} {
+		current, err := os.ReadFile(path)
+		if err == nil && bytes.Equal(current, []byte(content)) {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return fmt.Errorf("ошибка записи %s: %v", path, err)
+		}
+		result.add(fmt.Sprintf("записан юнит %s", path))
+		changed = true
+	}
+
+	if changed {
+		if err := systemctl("daemon-reload"); err != nil {
+			return err
+		}
+	}
+	if !systemdActive(i.unitName() + ".timer") {
+		if err := systemctl("enable", "--now", i.unitName()+".timer"); err != nil {
+			return err
+		}
+		result.add(fmt.Sprintf("включен таймер %s.timer", i.unitName()))
+	} else if changed {
+		if err := systemctl("restart", i.unitName()+".timer"); err != nil {
+			return err
+		}
+	}
+
+	if changed {
+		fmt.Fprintf(i.out, "✅ Таймер systemd установлен: %s.timer (%s)\n", i.unitName(), onCalendar)
+	} else {
+		fmt.Fprintf(i.out, "ℹ️  Таймер systemd уже установлен: %s.timer\n", i.unitName())
+	}
+	return nil
+}
+
+// removeSystemd отключает таймер профиля и удаляет его юниты. Если юнитов нет, ничего не делает
+func (i *Installer) removeSystemd(result *Result) error {
+	service, timer := i.unitPaths()
+	if !fileExists(service) && !fileExists(timer) {
+		return nil
+	}
+
+	// Ошибку отключения не считаем фатальной: таймер мог быть не включен
+	systemctl("disable", "--now", i.unitName()+".timer")
+	for _, path := range []string{timer, service} {
+		if !fileExists(path) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("ошибка удаления %s: %v", path, err)
+		}
+		result.add(fmt.Sprintf("удален юнит %s", path))
+	}
+	return systemctl("daemon-reload")
+}
+
+// systemdSchedule возвращает OnCalendar установленного таймера профиля
+func (i *Installer) systemdSchedule() (string, bool) {
+	_, timer := i.unitPaths()
+	file, err := os.Open(timer)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "OnCalendar="); ok {
+			return value, true
+		}
+	}
+	return "", true
+}
+
+// systemdUnitsUsing проверяет, запускают ли юниты других профилей программу scriptPath
+func (i *Installer) systemdUnitsUsing() bool {
+	own, _ := i.unitPaths()
+	units, _ := filepath.Glob(filepath.Join(SYSTEMD_DIR, UNIT_NAME+"*.service"))
+	for _, unit := range units {
+		if unit == own {
+			continue
+		}
+		content, err := os.ReadFile(unit)
+		if err == nil && strings.Contains(string(content), "ExecStart="+i.scriptPath+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// systemctl выполняет команду systemctl и возвращает ее вывод в ошибке
+func systemctl(args ...string) error {
+	output, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ошибка systemctl %s: %v %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// systemdActive проверяет, что юнит запущен
+func systemdActive(unit string) bool {
+	return exec.Command("systemctl", "is-active", "--quiet", unit).Run() == nil
+}
@@ -0,0 +1,247 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"xui_log_archiver/version"
+)
+
+const (
+	// BACKUP_SUFFIX - суффикс копии предыдущей версии рядом с установленной программой
+	BACKUP_SUFFIX = ".prev"
+
+	// SELF_CHECK_TIMEOUT - сколько ждать ответа новой программы при самопроверке
+	SELF_CHECK_TIMEOUT = 15 * time.Second
+)
+
+// backupPath возвращает путь копии предыдущей версии
+func (i *Installer) backupPath() string {
+	return i.scriptPath + BACKUP_SUFFIX
+}
+
+// Update устанавливает программу из source: локального файла, директории с файлом
+// xui_log_archiver или URL вида file:///path. Предыдущая версия сохраняется для Rollback
+func (i *Installer) Update(source string) (Result, error) {
+	result := Result{Actions: []string{}}
+
+	src, err := resolveSource(source, filepath.Base(SCRIPT_PATH))
+	if err != nil {
+		return result, err
+	}
+
+	if err := i.installBinary(src, &result); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Rollback возвращает предыдущую версию программы. Текущая версия становится предыдущей,
+// поэтому повторный Rollback возвращает ее обратно
+func (i *Installer) Rollback() (Result, error) {
+	result := Result{Actions: []string{}}
+	backup := i.backupPath()
+
+	if !fileExists(backup) {
+		return result, fmt.Errorf("предыдущая версия не найдена: %s", backup)
+	}
+
+	// Текущую версию сохраняем под временным именем, чтобы путь программы не пропадал ни на миг
+	current := i.scriptPath + ".rollback"
+	os.Remove(current)
+	if fileExists(i.scriptPath) {
+		if err := linkOrCopy(i.scriptPath, current); err != nil {
+			return result, fmt.Errorf("ошибка сохранения текущей версии: %v", err)
+		}
+	}
+	if err := os.Rename(backup, i.scriptPath); err != nil {
+		os.Remove(current)
+		return result, fmt.Errorf("ошибка восстановления предыдущей версии: %v", err)
+	}
+	if fileExists(current) {
+		if err := os.Rename(current, backup); err != nil {
+			return result, fmt.Errorf("ошибка сохранения текущей версии: %v", err)
+		}
+	}
+
+	restored, err := SelfCheck(i.scriptPath)
+	if err != nil {
+		// Старые сборки не знают команду version, это не повод откатывать откат
+		restored = "неизвестна"
+	}
+	fmt.Fprintf(i.out, "✅ Восстановлена предыдущая версия: %s\n", restored)
+	result.add(fmt.Sprintf("восстановлена предыдущая версия %s в %s", restored, i.scriptPath))
+	return result, nil
+}
+
+// installBinary атомарно заменяет установленную программу файлом src: новая версия
+// копируется во временный файл рядом и переименовывается поверх старой, старая
+// сохраняется в копию .prev. Если новая версия не проходит самопроверку, возвращается старая
+func (i *Installer) installBinary(src string, result *Result) error {
+	// Если установленная копия совпадает с новой, копировать нечего
+	if same, _ := sameContent(src, i.scriptPath); same {
+		if info, err := os.Stat(i.scriptPath); err == nil && info.Mode().Perm() == 0755 {
+			return nil
+		}
+		if err := os.Chmod(i.scriptPath, 0755); err != nil {
+			return fmt.Errorf("ошибка установки прав на выполнение: %v", err)
+		}
+		result.add(fmt.Sprintf("исправлены права %s", i.scriptPath))
+		return nil
+	}
+
+	staged, err := i.stageBinary(src)
+	if err != nil {
+		return fmt.Errorf("ошибка копирования файла: %v", err)
+	}
+	defer os.Remove(staged)
+
+	// Сохраняем текущую версию жесткой ссылкой: сам путь программы остается на месте.
+	// Копией .prev она становится только после самопроверки, иначе пропала бы прежняя копия
+	backup := i.backupPath()
+	previous := backup + ".new"
+	hadPrevious := fileExists(i.scriptPath)
+	if hadPrevious {
+		os.Remove(previous)
+		if err := linkOrCopy(i.scriptPath, previous); err != nil {
+			return fmt.Errorf("ошибка сохранения предыдущей версии: %v", err)
+		}
+		defer os.Remove(previous)
+	}
+
+	// rename атомарен: cron запускает либо старую, либо новую версию целиком
+	if err := os.Rename(staged, i.scriptPath); err != nil {
+		return fmt.Errorf("ошибка замены программы: %v", err)
+	}
+
+	installed, err := SelfCheck(i.scriptPath)
+	if err != nil {
+		if hadPrevious {
+			if restoreErr := os.Rename(previous, i.scriptPath); restoreErr != nil {
+				return fmt.Errorf("новая версия не прошла самопроверку (%v), восстановить предыдущую не удалось: %v", err, restoreErr)
+			}
+			fmt.Fprintln(i.out, "⚠️  Новая версия не прошла самопроверку, восстановлена предыдущая")
+			return fmt.Errorf("новая версия не прошла самопроверку, восстановлена предыдущая: %v", err)
+		}
+		os.Remove(i.scriptPath)
+		return fmt.Errorf("новая версия не прошла самопроверку и удалена: %v", err)
+	}
+
+	if hadPrevious {
+		if err := os.Rename(previous, backup); err != nil {
+			return fmt.Errorf("ошибка сохранения предыдущей версии: %v", err)
+		}
+	}
+	fmt.Fprintf(i.out, "✅ Программа версии %s установлена в %s\n", installed, i.scriptPath)
+	result.add(fmt.Sprintf("установлена версия %s в %s", installed, i.scriptPath))
+	if hadPrevious {
+		fmt.Fprintf(i.out, "💾 Предыдущая версия сохранена в %s\n", backup)
+		result.add(fmt.Sprintf("предыдущая версия сохранена в %s", backup))
+	}
+	return nil
+}
+
+// stageBinary копирует src во временный исполняемый файл в директории программы
+func (i *Installer) stageBinary(src string) (string, error) {
+	source, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	if err := os.MkdirAll(filepath.Dir(i.scriptPath), 0755); err != nil {
+		return "", err
+	}
+
+	staged, err := os.CreateTemp(filepath.Dir(i.scriptPath), "."+filepath.Base(i.scriptPath)+".*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := staged.ReadFrom(source); err != nil {
+		staged.Close()
+		os.Remove(staged.Name())
+		return "", err
+	}
+	if err := staged.Close(); err != nil {
+		os.Remove(staged.Name())
+		return "", err
+	}
+	if err := os.Chmod(staged.Name(), 0755); err != nil {
+		os.Remove(staged.Name())
+		return "", err
+	}
+	return staged.Name(), nil
+}
+
+// SelfCheck запускает программу с командой version и возвращает ее версию.
+// Ошибка означает, что программа не запускается на этой системе или повреждена
+func SelfCheck(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SELF_CHECK_TIMEOUT)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, "version", "--json").Output()
+	if err != nil {
+		return "", fmt.Errorf("ошибка запуска %s version: %v", path, err)
+	}
+
+	var resp struct {
+		OK     bool         `json:"ok"`
+		Result version.Info `json:"result"`
+	}
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return "", fmt.Errorf("некорректный ответ %s version: %v", path, err)
+	}
+	if !resp.OK || resp.Result.Version == "" {
+		return "", fmt.Errorf("%s version не вернула версию", path)
+	}
+	return resp.Result.Version, nil
+}
+
+// resolveSource превращает источник обновления в путь к файлу программы
+func resolveSource(source, binaryName string) (string, error) {
+	path := source
+	if strings.Contains(source, "://") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return "", fmt.Errorf("некорректный адрес источника %s: %v", source, err)
+		}
+		if u.Scheme != "file" {
+			return "", fmt.Errorf("неподдерживаемый источник %s: поддерживаются локальные пути и file://", source)
+		}
+		path = u.Path
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("источник обновления недоступен: %v", err)
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, binaryName)
+		if info, err = os.Stat(path); err != nil {
+			return "", fmt.Errorf("в директории нет программы %s: %v", binaryName, err)
+		}
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("источник обновления не является файлом: %s", path)
+	}
+	return path, nil
+}
+
+// linkOrCopy создает жесткую ссылку, а если файловая система ее не поддерживает - копию
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0755)
+}
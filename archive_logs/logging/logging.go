@@ -0,0 +1,172 @@
+// Package logging настраивает структурированное логирование архиватора на базе log/slog
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	// OUTPUT_STDERR - вывод лога в стандартный поток ошибок
+	OUTPUT_STDERR = "stderr"
+	// OUTPUT_SYSLOG - вывод лога в системный журнал
+	OUTPUT_SYSLOG = "syslog"
+
+	DEFAULT_MAX_SIZE_MB = 10
+	DEFAULT_MAX_BACKUPS = 5
+)
+
+// Config описывает уровень, формат и места назначения лога
+type Config struct {
+	// Level - debug, info, warn или error
+	Level string `json:"level,omitempty"`
+	// Format - text или json
+	Format string `json:"format,omitempty"`
+	// Outputs - пути к файлам, а также "stderr" и "syslog"
+	Outputs []string `json:"outputs,omitempty"`
+	// MaxSizeMB - размер файла лога, после которого он ротируется
+	MaxSizeMB int `json:"max_size_mb,omitempty"`
+	// MaxBackups - сколько ротированных копий хранить
+	MaxBackups int `json:"max_backups,omitempty"`
+}
+
+// ParseLevel переводит название уровня в slog.Level
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("неизвестный уровень логирования %q", name)
+}
+
+// New создает логгер по конфигурации. Если в конфигурации не заданы места назначения,
+// используются defaultOutputs. Возвращаемый io.Closer закрывает открытые файлы
+func New(cfg Config, defaultOutputs []string) (*slog.Logger, io.Closer, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	format := strings.ToLower(cfg.Format)
+	if format != "" && format != "text" && format != "json" {
+		return nil, nil, fmt.Errorf("неизвестный формат логирования %q", cfg.Format)
+	}
+
+	maxSize := int64(cfg.MaxSizeMB)
+	if maxSize == 0 {
+		maxSize = DEFAULT_MAX_SIZE_MB
+	}
+	maxBackups := cfg.MaxBackups
+	if maxBackups == 0 {
+		maxBackups = DEFAULT_MAX_BACKUPS
+	}
+
+	outputs := cfg.Outputs
+	if len(outputs) == 0 {
+		outputs = defaultOutputs
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	newHandler := func(w io.Writer) slog.Handler {
+		if format == "json" {
+			return slog.NewJSONHandler(w, opts)
+		}
+		return slog.NewTextHandler(w, opts)
+	}
+
+	var handlers []slog.Handler
+	var closers closerList
+	for _, output := range outputs {
+		switch output {
+		case OUTPUT_STDERR:
+			handlers = append(handlers, newHandler(os.Stderr))
+		case OUTPUT_SYSLOG:
+			handler, closer, err := newSyslogHandler(newHandler)
+			if err != nil {
+				// Недоступный syslog не должен ломать архивирование
+				fmt.Fprintf(os.Stderr, "Ошибка подключения к syslog: %v\n", err)
+				continue
+			}
+			handlers = append(handlers, handler)
+			closers = append(closers, closer)
+		default:
+			file, err := OpenRotatingFile(output, maxSize*1024*1024, maxBackups)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Ошибка открытия лога %s: %v\n", output, err)
+				continue
+			}
+			handlers = append(handlers, newHandler(file))
+			closers = append(closers, file)
+		}
+	}
+
+	return slog.New(fanout(handlers)), closers, nil
+}
+
+// closerList закрывает все файлы лога
+type closerList []io.Closer
+
+func (c closerList) Close() error {
+	var firstErr error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// fanoutHandler рассылает каждую запись во все обработчики
+type fanoutHandler []slog.Handler
+
+func fanout(handlers []slog.Handler) slog.Handler {
+	return fanoutHandler(handlers)
+}
+
+func (f fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, record slog.Record) error {
+	var firstErr error
+	for _, h := range f {
+		if !h.Enabled(ctx, record.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, record.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	result := make(fanoutHandler, len(f))
+	for i, h := range f {
+		result[i] = h.WithAttrs(attrs)
+	}
+	return result
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	result := make(fanoutHandler, len(f))
+	for i, h := range f {
+		result[i] = h.WithGroup(name)
+	}
+	return result
+}
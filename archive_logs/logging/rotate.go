@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile - файл лога, который сам ротируется при превышении размера.
+// При ротации path переименовывается в path.1, path.1 в path.2 и так далее
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile открывает файл лога для дозаписи
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write дописывает данные в файл, предварительно ротируя его при необходимости
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// Не теряем запись из-за неудачной ротации - пишем в текущий файл
+			fmt.Fprintf(os.Stderr, "Ошибка ротации лога %s: %v\n", r.path, err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate сдвигает старые копии и начинает новый файл. Вызывается под мьютексом
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	if r.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+		for i := r.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			r.open()
+			return err
+		}
+	} else if err := os.Truncate(r.path, 0); err != nil {
+		r.open()
+		return err
+	}
+
+	return r.open()
+}
+
+// Close закрывает файл
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
@@ -0,0 +1,73 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+// SYSLOG_TAG - тег, с которым записи попадают в системный журнал
+const SYSLOG_TAG = "xui_log_archiver"
+
+// syslogWriter отправляет отформатированную запись в syslog с нужной важностью
+type syslogWriter struct {
+	mu     sync.Mutex
+	writer *syslog.Writer
+	level  slog.Level
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	message := strings.TrimRight(string(p), "\n")
+	var err error
+	switch {
+	case w.level >= slog.LevelError:
+		err = w.writer.Err(message)
+	case w.level >= slog.LevelWarn:
+		err = w.writer.Warning(message)
+	case w.level >= slog.LevelInfo:
+		err = w.writer.Info(message)
+	default:
+		err = w.writer.Debug(message)
+	}
+	return len(p), err
+}
+
+// syslogHandler форматирует запись вложенным обработчиком и передает ее в syslog
+type syslogHandler struct {
+	out   *syslogWriter
+	inner slog.Handler
+}
+
+func newSyslogHandler(newHandler func(io.Writer) slog.Handler) (slog.Handler, io.Closer, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, SYSLOG_TAG)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := &syslogWriter{writer: writer}
+	return &syslogHandler{out: out, inner: newHandler(out)}, writer, nil
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, record slog.Record) error {
+	// Важность сообщения передается через общий writer, поэтому сериализуем записи
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.level = record.Level
+	return h.inner.Handle(ctx, record)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{out: h.out, inner: h.inner.WithAttrs(attrs)}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{out: h.out, inner: h.inner.WithGroup(name)}
+}
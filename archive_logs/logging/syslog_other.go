@@ -0,0 +1,17 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// SYSLOG_TAG - тег, с которым записи попадают в системный журнал
+const SYSLOG_TAG = "xui_log_archiver"
+
+// newSyslogHandler не реализован без log/syslog
+func newSyslogHandler(newHandler func(io.Writer) slog.Handler) (slog.Handler, io.Closer, error) {
+	return nil, nil, fmt.Errorf("syslog не поддерживается в этой системе")
+}
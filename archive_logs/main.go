@@ -6,15 +6,13 @@ import (
 	"os"
 	"strings"
 
-	"xui_log_archiver/archiver"
-	"xui_log_archiver/installer"
+	"xui_log_archiver/cli"
 )
 
 func main() {
-	// Проверяем, запущена ли программа с аргументом для cron
-	if len(os.Args) > 1 && os.Args[1] == "--cron" {
-		runArchiving()
-		return
+	// С аргументами работаем как утилита командной строки: archive, install, status...
+	if len(os.Args) > 1 {
+		os.Exit(cli.Run(os.Args[1:]))
 	}
 
 	// Интерактивное меню
@@ -25,11 +23,13 @@ func showMenu() {
 	for {
 		fmt.Println("\n=== X-UI Log Archiver ===")
 		fmt.Println("1. Сделать архивирование сейчас")
-		fmt.Println("2. Добавить в автозапуск (каждые 10 минут, архивирование в 00 минут часа)")
+		fmt.Println("2. Добавить в автозапуск (по умолчанию каждые 10 минут, архив каждый час)")
 		fmt.Println("3. Удалить из автозапуска")
 		fmt.Println("4. Показать статус автозапуска")
+		fmt.Println("5. Запустить веб-дашборд")
+		fmt.Println("6. Объединить архивы в один файл")
 		fmt.Println("0. Выход")
-		fmt.Print("\nВыберите действие (0-4): ")
+		fmt.Print("\nВыберите действие (0-6): ")
 
 		reader := bufio.NewReader(os.Stdin)
 		choice, _ := reader.ReadString('\n')
@@ -38,13 +38,17 @@ func showMenu() {
 		switch choice {
 		case "1":
 			fmt.Println("\nЗапуск архивирования...")
-			runArchiving()
+			cli.Run([]string{"archive"})
 		case "2":
-			installAutostart()
+			cli.Run([]string{"install", "--yes"})
 		case "3":
-			removeAutostart()
+			cli.Run([]string{"uninstall", "--yes"})
 		case "4":
-			showAutostartStatus()
+			cli.Run([]string{"status"})
+		case "5":
+			cli.Run([]string{"dashboard"})
+		case "6":
+			cli.Run([]string{"merge"})
 		case "0":
 			fmt.Println("До свидания!")
 			return
@@ -53,29 +57,3 @@ func showMenu() {
 		}
 	}
 }
-
-func runArchiving() {
-	arch := archiver.New()
-	if err := arch.RunArchiving(); err != nil {
-		fmt.Printf("Ошибка архивирования: %v\n", err)
-	}
-}
-
-func installAutostart() {
-	inst := installer.New()
-	if err := inst.InstallAutostart(); err != nil {
-		fmt.Printf("Ошибка установки автозапуска: %v\n", err)
-	}
-}
-
-func removeAutostart() {
-	inst := installer.New()
-	if err := inst.RemoveAutostart(); err != nil {
-		fmt.Printf("Ошибка удаления автозапуска: %v\n", err)
-	}
-}
-
-func showAutostartStatus() {
-	inst := installer.New()
-	inst.ShowAutostartStatus()
-}
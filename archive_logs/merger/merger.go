@@ -0,0 +1,344 @@
+// Package merger объединяет архивы логов в один отсортированный файл без дубликатов
+package merger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"xui_log_archiver/fsys"
+)
+
+const (
+	ARCHIVE_SOURCE_DIR = "/usr/local/x-ui/archives"
+	MERGE_DEST_DIR     = "/usr/local/x-ui/mergelog"
+	LOGS_SUBDIR        = "/usr/local/x-ui/mergelog/logs"
+	MERGED_LOG_FILE    = "/usr/local/x-ui/mergelog/merged_access.log"
+)
+
+// Merger копирует, распаковывает и объединяет архивы логов
+type Merger struct {
+	sourceDir  string
+	logsDir    string
+	mergedFile string
+	out        io.Writer
+	fs         fsys.FS
+}
+
+// Result содержит итоги объединения
+type Result struct {
+	Archives int `json:"archives"`
+	// Periods - число периодов: части одного периода (access_X.part1.log.gz, ...) считаются одним
+	Periods int `json:"periods"`
+	// MultiPartPeriods - сколько периодов разбито на части по размеру накопителя
+	MultiPartPeriods int    `json:"multi_part_periods"`
+	Lines            int    `json:"lines"`
+	Duplicates       int    `json:"duplicates"`
+	MergedFile       string `json:"merged_file"`
+}
+
+// New создает объединитель с путями по умолчанию
+func New() *Merger {
+	return &Merger{
+		sourceDir:  ARCHIVE_SOURCE_DIR,
+		logsDir:    LOGS_SUBDIR,
+		mergedFile: MERGED_LOG_FILE,
+		out:        os.Stdout,
+		fs:         fsys.OS,
+	}
+}
+
+// SetSourceDir задает директорию с архивами
+func (m *Merger) SetSourceDir(dir string) {
+	m.sourceDir = dir
+}
+
+// SetDestDir задает директорию результата: в ней создаются logs/ и merged_access.log
+func (m *Merger) SetDestDir(dir string) {
+	m.logsDir = filepath.Join(dir, "logs")
+	m.mergedFile = filepath.Join(dir, "merged_access.log")
+}
+
+// SetOutput задает, куда выводятся сообщения для пользователя (по умолчанию stdout)
+func (m *Merger) SetOutput(w io.Writer) {
+	m.out = w
+}
+
+// SetFS задает файловую систему (по умолчанию fsys.OS)
+func (m *Merger) SetFS(fs fsys.FS) {
+	m.fs = fs
+}
+
+// Run выполняет полный цикл: копирование, распаковку, объединение и очистку
+func (m *Merger) Run() (Result, error) {
+	result := Result{MergedFile: m.mergedFile}
+
+	// Создаем необходимые директории, если их нет
+	if err := m.fs.MkdirAll(m.sourceDir, 0755); err != nil {
+		return result, fmt.Errorf("ошибка создания директории %s: %v", m.sourceDir, err)
+	}
+
+	if err := m.fs.MkdirAll(m.logsDir, 0755); err != nil {
+		return result, fmt.Errorf("ошибка создания директории %s: %v", m.logsDir, err)
+	}
+
+	// Копируем и распаковываем архивы
+	archives, err := m.copyAndExtractArchives()
+	if err != nil {
+		return result, fmt.Errorf("ошибка копирования и распаковки архивов: %v", err)
+	}
+	if len(archives) == 0 {
+		fmt.Fprintf(m.out, "Предупреждение: в %s нет архивов. Для проверки на тестовых данных: "+
+			"xui_log_archiver gen --backfill 24h --archive-dir <директория>\n", m.sourceDir)
+	}
+	result.Archives = len(archives)
+	result.Periods, result.MultiPartPeriods = m.checkParts(archives)
+
+	// Объединяем логи
+	lines, duplicates, err := m.mergeLogs()
+	if err != nil {
+		return result, fmt.Errorf("ошибка объединения логов: %v", err)
+	}
+	result.Lines = lines
+	result.Duplicates = duplicates
+
+	// Очищаем временные файлы
+	if err := m.cleanupTempFiles(); err != nil {
+		fmt.Fprintf(m.out, "Предупреждение: ошибка очистки временных файлов: %v\n", err)
+	}
+
+	return result, nil
+}
+
+// copyAndExtractArchives копирует .gz файлы из исходной директории и ее поддиректорий
+// YYYY/MM/DD с уплотненными архивами, распаковывает их и возвращает имена распакованных архивов
+func (m *Merger) copyAndExtractArchives() ([]string, error) {
+	// Читаем все .gz файлы из исходной директории
+	if _, err := m.fs.ReadDir(m.sourceDir); err != nil {
+		return nil, fmt.Errorf("ошибка чтения директории %s: %v", m.sourceDir, err)
+	}
+	var archives []string
+	fsys.Walk(m.fs, m.sourceDir, func(path string, info fs.FileInfo) error {
+		if strings.HasSuffix(info.Name(), ".gz") {
+			archives = append(archives, path)
+		}
+		return nil
+	})
+
+	var extracted []string
+	for _, sourcePath := range archives {
+		// Имена архивов содержат дату, поэтому в logs/ они не пересекаются
+		name := filepath.Base(sourcePath)
+		destPath := filepath.Join(m.logsDir, name)
+
+		// Копируем файл
+		if err := m.copyFile(sourcePath, destPath); err != nil {
+			fmt.Fprintf(m.out, "Предупреждение: не удалось скопировать %s: %v\n", sourcePath, err)
+			continue
+		}
+
+		// Распаковываем файл
+		if err := m.extractGzipFile(destPath); err != nil {
+			fmt.Fprintf(m.out, "Предупреждение: не удалось распаковать %s: %v\n", destPath, err)
+			continue
+		}
+		extracted = append(extracted, name)
+	}
+
+	return extracted, nil
+}
+
+// splitPart разбирает имя части архива: access_X.part2.log.gz -> ("access_X", 2).
+// Для архива без частей номер части 0
+func splitPart(name string) (string, int) {
+	base := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".log")
+	index := strings.LastIndex(base, ".part")
+	if index < 0 {
+		return base, 0
+	}
+	part, err := strconv.Atoi(base[index+len(".part"):])
+	if err != nil || part < 1 {
+		return base, 0
+	}
+	return base[:index], part
+}
+
+// checkParts группирует архивы по периодам и предупреждает о пропущенных частях:
+// без них в объединенном файле не хватит строк за часть периода.
+// Возвращает число периодов и число периодов из нескольких частей
+func (m *Merger) checkParts(archives []string) (int, int) {
+	parts := make(map[string][]int)
+	for _, name := range archives {
+		period, part := splitPart(name)
+		parts[period] = append(parts[period], part)
+	}
+
+	periods := make([]string, 0, len(parts))
+	for period := range parts {
+		periods = append(periods, period)
+	}
+	sort.Strings(periods)
+
+	multiPart := 0
+	for _, period := range periods {
+		numbers := parts[period]
+		sort.Ints(numbers)
+		if numbers[len(numbers)-1] == 0 {
+			continue
+		}
+		multiPart++
+		// Части нумеруются с 1; архив без номера рядом с частями - результат запечатывания
+		expected := 1
+		for _, number := range numbers {
+			if number == 0 {
+				continue
+			}
+			for ; expected < number; expected++ {
+				fmt.Fprintf(m.out, "Предупреждение: нет части %d периода %s\n", expected, period)
+			}
+			expected = number + 1
+		}
+	}
+	return len(periods), multiPart
+}
+
+// copyFile копирует файл из source в destination
+func (m *Merger) copyFile(source, dest string) error {
+	srcFile, err := m.fs.Open(source)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	destFile, err := m.fs.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	_, err = io.Copy(destFile, srcFile)
+	return err
+}
+
+// extractGzipFile распаковывает .gz файл
+func (m *Merger) extractGzipFile(gzPath string) error {
+	// Открываем сжатый файл
+	gzFile, err := m.fs.Open(gzPath)
+	if err != nil {
+		return err
+	}
+	defer gzFile.Close()
+
+	// Создаем gzip reader
+	gzReader, err := gzip.NewReader(gzFile)
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+
+	// Создаем файл для распакованных данных
+	extractedPath := strings.TrimSuffix(gzPath, ".gz")
+	extractedFile, err := m.fs.Create(extractedPath)
+	if err != nil {
+		return err
+	}
+	defer extractedFile.Close()
+
+	// Копируем распакованные данные
+	_, err = io.Copy(extractedFile, gzReader)
+	if err != nil {
+		m.fs.Remove(extractedPath) // Удаляем частично созданный файл при ошибке
+		return err
+	}
+
+	// Удаляем исходный .gz файл
+	return m.fs.Remove(gzPath)
+}
+
+// mergeLogs объединяет все .log файлы, сортирует и удаляет дубликаты.
+// Возвращает количество записанных строк и количество отброшенных дубликатов
+func (m *Merger) mergeLogs() (int, int, error) {
+	// Читаем все .log файлы из директории логов
+	entries, err := m.fs.ReadDir(m.logsDir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка чтения директории %s: %v", m.logsDir, err)
+	}
+
+	var allLines []string
+	lineSet := make(map[string]bool) // Для удаления дубликатов
+	duplicates := 0
+
+	// Читаем все строки из всех .log файлов
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".log") {
+			filePath := filepath.Join(m.logsDir, entry.Name())
+
+			file, err := m.fs.Open(filePath)
+			if err != nil {
+				fmt.Fprintf(m.out, "Предупреждение: не удалось открыть %s: %v\n", filePath, err)
+				continue
+			}
+
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+				if lineSet[line] {
+					duplicates++
+					continue
+				}
+				lineSet[line] = true
+				allLines = append(allLines, line)
+			}
+
+			file.Close()
+		}
+	}
+
+	// Сортируем строки
+	sort.Strings(allLines)
+
+	// Записываем объединенный файл
+	mergedFile, err := m.fs.Create(m.mergedFile)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка создания файла %s: %v", m.mergedFile, err)
+	}
+	defer mergedFile.Close()
+
+	writer := bufio.NewWriter(mergedFile)
+	for _, line := range allLines {
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			return 0, 0, fmt.Errorf("ошибка записи в файл: %v", err)
+		}
+	}
+
+	return len(allLines), duplicates, writer.Flush()
+}
+
+// cleanupTempFiles удаляет временные .log файлы из директории логов
+func (m *Merger) cleanupTempFiles() error {
+	entries, err := m.fs.ReadDir(m.logsDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".log") {
+			filePath := filepath.Join(m.logsDir, entry.Name())
+			if err := m.fs.Remove(filePath); err != nil {
+				fmt.Fprintf(m.out, "Предупреждение: не удалось удалить %s: %v\n", filePath, err)
+			}
+		}
+	}
+
+	return nil
+}
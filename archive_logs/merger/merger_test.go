@@ -0,0 +1,80 @@
+package merger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"xui_log_archiver/fsys"
+)
+
+// writeArchive создает сжатый архив с заданными строками
+func writeArchive(t *testing.T, dir, name string, lines ...string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	gz.Close()
+	if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingFS не дает скопировать один из архивов
+type failingFS struct {
+	fsys.FS
+	name string
+}
+
+func (f failingFS) Create(name string) (fsys.File, error) {
+	if filepath.Base(name) == f.name {
+		return nil, os.ErrPermission
+	}
+	return f.FS.Create(name)
+}
+
+func TestRunMergesPartsAndDropsDuplicates(t *testing.T) {
+	source, dest := t.TempDir(), t.TempDir()
+	writeArchive(t, source, "access_20260504_10.log.gz",
+		"2026/05/04 10:00:02 b", "2026/05/04 10:00:01 a")
+	writeArchive(t, source, "access_20260504_11.part1.log.gz",
+		"2026/05/04 11:00:01 c", "2026/05/04 10:00:01 a")
+	writeArchive(t, source, "access_20260504_11.part3.log.gz", "2026/05/04 11:30:00 d")
+	writeArchive(t, source, "access_20260504_12.log.gz", "2026/05/04 12:00:00 e")
+
+	var out bytes.Buffer
+	m := New()
+	m.SetSourceDir(source)
+	m.SetDestDir(dest)
+	m.SetOutput(&out)
+	// Архив, который не удалось скопировать, пропускается с предупреждением
+	m.SetFS(failingFS{FS: fsys.OS, name: "access_20260504_12.log.gz"})
+
+	result, err := m.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Archives != 3 || result.Periods != 2 || result.MultiPartPeriods != 1 {
+		t.Errorf("итоги %+v", result)
+	}
+	if result.Lines != 4 || result.Duplicates != 1 {
+		t.Errorf("строк %d, дубликатов %d, ожидалось 4 и 1", result.Lines, result.Duplicates)
+	}
+	for _, warning := range []string{"нет части 2 периода access_20260504_11", "access_20260504_12.log.gz"} {
+		if !strings.Contains(out.String(), warning) {
+			t.Errorf("нет предупреждения %q в выводе:\n%s", warning, out.String())
+		}
+	}
+
+	merged, err := os.ReadFile(result.MergedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2026/05/04 10:00:01 a\n2026/05/04 10:00:02 b\n2026/05/04 11:00:01 c\n2026/05/04 11:30:00 d\n"
+	if string(merged) != want {
+		t.Errorf("объединенный файл:\n%s\nожидалось:\n%s", merged, want)
+	}
+}
@@ -0,0 +1,284 @@
+// Package metrics реализует минимальный реестр метрик в текстовом формате Prometheus
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DEFAULT_TEXTFILE - файл метрик для textfile collector node_exporter (режим cron)
+	DEFAULT_TEXTFILE = "/var/lib/node_exporter/textfile_collector/xui_log_archiver.prom"
+	// DEFAULT_ADDR - адрес эндпоинта /metrics в режиме демона
+	DEFAULT_ADDR = "127.0.0.1:9435"
+)
+
+// TextfileFor возвращает файл метрик профиля: у каждого профиля свой файл в той же директории
+func TextfileFor(profile string) string {
+	return strings.TrimSuffix(DEFAULT_TEXTFILE, ".prom") + "_" + profile + ".prom"
+}
+
+// Type - тип метрики в терминах Prometheus
+type Type string
+
+const (
+	Counter Type = "counter"
+	Gauge   Type = "gauge"
+)
+
+// family - семейство метрик с одинаковым именем и разными метками
+type family struct {
+	name   string
+	help   string
+	typ    Type
+	labels []string
+	values map[string]float64
+}
+
+// Registry хранит значения метрик и умеет выводить их в формате Prometheus
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	byName   map[string]*family
+	hooks    []func()
+	// constLabel - метка вида profile="x", которая добавляется ко всем метрикам реестра
+	constLabel string
+}
+
+// NewRegistry создает пустой реестр
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]*family)}
+}
+
+// Vec - ссылка на семейство метрик для обновления значений
+type Vec struct {
+	reg *Registry
+	fam *family
+}
+
+// Register регистрирует семейство метрик. Повторная регистрация возвращает то же семейство
+func (r *Registry) Register(name, help string, typ Type, labels ...string) *Vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if fam, ok := r.byName[name]; ok {
+		return &Vec{reg: r, fam: fam}
+	}
+	fam := &family{name: name, help: help, typ: typ, labels: labels, values: make(map[string]float64)}
+	r.families = append(r.families, fam)
+	r.byName[name] = fam
+	return &Vec{reg: r, fam: fam}
+}
+
+// SetConstLabel добавляет метку name="value" ко всем метрикам реестра. Нужно, когда несколько
+// экземпляров архиватора пишут одинаковые метрики на одном сервере
+func (r *Registry) SetConstLabel(name, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.constLabel = name + "=" + quoteLabel(value)
+}
+
+// withConstLabel добавляет постоянную метку к строке меток серии
+func (r *Registry) withConstLabel(key string) string {
+	switch {
+	case r.constLabel == "":
+		return key
+	case key == "":
+		return "{" + r.constLabel + "}"
+	}
+	return "{" + r.constLabel + "," + key[1:]
+}
+
+// withoutConstLabel убирает постоянную метку из строки меток, прочитанной из файла
+func (r *Registry) withoutConstLabel(key string) string {
+	switch {
+	case r.constLabel == "":
+		return key
+	case key == "{"+r.constLabel+"}":
+		return ""
+	}
+	return strings.Replace(key, "{"+r.constLabel+",", "{", 1)
+}
+
+// OnCollect добавляет функцию, которая вызывается перед каждым выводом метрик
+func (r *Registry) OnCollect(hook func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+// labelKey строит строку меток вида {a="1",b="2"} в порядке объявления
+func (v *Vec) labelKey(values []string) string {
+	if len(v.fam.labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(v.fam.labels))
+	for i, name := range v.fam.labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		parts[i] = name + "=" + quoteLabel(value)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labelEscaper экранирует значение метки по текстовому формату Prometheus: только \, " и
+// перевод строки. %q из Go пишет еще \t, \x.. и \u...., которых в этом формате нет
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel возвращает значение метки в кавычках
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// Add увеличивает значение метрики на delta
+func (v *Vec) Add(delta float64, labels ...string) {
+	v.reg.mu.Lock()
+	defer v.reg.mu.Unlock()
+	v.fam.values[v.labelKey(labels)] += delta
+}
+
+// Inc увеличивает значение метрики на единицу
+func (v *Vec) Inc(labels ...string) {
+	v.Add(1, labels...)
+}
+
+// Set устанавливает значение метрики
+func (v *Vec) Set(value float64, labels ...string) {
+	v.reg.mu.Lock()
+	defer v.reg.mu.Unlock()
+	v.fam.values[v.labelKey(labels)] = value
+}
+
+// Value возвращает текущее значение метрики
+func (v *Vec) Value(labels ...string) float64 {
+	v.reg.mu.Lock()
+	defer v.reg.mu.Unlock()
+	return v.fam.values[v.labelKey(labels)]
+}
+
+// WriteTo выводит все метрики в текстовом формате Prometheus
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	hooks := append([]func(){}, r.hooks...)
+	r.mu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, fam := range r.families {
+		if len(fam.values) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "# HELP %s %s\n", fam.name, fam.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", fam.name, fam.typ)
+
+		keys := make([]string, 0, len(fam.values))
+		for key := range fam.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "%s%s %s\n", fam.name, r.withConstLabel(key), formatValue(fam.values[key]))
+		}
+	}
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Handler возвращает HTTP-обработчик для эндпоинта /metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// WriteTextfile атомарно записывает метрики в файл для textfile collector node_exporter
+func (r *Registry) WriteTextfile(path string) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := r.WriteTo(tempFile); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tempFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+// LoadTextfile восстанавливает значения зарегистрированных метрик из ранее записанного файла.
+// Нужно в режиме cron, где каждый запуск - новый процесс, а счетчики должны только расти
+func (r *Registry) LoadTextfile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sep := strings.LastIndexByte(line, ' ')
+		if sep < 0 {
+			continue
+		}
+		series, rawValue := line[:sep], line[sep+1:]
+		value, err := strconv.ParseFloat(rawValue, 64)
+		if err != nil {
+			continue
+		}
+
+		name, key := series, ""
+		if i := strings.IndexByte(series, '{'); i >= 0 {
+			name, key = series[:i], series[i:]
+		}
+		if fam, ok := r.byName[name]; ok {
+			fam.values[r.withoutConstLabel(key)] = value
+		}
+	}
+	return scanner.Err()
+}
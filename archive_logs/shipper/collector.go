@@ -0,0 +1,177 @@
+package shipper
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"xui_log_archiver/collector"
+)
+
+const (
+	// DEFAULT_CHUNK_SIZE - размер куска архива в одном запросе к коллектору
+	DEFAULT_CHUNK_SIZE = 8 << 20
+	// COLLECTOR_REQUEST_TIMEOUT - сколько ждать один запрос к коллектору
+	COLLECTOR_REQUEST_TIMEOUT = 5 * time.Minute
+)
+
+// CollectorConfig - коллектор архивов (команда collect). Узел подтверждает себя сертификатом,
+// выпущенным CA коллектора, и проверяет сертификат коллектора тем же CA
+type CollectorConfig struct {
+	// URL - адрес коллектора, например https://logs.example.com:8443
+	URL string
+	// CA - сертификат CA коллектора, Cert и Key - сертификат и ключ узла
+	CA   string
+	Cert string
+	Key  string
+	// ChunkSize - размер куска архива, по умолчанию DEFAULT_CHUNK_SIZE
+	ChunkSize int64
+	// Client - HTTP-клиент, по умолчанию с сертификатами из CA, Cert и Key
+	Client *http.Client
+}
+
+// Collector отправляет архивы на коллектор по HTTPS. Прерванная отправка продолжается с байта,
+// который коллектор принял последним, а SHA-256 архива коллектор вычисляет сам
+type Collector struct {
+	cfg  CollectorConfig
+	base *url.URL
+}
+
+// NewCollector проверяет настройки коллектора и читает сертификаты
+func NewCollector(cfg CollectorConfig) (*Collector, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil || base.Host == "" || base.Scheme != "https" {
+		return nil, fmt.Errorf("некорректный адрес коллектора %q: ожидается https://host[:port]", cfg.URL)
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = DEFAULT_CHUNK_SIZE
+	}
+	if cfg.ChunkSize > collector.MAX_CHUNK {
+		return nil, fmt.Errorf("кусок архива %d МБ больше допустимого коллектором %d МБ", cfg.ChunkSize>>20, collector.MAX_CHUNK>>20)
+	}
+	if cfg.Client == nil {
+		if cfg.CA == "" || cfg.Cert == "" || cfg.Key == "" {
+			return nil, fmt.Errorf("для коллектора нужны сертификат CA, сертификат и ключ узла")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения сертификата узла: %v", err)
+		}
+		data, err := os.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения сертификата CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("в %s нет сертификатов PEM", cfg.CA)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+			MinVersion:   tls.VersionTLS12,
+		}
+		cfg.Client = &http.Client{Transport: transport, Timeout: COLLECTOR_REQUEST_TIMEOUT}
+	}
+	return &Collector{cfg: cfg, base: base}, nil
+}
+
+// String возвращает адрес коллектора
+func (c *Collector) String() string {
+	return c.base.String() + "/"
+}
+
+// Stat возвращает размер и SHA-256 архива, вычисленную коллектором
+func (c *Collector) Stat(ctx context.Context, key string) (Object, error) {
+	resp, err := c.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return Object{Size: resp.ContentLength, SHA256: resp.Header.Get(collector.HEADER_SHA256)}, nil
+	case http.StatusNotFound:
+		return Object{}, ErrNotFound
+	}
+	return Object{}, collectorError(http.MethodHead, key, resp)
+}
+
+// Upload спрашивает коллектор, сколько байт архива он уже принял, и отправляет остальное
+// кусками. Если такой же архив у коллектора уже есть, данные не передаются
+func (c *Collector) Upload(ctx context.Context, item *Item, file *os.File, save func() error) error {
+	header := http.Header{
+		collector.HEADER_SHA256: {item.SHA256},
+		"Content-Range":         {fmt.Sprintf("bytes */%d", item.Size)},
+	}
+	if len(item.Replaces) > 0 {
+		header[collector.HEADER_REPLACES] = item.Replaces
+	}
+	resp, err := c.do(ctx, http.MethodPut, item.Key, header, nil)
+	for err == nil {
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusCreated:
+			resp.Body.Close()
+			return nil
+		case http.StatusAccepted, http.StatusRequestedRangeNotSatisfiable:
+			resp.Body.Close()
+		default:
+			err := collectorError(http.MethodPut, item.Key, resp)
+			resp.Body.Close()
+			return err
+		}
+
+		offset, parseErr := strconv.ParseInt(resp.Header.Get(collector.HEADER_OFFSET), 10, 64)
+		if parseErr != nil || offset < 0 || offset >= item.Size {
+			return fmt.Errorf("коллектор вернул некорректное смещение %q", resp.Header.Get(collector.HEADER_OFFSET))
+		}
+		if offset != item.Offset {
+			item.Offset = offset
+			if err := save(); err != nil {
+				return err
+			}
+		}
+
+		chunk := make([]byte, min(c.cfg.ChunkSize, item.Size-offset))
+		if _, err := file.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return fmt.Errorf("ошибка чтения архива: %v", err)
+		}
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(chunk))-1, item.Size))
+		resp, err = c.do(ctx, http.MethodPut, item.Key, header, chunk)
+	}
+	return err
+}
+
+// do выполняет запрос к архиву key
+func (c *Collector) do(ctx context.Context, method, key string, header http.Header, body []byte) (*http.Response, error) {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base.String()+collector.API_PATH+strings.Join(segments, "/"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := c.cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("коллектор %s %s: %v", method, key, err)
+	}
+	return resp, nil
+}
+
+func collectorError(method, key string, resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return fmt.Errorf("коллектор %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(message)))
+}
@@ -0,0 +1,376 @@
+package shipper
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DEFAULT_PART_SIZE - размер части многочастной загрузки. Архивы не больше части
+	// отправляются одним запросом
+	DEFAULT_PART_SIZE = 16 << 20
+	// MIN_PART_SIZE - меньше S3 не принимает ни одну часть, кроме последней
+	MIN_PART_SIZE = 5 << 20
+	// DEFAULT_REGION - регион подписи запросов, если он не задан. MinIO принимает любой
+	DEFAULT_REGION = "us-east-1"
+	// S3_REQUEST_TIMEOUT - сколько ждать один запрос к S3, в том числе отправку части
+	S3_REQUEST_TIMEOUT = 5 * time.Minute
+	// S3_META_SHA256 - метаданные объекта с SHA-256 архива: по ним проверяется отправка
+	S3_META_SHA256 = "X-Amz-Meta-Sha256"
+)
+
+// S3Config - S3-совместимое хранилище: AWS, MinIO, Ceph и т.п.
+type S3Config struct {
+	// Endpoint - адрес API, например https://s3.eu-central-1.amazonaws.com или http://127.0.0.1:9000
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// VirtualHost - адресовать бакет поддоменом (bucket.endpoint), а не путем (endpoint/bucket)
+	VirtualHost bool
+	// PartSize - размер части многочастной загрузки, по умолчанию DEFAULT_PART_SIZE
+	PartSize int64
+	// Client - HTTP-клиент, по умолчанию с таймаутом S3_REQUEST_TIMEOUT
+	Client *http.Client
+}
+
+// S3 отправляет архивы в S3-совместимое хранилище. Запросы подписываются AWS Signature V4,
+// тело каждого запроса сверяется хранилищем по Content-MD5 и x-amz-content-sha256,
+// а SHA-256 всего архива записывается в метаданные объекта
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+}
+
+// NewS3 проверяет настройки хранилища
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("некорректный адрес S3 %q: ожидается http(s)://host[:port]", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("не задан бакет S3")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("не заданы ключи доступа S3")
+	}
+	if cfg.Region == "" {
+		cfg.Region = DEFAULT_REGION
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = DEFAULT_PART_SIZE
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: S3_REQUEST_TIMEOUT}
+	}
+	if cfg.Prefix != "" && !strings.HasSuffix(cfg.Prefix, "/") {
+		cfg.Prefix += "/"
+	}
+	return &S3{cfg: cfg, endpoint: endpoint}, nil
+}
+
+// String возвращает адрес хранилища: s3://bucket/prefix
+func (s *S3) String() string {
+	return "s3://" + s.cfg.Bucket + "/" + s.cfg.Prefix
+}
+
+// Stat возвращает размер объекта и SHA-256 из его метаданных
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	return Object{Size: resp.ContentLength, SHA256: resp.Header.Get(S3_META_SHA256)}, nil
+}
+
+// Upload отправляет архив одним запросом или, если он больше части, многочастной загрузкой.
+// Многочастная загрузка продолжается с первой неотправленной части, а если хранилище ее
+// уже забыло (NoSuchUpload) - начинается заново
+func (s *S3) Upload(ctx context.Context, item *Item, file *os.File, save func() error) error {
+	if item.UploadID == "" && item.Size <= s.cfg.PartSize {
+		body := make([]byte, item.Size)
+		if _, err := file.ReadAt(body, 0); err != nil && err != io.EOF {
+			return fmt.Errorf("ошибка чтения архива: %v", err)
+		}
+		resp, err := s.do(ctx, http.MethodPut, item.Key, nil, s.metadata(item), body)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	err := s.uploadParts(ctx, item, file, save)
+	if isS3Error(err, "NoSuchUpload") {
+		item.UploadID, item.PartSize, item.Parts = "", 0, nil
+		if err := save(); err != nil {
+			return err
+		}
+		err = s.uploadParts(ctx, item, file, save)
+	}
+	return err
+}
+
+func (s *S3) uploadParts(ctx context.Context, item *Item, file *os.File, save func() error) error {
+	if item.UploadID == "" {
+		resp, err := s.do(ctx, http.MethodPost, item.Key, url.Values{"uploads": {""}}, s.metadata(item), nil)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil || result.UploadID == "" {
+			return fmt.Errorf("S3 не вернул номер многочастной загрузки: %v", err)
+		}
+		// Размер части запоминается: если он изменится в настройках, начатая загрузка
+		// продолжится с прежним
+		item.UploadID, item.PartSize, item.Parts = result.UploadID, s.cfg.PartSize, nil
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, item.PartSize)
+	for number := len(item.Parts) + 1; int64(number-1)*item.PartSize < item.Size; number++ {
+		n, err := file.ReadAt(buf, int64(number-1)*item.PartSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("ошибка чтения архива: %v", err)
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {item.UploadID}}
+		resp, err := s.do(ctx, http.MethodPut, item.Key, query, nil, buf[:n])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		item.Parts = append(item.Parts, Part{Number: number, ETag: resp.Header.Get("ETag")})
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	type completePart struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+		Parts   []completePart `xml:"Part"`
+	}{}
+	for _, part := range item.Parts {
+		complete.Parts = append(complete.Parts, completePart{part.Number, part.ETag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, item.Key, url.Values{"uploadId": {item.UploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	// Завершение может вернуть ошибку в теле ответа 200, когда хранилище уже начало отвечать
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("ошибка завершения многочастной загрузки: %v", err)
+	}
+	if failure := parseS3Error(http.MethodPost, item.Key, resp.StatusCode, data); failure.Code != "" {
+		return failure
+	}
+	item.UploadID, item.Parts = "", nil
+	return save()
+}
+
+// metadata возвращает заголовки объекта, которые задаются при его создании
+func (s *S3) metadata(item *Item) http.Header {
+	return http.Header{
+		S3_META_SHA256: {item.SHA256},
+		"Content-Type": {"application/octet-stream"},
+	}
+}
+
+// S3Error - ответ S3 с ошибкой
+type S3Error struct {
+	Method  string `xml:"-"`
+	Key     string `xml:"-"`
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	message := fmt.Sprintf("S3 %s %s: %d", e.Method, e.Key, e.Status)
+	if e.Code != "" {
+		message += " " + e.Code
+	}
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	return message
+}
+
+func parseS3Error(method, key string, status int, body []byte) *S3Error {
+	failure := &S3Error{Method: method, Key: key, Status: status}
+	xml.Unmarshal(body, failure)
+	return failure
+}
+
+func isS3Error(err error, code string) bool {
+	failure, ok := err.(*S3Error)
+	return ok && failure.Code == code
+}
+
+// do выполняет подписанный запрос к объекту key и возвращает ответ с кодом 2xx.
+// На HEAD без объекта возвращает ErrNotFound
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	target := *s.endpoint
+	path := "/" + uriEncode(s.cfg.Prefix+key, false)
+	if s.cfg.VirtualHost {
+		target.Host = s.cfg.Bucket + "." + target.Host
+	} else {
+		path = "/" + uriEncode(s.cfg.Bucket, true) + path
+	}
+	address := target.Scheme + "://" + target.Host + target.Path + path
+	if len(query) > 0 {
+		address += "?" + canonicalQuery(query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, address, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	if method == http.MethodPut || method == http.MethodPost && body != nil {
+		sum := md5.Sum(body)
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	payload := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(payload[:]), time.Now())
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s: %v", method, key, err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if method == http.MethodHead && resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return nil, parseS3Error(method, key, resp.StatusCode, data)
+}
+
+// sign подписывает запрос AWS Signature V4. Подписываются host и все заголовки запроса
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	date := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for name, header := range req.Header {
+		trimmed := make([]string, len(header))
+		for i, value := range header {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		values[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.cfg.SecretKey)
+	for _, part := range []string{date, s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery кодирует параметры так, как их подписывает S3: по алфавиту, с = у пустых значений
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode кодирует все, кроме A-Z, a-z, 0-9, -_.~ и, если encodeSlash не задан, /.
+// url.PathEscape оставляет как есть + и другие символы, которые S3 ожидает закодированными
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,457 @@
+// Package shipper отправляет запечатанные архивы во внешнее хранилище. Архивы ждут отправки
+// в очереди на диске - по файлу на архив, поэтому очередь переживает сбои и перезапуски,
+// а прерванная загрузка продолжается с того места, где остановилась
+package shipper
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"xui_log_archiver/archiver"
+)
+
+const (
+	// DEFAULT_RETRIES - сколько раз за запуск пробовать отправить архив
+	DEFAULT_RETRIES = 3
+	// DEFAULT_BACKOFF - пауза перед второй попыткой, перед каждой следующей - вдвое больше
+	DEFAULT_BACKOFF = 2 * time.Second
+	// MAX_DELAY - самая долгая отсрочка архива, который не удалось отправить за несколько запусков
+	MAX_DELAY = time.Hour
+)
+
+// Итог отправки архива в Result.Status
+const (
+	STATUS_UPLOADED = "uploaded"
+	// STATUS_EXISTS - такой же объект уже был в хранилище, например после сбоя до удаления из очереди
+	STATUS_EXISTS = "exists"
+	STATUS_FAILED = "failed"
+	// STATUS_MISSING - архива уже нет на диске: его удалили или объединили при уплотнении
+	STATUS_MISSING = "missing"
+)
+
+var (
+	// ErrNotFound возвращает Target.Stat, если объекта нет в хранилище
+	ErrNotFound = errors.New("объекта нет в хранилище")
+	// ErrLocked - очередь уже отправляет другой процесс
+	ErrLocked = errors.New("отправка архивов уже выполняется другим процессом")
+)
+
+// Object - объект в хранилище
+type Object struct {
+	Size   int64
+	SHA256 string
+}
+
+// Target - хранилище, в которое отправляются архивы: S3 или Collector
+type Target interface {
+	// String возвращает адрес хранилища для сообщений, например s3://bucket/prefix
+	String() string
+	// Stat возвращает объект key или ErrNotFound
+	Stat(ctx context.Context, key string) (Object, error)
+	// Upload отправляет file в объект item.Key и перезаписывает объект с SHA-256 из item.Replaces.
+	// Состояние многочастной загрузки хранится в item и сохраняется вызовом save после каждой
+	// части, чтобы прерванная загрузка продолжилась
+	Upload(ctx context.Context, item *Item, file *os.File, save func() error) error
+}
+
+// Part - отправленная часть многочастной загрузки
+type Part struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+// Item - архив в очереди отправки
+type Item struct {
+	Path   string    `json:"path"`
+	Key    string    `json:"key"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"`
+	Added  time.Time `json:"added"`
+	// Attempts - запуски, в которых архив не удалось отправить
+	Attempts    int       `json:"attempts,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	// UploadID, PartSize и Parts - начатая многочастная загрузка S3
+	UploadID string `json:"upload_id,omitempty"`
+	PartSize int64  `json:"part_size,omitempty"`
+	Parts    []Part `json:"parts,omitempty"`
+	// Offset - сколько байт архива принял коллектор
+	Offset int64 `json:"offset,omitempty"`
+	// Replaces - SHA-256 прежнего содержимого архива, если он изменился в очереди. Объект
+	// с такой суммой - это этот же архив, отправленный раньше, и его можно перезаписать
+	Replaces []string `json:"replaces,omitempty"`
+}
+
+// Result - итог отправки архива
+type Result struct {
+	Path   string `json:"path"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Attempts и NextAttempt - у неотправленного архива
+	Attempts    int       `json:"attempts,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+	// Deleted - локальный архив удален после подтверждения хранилищем
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Stats - итоги отправки очереди
+type Stats struct {
+	Target  string   `json:"target"`
+	Results []Result `json:"results"`
+	// Deferred - архивы, следующая попытка отправить которые еще не наступила
+	Deferred int `json:"deferred"`
+	// Bytes - отправлено байт
+	Bytes int64 `json:"bytes"`
+}
+
+// Failed возвращает число архивов, которые не удалось отправить
+func (s Stats) Failed() int {
+	failed := 0
+	for _, result := range s.Results {
+		if result.Status == STATUS_FAILED {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Options - настройки отправки
+type Options struct {
+	Target Target
+	// OutboxDir - директория очереди: по файлу JSON на архив
+	OutboxDir string
+	// ArchiveDir - директория архивов: ключ объекта - путь архива относительно нее
+	ArchiveDir string
+	// KeyPrefix добавляется к ключам, например имя профиля и /
+	KeyPrefix string
+	// DeleteLocal - удалять локальный архив, когда хранилище подтвердило размер и контрольную сумму
+	DeleteLocal bool
+	// Retries - попыток за запуск, по умолчанию DEFAULT_RETRIES
+	Retries int
+	// Backoff - пауза перед повторной попыткой, по умолчанию DEFAULT_BACKOFF
+	Backoff time.Duration
+	// Clock - источник текущего времени, по умолчанию time.Now
+	Clock func() time.Time
+}
+
+// ShipOptions - настройки одной отправки очереди
+type ShipOptions struct {
+	// Force - отправить и архивы, следующая попытка для которых еще не наступила
+	Force bool
+}
+
+// Shipper отправляет архивы из очереди в хранилище
+type Shipper struct {
+	opts Options
+}
+
+// New создает отправитель архивов
+func New(opts Options) *Shipper {
+	if opts.Retries <= 0 {
+		opts.Retries = DEFAULT_RETRIES
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DEFAULT_BACKOFF
+	}
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	return &Shipper{opts: opts}
+}
+
+// Target возвращает хранилище
+func (s *Shipper) Target() Target {
+	return s.opts.Target
+}
+
+// Enqueue ставит архивы в очередь отправки, вычисляя их размер и SHA-256. Архивы, которые
+// уже в очереди, не меняются: их загрузка могла начаться. Блокировка не нужна - каждый архив
+// записывается в очередь отдельным файлом одной операцией
+func (s *Shipper) Enqueue(paths ...string) error {
+	if err := os.MkdirAll(s.opts.OutboxDir, 0755); err != nil {
+		return fmt.Errorf("ошибка создания очереди отправки %s: %v", s.opts.OutboxDir, err)
+	}
+	for _, path := range paths {
+		item := Item{Path: path, Key: s.key(path), Added: s.opts.Clock()}
+		if _, err := os.Stat(s.itemFile(item)); err == nil {
+			continue
+		}
+		size, sum, err := checksum(path)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения архива %s: %v", path, err)
+		}
+		item.Size, item.SHA256 = size, sum
+		if err := s.save(&item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueAll ставит в очередь все сжатые архивы директории архивов и возвращает их число
+func (s *Shipper) EnqueueAll() (int, error) {
+	var paths []string
+	err := filepath.WalkDir(s.opts.ArchiveDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			if strings.HasPrefix(name, ".") && path != s.opts.ArchiveDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Несжатые архивы после сбоя еще будут сжаты и переименованы
+		if strings.HasPrefix(name, "access_") && !strings.HasSuffix(name, ".log") && !strings.HasSuffix(name, ".tmp") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ошибка чтения директории архивов %s: %v", s.opts.ArchiveDir, err)
+	}
+	return len(paths), s.Enqueue(paths...)
+}
+
+// Queue возвращает архивы в очереди, начиная с самых старых
+func (s *Shipper) Queue() ([]Item, error) {
+	files, err := filepath.Glob(filepath.Join(s.opts.OutboxDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return items, fmt.Errorf("ошибка чтения очереди отправки %s: %v", file, err)
+		}
+		var item Item
+		if err := json.Unmarshal(data, &item); err != nil {
+			return items, fmt.Errorf("ошибка разбора очереди отправки %s: %v", file, err)
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].Added.Equal(items[j].Added) {
+			return items[i].Added.Before(items[j].Added)
+		}
+		return items[i].Path < items[j].Path
+	})
+	return items, nil
+}
+
+// Ship отправляет архивы из очереди. Архив, который не удалось отправить за Options.Retries
+// попыток, остается в очереди, а следующая попытка откладывается, с каждым запуском дольше,
+// но не больше MAX_DELAY. Ошибки отдельных архивов - в Stats.Results, а ошибка возвращается,
+// только если очередь не удалось прочитать, запуск прерван или очередь уже отправляет
+// другой процесс (ErrLocked)
+func (s *Shipper) Ship(ctx context.Context, opts ShipOptions) (Stats, error) {
+	stats := Stats{Target: s.opts.Target.String(), Results: []Result{}}
+
+	if err := os.MkdirAll(s.opts.OutboxDir, 0755); err != nil {
+		return stats, fmt.Errorf("ошибка создания очереди отправки %s: %v", s.opts.OutboxDir, err)
+	}
+	lockFile := filepath.Join(s.opts.OutboxDir, ".lock")
+	unlock, err := archiver.AcquireLock(lockFile)
+	if err != nil {
+		if err == archiver.ErrLocked {
+			return stats, ErrLocked
+		}
+		return stats, fmt.Errorf("ошибка блокировки %s: %v", lockFile, err)
+	}
+	defer unlock()
+
+	items, err := s.Queue()
+	if err != nil {
+		return stats, err
+	}
+	for i := range items {
+		if err := ctx.Err(); err != nil {
+			return stats, fmt.Errorf("отправка прервана: %w", err)
+		}
+		item := &items[i]
+		if !opts.Force && item.NextAttempt.After(s.opts.Clock()) {
+			stats.Deferred++
+			continue
+		}
+		result := s.ship(ctx, item)
+		if result.Status == STATUS_UPLOADED {
+			stats.Bytes += item.Size
+		}
+		stats.Results = append(stats.Results, result)
+	}
+	return stats, ctx.Err()
+}
+
+// ship отправляет архив с повторными попытками и убирает его из очереди после подтверждения
+func (s *Shipper) ship(ctx context.Context, item *Item) Result {
+	result := Result{Path: item.Path, Key: item.Key, Size: item.Size}
+
+	file, err := os.Open(item.Path)
+	if os.IsNotExist(err) {
+		result.Status = STATUS_MISSING
+		if err := s.remove(item); err != nil {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	if err == nil {
+		defer file.Close()
+		for attempt := 0; attempt < s.opts.Retries; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(s.opts.Backoff << (attempt - 1)):
+				}
+			}
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			if result.Status, err = s.upload(ctx, item, file); err == nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		item.Attempts++
+		item.LastError = err.Error()
+		item.NextAttempt = s.opts.Clock().Add(min(time.Minute<<min(item.Attempts-1, 10), MAX_DELAY))
+		if saveErr := s.save(item); saveErr != nil {
+			err = fmt.Errorf("%v; %v", err, saveErr)
+		}
+		result.Status, result.Error = STATUS_FAILED, err.Error()
+		result.Attempts, result.NextAttempt = item.Attempts, item.NextAttempt
+		return result
+	}
+
+	if s.opts.DeleteLocal {
+		file.Close()
+		if err := os.Remove(item.Path); err != nil {
+			result.Error = fmt.Sprintf("архив отправлен, но не удален: %v", err)
+		} else {
+			result.Deleted = true
+		}
+	}
+	if err := s.remove(item); err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// upload отправляет архив и проверяет, что хранилище получило его целиком. Если архив изменился
+// после постановки в очередь (backfill --merge), он отправляется заново с новой контрольной суммой
+// и перезаписывает свою прежнюю версию в хранилище. Объект с чужим содержимым не перезаписывается
+func (s *Shipper) upload(ctx context.Context, item *Item, file *os.File) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	size, sum, err := checksumReader(file)
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения архива: %v", err)
+	}
+	if size != item.Size || sum != item.SHA256 {
+		if !slices.Contains(item.Replaces, item.SHA256) {
+			item.Replaces = append(item.Replaces, item.SHA256)
+		}
+		item.Size, item.SHA256 = size, sum
+		item.UploadID, item.PartSize, item.Parts, item.Offset = "", 0, nil, 0
+		if err := s.save(item); err != nil {
+			return "", err
+		}
+	}
+
+	remote, err := s.opts.Target.Stat(ctx, item.Key)
+	switch {
+	case err == nil && remote == (Object{Size: item.Size, SHA256: item.SHA256}):
+		return STATUS_EXISTS, nil
+	case err == nil && !slices.Contains(item.Replaces, remote.SHA256):
+		return "", fmt.Errorf("в хранилище уже есть другой объект %s (sha256 %q, %d байт): архив не перезаписан", item.Key, remote.SHA256, remote.Size)
+	case err != nil && !errors.Is(err, ErrNotFound):
+		return "", err
+	}
+
+	if err := s.opts.Target.Upload(ctx, item, file, func() error { return s.save(item) }); err != nil {
+		return "", err
+	}
+	remote, err = s.opts.Target.Stat(ctx, item.Key)
+	if err != nil {
+		return "", fmt.Errorf("не удалось проверить отправленный архив: %v", err)
+	}
+	if remote.Size != item.Size || remote.SHA256 != item.SHA256 {
+		return "", fmt.Errorf("хранилище подтвердило другой объект: %d байт, sha256 %q вместо %d байт, %s",
+			remote.Size, remote.SHA256, item.Size, item.SHA256)
+	}
+	return STATUS_UPLOADED, nil
+}
+
+// key возвращает ключ объекта для архива: путь относительно директории архивов
+func (s *Shipper) key(path string) string {
+	rel, err := filepath.Rel(s.opts.ArchiveDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	return s.opts.KeyPrefix + filepath.ToSlash(rel)
+}
+
+// itemFile возвращает файл очереди архива: 2026/10/24/access_20261024.log.gz -> 2026_10_24_access_20261024.log.gz.json
+func (s *Shipper) itemFile(item Item) string {
+	return filepath.Join(s.opts.OutboxDir, strings.ReplaceAll(item.Key, "/", "_")+".json")
+}
+
+// save записывает архив в очередь через временный файл, чтобы сбой не оставил его половину
+func (s *Shipper) save(item *Item) error {
+	data, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := s.itemFile(*item)
+	if err := os.WriteFile(file+".tmp", append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("ошибка записи очереди отправки %s: %v", file, err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		os.Remove(file + ".tmp")
+		return fmt.Errorf("ошибка записи очереди отправки %s: %v", file, err)
+	}
+	return nil
+}
+
+// remove убирает архив из очереди
+func (s *Shipper) remove(item *Item) error {
+	if err := os.Remove(s.itemFile(*item)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ошибка удаления из очереди отправки: %v", err)
+	}
+	return nil
+}
+
+func checksum(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	return checksumReader(file)
+}
+
+func checksumReader(r io.Reader) (int64, string, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,41 @@
+// Package version хранит версию программы, которая задается при сборке:
+//
+//	go build -ldflags "-X xui_log_archiver/version.Version=1.2.0 -X xui_log_archiver/version.Commit=$(git rev-parse --short HEAD) -X xui_log_archiver/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Значения подставляются через -ldflags при сборке
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info описывает собранную программу
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get возвращает информацию о текущей сборке
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// String возвращает версию одной строкой
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, собрано %s, %s, %s)", i.Version, i.Commit, i.BuildDate, i.GoVersion, i.Platform)
+}
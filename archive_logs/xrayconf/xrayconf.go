@@ -0,0 +1,137 @@
+// Package xrayconf находит конфигурацию Xray, которую генерирует x-ui, и определяет,
+// куда на самом деле пишутся access и error логи
+package xrayconf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CONFIG_FILE - конфигурация, которую x-ui собирает из шаблона xrayTemplateConfig
+	// (хранится в базе x-ui) при каждом запуске Xray. Путь относительно директории x-ui
+	CONFIG_FILE = "bin/config.json"
+
+	// STANDALONE_CONFIG - конфигурация Xray, установленного отдельно от x-ui
+	STANDALONE_CONFIG = "/usr/local/etc/xray/config.json"
+
+	// DISABLED - значение log.access или log.error, отключающее лог
+	DISABLED = "none"
+
+	// STDOUT и STDERR - куда Xray пишет лог с пустым путем. x-ui читает эти потоки
+	// сам и в файл их не сохраняет
+	STDOUT = "stdout"
+	STDERR = "stderr"
+)
+
+// ErrNotFound - ни одной конфигурации Xray не найдено
+var ErrNotFound = errors.New("конфигурация Xray не найдена")
+
+// Logs - настройки логирования из раздела log конфигурации Xray
+type Logs struct {
+	// ConfigFile - разобранная конфигурация
+	ConfigFile string `json:"config_file"`
+	// Access - абсолютный путь access лога, "none" или "stdout"
+	Access string `json:"access"`
+	// Error - абсолютный путь error лога, "none" или "stderr"
+	Error    string `json:"error"`
+	LogLevel string `json:"loglevel"`
+	// DNSLog - пишет ли Xray в access лог запросы DNS, из которых строится дашборд
+	DNSLog   bool     `json:"dns_log"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// AccessFile возвращает путь access лога, если Xray пишет его в файл
+func (l Logs) AccessFile() (string, bool) {
+	return l.Access, filepath.IsAbs(l.Access)
+}
+
+// xrayConfig - часть конфигурации Xray, которая нужна архиватору
+type xrayConfig struct {
+	Log *struct {
+		Access   string `json:"access"`
+		Error    string `json:"error"`
+		LogLevel string `json:"loglevel"`
+		DNSLog   bool   `json:"dnsLog"`
+	} `json:"log"`
+}
+
+// Candidates возвращает пути, где ищется конфигурация Xray экземпляра x-ui в dir
+func Candidates(dir string, standalone bool) []string {
+	candidates := []string{filepath.Join(dir, CONFIG_FILE)}
+	if standalone {
+		candidates = append(candidates, STANDALONE_CONFIG)
+	}
+	return candidates
+}
+
+// Discover разбирает первую существующую конфигурацию из candidates.
+// Относительные пути логов отсчитываются от dir - рабочей директории x-ui
+func Discover(dir string, candidates ...string) (Logs, error) {
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		return Parse(path, dir)
+	}
+	return Logs{}, ErrNotFound
+}
+
+// Parse читает раздел log конфигурации Xray path
+func Parse(path, dir string) (Logs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Logs{}, fmt.Errorf("ошибка чтения конфигурации Xray %s: %v", path, err)
+	}
+
+	var cfg xrayConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Logs{}, fmt.Errorf("ошибка разбора конфигурации Xray %s: %v", path, err)
+	}
+
+	logs := Logs{ConfigFile: path, Access: STDOUT, Error: STDERR, LogLevel: "warning"}
+	if cfg.Log == nil {
+		logs.Warnings = append(logs.Warnings, "в конфигурации нет раздела log: access лог не пишется в файл")
+		return logs, nil
+	}
+
+	logs.Access = logPath(cfg.Log.Access, STDOUT, dir)
+	logs.Error = logPath(cfg.Log.Error, STDERR, dir)
+	if cfg.Log.LogLevel != "" {
+		logs.LogLevel = strings.ToLower(cfg.Log.LogLevel)
+	}
+	logs.DNSLog = cfg.Log.DNSLog
+
+	switch logs.Access {
+	case DISABLED:
+		logs.Warnings = append(logs.Warnings, "access лог отключен (log.access = \"none\"): архивировать нечего, "+
+			"включите его в настройках Xray панели x-ui")
+	case STDOUT:
+		logs.Warnings = append(logs.Warnings, "access лог не задан (log.access пуст): Xray пишет его в stdout, а не в файл")
+	}
+	if logs.LogLevel == DISABLED {
+		logs.Error = DISABLED
+	}
+	if !logs.DNSLog && logs.Access != DISABLED {
+		logs.Warnings = append(logs.Warnings, "запросы DNS не пишутся в access лог (log.dnsLog = false): дашборд DNS будет пустым")
+	}
+	return logs, nil
+}
+
+// logPath приводит путь лога из конфигурации к абсолютному
+func logPath(value, empty, dir string) string {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return empty
+	case strings.EqualFold(value, DISABLED):
+		return DISABLED
+	case filepath.IsAbs(value):
+		return filepath.Clean(value)
+	}
+	return filepath.Join(dir, value)
+}
@@ -0,0 +1,142 @@
+// Package xraylog разбирает строки access.log, который пишет Xray
+package xraylog
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Kind обозначает тип записи лога
+type Kind int
+
+const (
+	// KindAccess - запись о соединении (from ... accepted ...)
+	KindAccess Kind = iota
+	// KindDNS - запись DNS-резолвера (got answer / cache HIT)
+	KindDNS
+)
+
+// Entry представляет одну разобранную строку лога
+type Entry struct {
+	Time     time.Time
+	Kind     Kind
+	SourceIP string
+	Email    string
+	Network  string
+	Domain   string
+	Port     string
+	Status   string
+	Inbound  string
+	Outbound string
+}
+
+// Client возвращает идентификатор клиента: email, если он есть, иначе IP
+func (e Entry) Client() string {
+	if e.Email != "" {
+		return e.Email
+	}
+	if e.SourceIP != "" {
+		return e.SourceIP
+	}
+	return "unknown"
+}
+
+var (
+	// 2025/01/20 10:11:12.123456 from tcp:1.2.3.4:51234 accepted tcp:example.com:443 [in >> out] email: user1
+	accessRe = regexp.MustCompile(`^from (?:(?:tcp|udp):)?(\S+) (accepted|rejected) (?:(tcp|udp):)?(\S+?)(?::(\d+))?(?: \[([^\]]*)\])?(?: email: (\S+))?\s*$`)
+	// ... got answer: example.com. -> [1.2.3.4] 10ms / cache HIT: example.com -> [...]
+	dnsRe = regexp.MustCompile(`(?:got answer|cache HIT|cache OPTIMISTE): (\S+?)\.? `)
+)
+
+// timeLayouts - форматы времени, которые встречаются в логах разных версий Xray
+var timeLayouts = []string{
+	"2006/01/02 15:04:05.999999",
+	"2006/01/02 15:04:05",
+}
+
+// ParseTime разбирает метку времени в начале строки лога и возвращает остаток строки
+func ParseTime(line string) (time.Time, string, bool) {
+	return ParseTimeIn(line, time.Local)
+}
+
+// ParseTimeIn - ParseTime для строк, записанных в зоне loc
+func ParseTimeIn(line string, loc *time.Location) (time.Time, string, bool) {
+	// Дата и время занимают первые два поля строки
+	first := strings.IndexByte(line, ' ')
+	if first < 0 {
+		return time.Time{}, "", false
+	}
+	second := strings.IndexByte(line[first+1:], ' ')
+	if second < 0 {
+		return time.Time{}, "", false
+	}
+	stamp := line[:first+1+second]
+	rest := line[first+2+second:]
+
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, stamp, loc); err == nil {
+			return t, rest, true
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// Parse разбирает одну строку лога. Второе значение false, если строка не распознана
+func Parse(line string) (Entry, bool) {
+	line = strings.TrimSpace(line)
+	t, rest, ok := ParseTime(line)
+	if !ok {
+		return Entry{}, false
+	}
+
+	if m := accessRe.FindStringSubmatch(rest); m != nil {
+		e := Entry{
+			Time:     t,
+			Kind:     KindAccess,
+			SourceIP: stripPort(m[1]),
+			Status:   m[2],
+			Network:  m[3],
+			Domain:   strings.ToLower(m[4]),
+			Port:     m[5],
+			Email:    m[7],
+		}
+		if m[6] != "" {
+			e.Inbound, e.Outbound = splitRoute(m[6])
+		}
+		return e, true
+	}
+
+	if m := dnsRe.FindStringSubmatch(rest); m != nil {
+		return Entry{
+			Time:   t,
+			Kind:   KindDNS,
+			Domain: strings.ToLower(m[1]),
+		}, true
+	}
+
+	return Entry{}, false
+}
+
+// stripPort отрезает порт от адреса вида 1.2.3.4:5555 или [::1]:5555
+func stripPort(addr string) string {
+	if strings.HasPrefix(addr, "[") {
+		if end := strings.IndexByte(addr, ']'); end > 0 {
+			return addr[1:end]
+		}
+	}
+	if i := strings.LastIndexByte(addr, ':'); i > 0 && strings.Count(addr, ":") == 1 {
+		return addr[:i]
+	}
+	return addr
+}
+
+// splitRoute разбирает "[inbound >> outbound]" и старый формат "[inbound -> outbound]"
+func splitRoute(route string) (string, string) {
+	for _, sep := range []string{" >> ", " -> "} {
+		if i := strings.Index(route, sep); i >= 0 {
+			return route[:i], route[i+len(sep):]
+		}
+	}
+	return route, ""
+}
@@ -0,0 +1,52 @@
+package xraylog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	at := time.Date(2026, 5, 4, 10, 11, 12, 123456000, time.Local)
+	cases := []struct {
+		name, line string
+		ok         bool
+		want       Entry
+	}{
+		{"accepted tcp с email",
+			"2026/05/04 10:11:12.123456 from tcp:1.2.3.4:51234 accepted tcp:Example.com:443 [in >> direct] email: user1",
+			true, Entry{Time: at, Kind: KindAccess, SourceIP: "1.2.3.4", Email: "user1", Network: "tcp",
+				Domain: "example.com", Port: "443", Status: "accepted", Inbound: "in", Outbound: "direct"}},
+		{"rejected udp без email",
+			"2026/05/04 10:11:12.123456 from udp:1.2.3.4:51234 rejected udp:8.8.8.8:53 [in -> block]",
+			true, Entry{Time: at, Kind: KindAccess, SourceIP: "1.2.3.4", Network: "udp",
+				Domain: "8.8.8.8", Port: "53", Status: "rejected", Inbound: "in", Outbound: "block"}},
+		{"без сети и маршрута",
+			"2026/05/04 10:11:12.123456 from [2001:db8::1]:51234 accepted example.com:443 email: user2",
+			true, Entry{Time: at, Kind: KindAccess, SourceIP: "2001:db8::1", Email: "user2",
+				Domain: "example.com", Port: "443", Status: "accepted"}},
+		{"ответ DNS",
+			"2026/05/04 10:11:12.123456 app/dns: got answer: Example.com. -> [93.184.216.34] 10ms",
+			true, Entry{Time: at, Kind: KindDNS, Domain: "example.com"}},
+		{"без метки времени",
+			"from tcp:1.2.3.4:51234 accepted tcp:example.com:443 [in >> direct] email: user1", false, Entry{}},
+		{"неизвестная строка",
+			"2026/05/04 10:11:12.123456 [Info] core: Xray 1.8.4 started", false, Entry{}},
+		{"без статуса",
+			"2026/05/04 10:11:12.123456 from tcp:1.2.3.4:51234 tcp:example.com:443", false, Entry{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := Parse(c.line)
+			if ok != c.ok {
+				t.Fatalf("разобрана: %v, ожидалось %v", ok, c.ok)
+			}
+			if !got.Time.Equal(c.want.Time) {
+				t.Errorf("время %v, ожидалось %v", got.Time, c.want.Time)
+			}
+			got.Time = c.want.Time
+			if got != c.want {
+				t.Errorf("запись %+v, ожидалось %+v", got, c.want)
+			}
+		})
+	}
+}
@@ -136,6 +136,21 @@ if [[ $skip_commit == false ]]; then
     fi
 fi
 
+# Собираем бинарник релиза с версией, коммитом и датой сборки (видны в xui_log_archiver version)
+echo -e "${YELLOW}🔨 Собираем xui_log_archiver $new_tag...${NC}"
+build_dir=$(mktemp -d)
+binary="$build_dir/xui_log_archiver"
+ldflags="-X xui_log_archiver/version.Version=$new_tag"
+ldflags="$ldflags -X xui_log_archiver/version.Commit=$(git rev-parse --short HEAD)"
+ldflags="$ldflags -X xui_log_archiver/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+(cd archive_logs && go build -ldflags "$ldflags" -o "$binary" .)
+
+if [[ $? -ne 0 ]]; then
+    echo -e "${RED}❌ Ошибка сборки, тег не создан${NC}"
+    exit 1
+fi
+echo -e "${GREEN}✅ $("$binary" version)${NC}"
+
 echo -e "${YELLOW}🏷️  Создаем тег...${NC}"
 git tag -a "$new_tag" -m "$tag_message"
 
@@ -194,7 +209,7 @@ else
         echo -e "${YELLOW}💡 Выполните: gh auth login${NC}"
     else
         # Создаем релиз
-        gh release create "$new_tag" --title "$new_tag" --notes "$tag_message"
+        gh release create "$new_tag" "$binary" --title "$new_tag" --notes "$tag_message"
         
         if [[ $? -eq 0 ]]; then
             echo -e "${GREEN}✅ GitHub Release $new_tag успешно создан!${NC}"